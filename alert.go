@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Target states reported in alerts.
+const (
+	stateUp   = "up"
+	stateDown = "down"
+)
+
+// alertEvent describes a target changing between up and down.
+type alertEvent struct {
+	Target string
+	URL    string
+	State  string
+	Time   time.Time
+
+	// Since is when the target went down; for an up event Duration is how
+	// long it was down.
+	Since    time.Time
+	Duration time.Duration
+
+	Failures int
+	Status   string
+	Error    string
+
+	// BurnRate is how fast the error budget is being spent, in multiples of
+	// the sustainable rate, when alerting on an SLO.
+	BurnRate float64
+}
+
+// notifier delivers alert events to people or systems.
+type notifier interface {
+	notify(ev alertEvent) error
+}
+
+// alerter turns probe results into down and up events. By default a target
+// is down after the configured number of consecutive failed probes and up
+// again after the first successful one. With a window, the decision is based
+// on the error rate over that sliding window instead, and with an SLO on the
+// error budget burn rate.
+type alerter struct {
+	alertPolicy
+	queues    []chan queuedEvent
+	delivered sync.WaitGroup
+
+	mu     sync.Mutex
+	states map[string]*alertState
+}
+
+type queuedEvent struct {
+	ev     alertEvent
+	logger *log.Logger
+}
+
+// alertQueueSize bounds how many events may wait for a slow notifier.
+const alertQueueSize = 64
+
+// alertDrainTimeout bounds how long the events still queued at exit may
+// take to be delivered.
+const alertDrainTimeout = 10 * time.Second
+
+type alertState struct {
+	down     bool
+	since    time.Time
+	failures int
+
+	// recent holds the probes within the sliding window, oldest first.
+	recent []windowProbe
+}
+
+type windowProbe struct {
+	start time.Time
+	ok    bool
+}
+
+// alertPolicy holds the settings deciding when a target is down.
+type alertPolicy struct {
+	after     int
+	window    time.Duration
+	errorRate float64
+
+	// Burn-rate alerting against an availability SLO, in percent, where
+	// probes slower than sloLatency also count against the objective.
+	slo         float64
+	sloLatency  time.Duration
+	shortWindow time.Duration
+	longWindow  time.Duration
+	burnRate    float64
+}
+
+// newAlerter starts a delivery goroutine per notifier, so that each one
+// receives events in order without holding up probing.
+func newAlerter(policy alertPolicy, notifiers []notifier) *alerter {
+	if policy.after < 1 {
+		policy.after = 1
+	}
+	a := &alerter{
+		alertPolicy: policy,
+		states:      make(map[string]*alertState),
+	}
+	for _, n := range notifiers {
+		q := make(chan queuedEvent, alertQueueSize)
+		a.queues = append(a.queues, q)
+		a.delivered.Add(1)
+		go func(n notifier) {
+			defer a.delivered.Done()
+			for qe := range q {
+				if err := n.notify(qe.ev); err != nil {
+					qe.logger.Printf("ERROR: alert: %v", err)
+				}
+			}
+		}(n)
+	}
+	return a
+}
+
+// Write updates the target state with a probe result and sends an event
+// to every notifier when the state changes. Probes cancelled by the end of
+// the run are ignored, as they say nothing about the target.
+func (a *alerter) Write(t Target, rec Record) error {
+	if rec.Err != nil && classifyError(rec.Err) == errCanceled {
+		return nil
+	}
+	ev, ok := a.transition(t, rec)
+	if !ok {
+		return nil
+	}
+
+	logger := targetLogger(t)
+	logger.Printf("ALERT: %s is %s", ev.Target, ev.State)
+	for _, q := range a.queues {
+		select {
+		case q <- queuedEvent{ev, logger}:
+		default:
+			logger.Printf("ERROR: alert: notifier queue full, dropping %s event", ev.State)
+		}
+	}
+	return nil
+}
+
+func (a *alerter) Flush(t Target, s Summary) error {
+	return nil
+}
+
+// Close delivers the events still queued, giving up after
+// alertDrainTimeout.
+func (a *alerter) Close() error {
+	for _, q := range a.queues {
+		close(q)
+	}
+	done := make(chan struct{})
+	go func() {
+		a.delivered.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-time.After(alertDrainTimeout):
+		return fmt.Errorf("alert: notifiers still busy after %s, events dropped", alertDrainTimeout)
+	}
+}
+
+func (a *alerter) transition(t Target, rec Record) (alertEvent, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	name := targetName(t)
+	st, ok := a.states[name]
+	if !ok {
+		st = &alertState{}
+		a.states[name] = st
+	}
+
+	ev := alertEvent{Target: name, URL: t.URL, Time: time.Now()}
+	ev.Status = rec.Status
+	switch {
+	case rec.Err != nil:
+		ev.Error = rec.Err.Error()
+	case len(rec.Failures) > 0:
+		ev.Error = rec.Failures[0]
+	case a.slo > 0 && !a.sloGood(rec):
+		ev.Error = fmt.Sprintf("took %s, above the %s objective", formatDuration(rec.Total), formatDuration(a.sloLatency))
+	}
+
+	var failing bool
+	switch {
+	case a.slo > 0:
+		failing, ev.BurnRate = a.burnFailing(st, rec)
+	case a.window > 0:
+		failing = a.windowFailing(st, rec)
+	default:
+		failing = a.consecutiveFailing(st, rec)
+	}
+
+	switch {
+	case failing && !st.down:
+		st.down = true
+		ev.State, ev.Since, ev.Failures = stateDown, st.since, st.failures
+		return ev, true
+	case !failing && st.down:
+		st.down = false
+		ev.State, ev.Since, ev.Duration = stateUp, st.since, ev.Time.Sub(st.since).Round(time.Second)
+		return ev, true
+	}
+	return ev, false
+}
+
+// consecutiveFailing counts failed probes in a row and reports whether they
+// reached the alert threshold.
+func (a *alerter) consecutiveFailing(st *alertState, rec Record) bool {
+	if probeOK(rec) {
+		st.failures = 0
+		return false
+	}
+	st.failures++
+	if st.failures == 1 && !st.down {
+		st.since = rec.StartTime
+	}
+	return st.failures >= a.after
+}
+
+// windowFailing reports whether the share of failed probes within the
+// sliding window exceeds the error rate. Going down also needs at least the
+// alert threshold of failures in the window, so a single failure after
+// startup does not count as 100% errors.
+func (a *alerter) windowFailing(st *alertState, rec Record) bool {
+	st.recent = append(st.recent, windowProbe{start: rec.StartTime, ok: probeOK(rec)})
+	cutoff := time.Now().Add(-a.window)
+	for len(st.recent) > 0 && st.recent[0].start.Before(cutoff) {
+		st.recent = st.recent[1:]
+	}
+
+	st.failures = 0
+	var firstFailure time.Time
+	for _, p := range st.recent {
+		if !p.ok {
+			if st.failures == 0 {
+				firstFailure = p.start
+			}
+			st.failures++
+		}
+	}
+	if len(st.recent) == 0 {
+		return false
+	}
+	rate := float64(st.failures) / float64(len(st.recent)) * 100
+
+	if st.down {
+		return rate > a.errorRate
+	}
+	st.since = firstFailure
+	return st.failures >= a.after && rate > a.errorRate
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier keeps the events it is notified of.
+type recordingNotifier struct {
+	mu     sync.Mutex
+	events []alertEvent
+}
+
+func (n *recordingNotifier) notify(ev alertEvent) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, ev)
+	return nil
+}
+
+// states returns the state of each event, in order.
+func (n *recordingNotifier) states() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var states []string
+	for _, ev := range n.events {
+		states = append(states, ev.State)
+	}
+	return states
+}
+
+func TestAlerterConsecutive(t *testing.T) {
+	ok := Record{Responded: true, StatusCode: 200}
+	failed := Record{Err: errors.New("connection refused")}
+	canceled := Record{Err: context.Canceled}
+	tests := []struct {
+		records []Record
+		states  []string
+	}{
+		{[]Record{failed, failed, ok}, nil},
+		{[]Record{failed, failed, failed}, []string{stateDown}},
+		{[]Record{failed, failed, failed, failed, ok, ok}, []string{stateDown, stateUp}},
+		{[]Record{failed, failed, ok, failed, failed}, nil},
+		// Probes cancelled at shutdown neither fail nor recover the target.
+		{[]Record{failed, failed, canceled, canceled}, nil},
+		{[]Record{failed, failed, failed, canceled}, []string{stateDown}},
+	}
+	for _, tt := range tests {
+		n := &recordingNotifier{}
+		a := newAlerter(alertPolicy{after: 3}, []notifier{n})
+		for _, rec := range tt.records {
+			a.Write(Target{Name: "api"}, rec)
+		}
+		if err := a.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := n.states(); !equalStrings(got, tt.states) {
+			t.Errorf("%d records: events %q, want %q", len(tt.records), got, tt.states)
+		}
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestAlerterWindow(t *testing.T) {
+	now := time.Now()
+	probe := func(ok bool, ago time.Duration) Record {
+		if ok {
+			return Record{StartTime: now.Add(-ago), Responded: true, StatusCode: 200}
+		}
+		return Record{StartTime: now.Add(-ago), Responded: true, StatusCode: 503}
+	}
+	s := time.Second
+	tests := []struct {
+		records []Record
+		states  []string
+	}{
+		// A single failure at startup is not enough.
+		{[]Record{probe(false, 10*s)}, nil},
+		{[]Record{probe(true, 10*s), probe(false, 9*s), probe(false, 8*s)}, []string{stateDown}},
+		// 2 failures out of 4 is not above 50%.
+		{[]Record{probe(true, 10*s), probe(false, 9*s), probe(true, 8*s), probe(false, 7*s)}, nil},
+		// Failures older than the window do not count.
+		{[]Record{probe(false, 2*time.Minute), probe(false, 10*s), probe(true, 9*s)}, nil},
+		{[]Record{probe(false, 10*s), probe(false, 9*s), probe(true, 8*s), probe(true, 7*s), probe(true, 6*s)}, []string{stateDown, stateUp}},
+	}
+	for i, tt := range tests {
+		n := &recordingNotifier{}
+		a := newAlerter(alertPolicy{after: 2, window: time.Minute, errorRate: 50}, []notifier{n})
+		for _, rec := range tt.records {
+			a.Write(Target{}, rec)
+		}
+		a.Close()
+		if got := n.states(); !equalStrings(got, tt.states) {
+			t.Errorf("%d: events %q, want %q", i, got, tt.states)
+		}
+	}
+}
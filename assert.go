@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// stringList is a flag.Value collecting every occurrence of a repeatable
+// flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// jsonExpectation is a parsed -expect-json expression such as
+// "status=ok" or "items.0.replicas>=3". Without an operator the path only
+// has to exist.
+type jsonExpectation struct {
+	expr  string
+	path  string
+	op    string
+	value string
+}
+
+var comparisonOps = []string{">=", "<=", "!=", "=", ">", "<"}
+
+func parseJSONExpectation(expr string) (jsonExpectation, error) {
+	e := jsonExpectation{expr: expr, path: expr}
+	if i := strings.IndexAny(expr, "!=<>"); i >= 0 {
+		for _, op := range comparisonOps {
+			if strings.HasPrefix(expr[i:], op) {
+				e.path, e.op, e.value = expr[:i], op, expr[i+len(op):]
+				break
+			}
+		}
+		if e.op == "" {
+			return e, fmt.Errorf("invalid json expectation %q", expr)
+		}
+	}
+	e.path = strings.TrimSpace(e.path)
+	e.value = strings.TrimSpace(e.value)
+	if e.path == "" {
+		return e, fmt.Errorf("json expectation %q has no path", expr)
+	}
+	return e, nil
+}
+
+// check evaluates the expectation against a decoded JSON document and
+// returns a description of the mismatch, or "" when it holds.
+func (e jsonExpectation) check(doc interface{}) string {
+	v, ok := lookupJSON(doc, e.path)
+	if !ok {
+		return fmt.Sprintf("%s: not found", e.expr)
+	}
+	if e.op == "" {
+		return ""
+	}
+
+	actual := jsonString(v)
+	var cmp int
+	n, isNum := v.(json.Number)
+	want, err := strconv.ParseFloat(e.value, 64)
+	if isNum && err == nil {
+		got, _ := n.Float64()
+		cmp = compareFloat(got, want)
+	} else {
+		cmp = strings.Compare(actual, e.value)
+	}
+
+	var pass bool
+	switch e.op {
+	case "=":
+		pass = cmp == 0
+	case "!=":
+		pass = cmp != 0
+	case ">":
+		pass = cmp > 0
+	case ">=":
+		pass = cmp >= 0
+	case "<":
+		pass = cmp < 0
+	case "<=":
+		pass = cmp <= 0
+	}
+	if pass {
+		return ""
+	}
+	return fmt.Sprintf("%s: got %s", e.expr, actual)
+}
+
+// lookupJSON follows a dotted path such as "data.items.0.name" through
+// objects and arrays.
+func lookupJSON(doc interface{}, path string) (interface{}, bool) {
+	v := doc
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			next, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = next
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func jsonString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return "null"
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+func compareFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// sizeRange is a parsed -expect-size value: "MIN-MAX", "MIN-", "-MAX" or an
+// exact size, all in bytes.
+type sizeRange struct {
+	min, max int64
+}
+
+func parseSizeRange(s string) (sizeRange, error) {
+	r := sizeRange{max: -1}
+	lo, hi := s, s
+	if i := strings.Index(s, "-"); i >= 0 {
+		lo, hi = s[:i], s[i+1:]
+	}
+
+	var err error
+	if lo != "" {
+		if r.min, err = strconv.ParseInt(lo, 10, 64); err != nil {
+			return r, fmt.Errorf("invalid size range %q", s)
+		}
+	}
+	if hi != "" {
+		if r.max, err = strconv.ParseInt(hi, 10, 64); err != nil {
+			return r, fmt.Errorf("invalid size range %q", s)
+		}
+	}
+	if r.max >= 0 && r.max < r.min {
+		return r, fmt.Errorf("invalid size range %q: max is below min", s)
+	}
+	return r, nil
+}
+
+func (r sizeRange) contains(n int64) bool {
+	return n >= r.min && (r.max < 0 || n <= r.max)
+}
+
+// sizeChange reports whether size differs from prev by more than pct
+// percent, returning the change in percent.
+func sizeChange(prev, size int64, pct float64) (float64, bool) {
+	if prev == 0 {
+		return 0, size != 0 && pct > 0
+	}
+	change := float64(size-prev) / float64(prev) * 100
+	return change, pct > 0 && math.Abs(change) > pct
+}
+
+// cacheStatusHeaders are set by CDNs and caching proxies to tell whether
+// they served the response from their cache.
+var cacheStatusHeaders = []string{"X-Cache", "X-Cache-Status", "CF-Cache-Status"}
+
+// checkNoCache asserts that a response forbids caching, through
+// Cache-Control or, lacking it, Pragma, and that no cache served it.
+func checkNoCache(h http.Header) []string {
+	var failures []string
+
+	if cc := h.Get("Cache-Control"); cc != "" {
+		ok := false
+		for _, d := range strings.Split(strings.ToLower(cc), ",") {
+			switch strings.Replace(strings.TrimSpace(d), " ", "", -1) {
+			case "no-store", "no-cache", "max-age=0":
+				ok = true
+			}
+		}
+		if !ok {
+			failures = append(failures, fmt.Sprintf("Cache-Control %q allows caching", cc))
+		}
+	} else if !strings.EqualFold(strings.TrimSpace(h.Get("Pragma")), "no-cache") {
+		failures = append(failures, "no Cache-Control or Pragma header forbids caching")
+	}
+
+	if age := h.Get("Age"); age != "" && age != "0" {
+		failures = append(failures, fmt.Sprintf("served from a cache (Age: %s)", age))
+	}
+	for _, name := range cacheStatusHeaders {
+		if v := h.Get(name); strings.Contains(strings.ToUpper(v), "HIT") {
+			failures = append(failures, fmt.Sprintf("served from a cache (%s: %s)", name, v))
+		}
+	}
+	return failures
+}
+
+// checkExpectations validates a response body against the target's
+// assertions and returns one message per failed assertion.
+func checkExpectations(t Target, contentType string, body []byte) []string {
+	var failures []string
+
+	if t.ExpectSize != "" {
+		r, _ := parseSizeRange(t.ExpectSize)
+		if !r.contains(int64(len(body))) {
+			failures = append(failures, fmt.Sprintf("size %d outside %s", len(body), t.ExpectSize))
+		}
+	}
+
+	if len(t.ExpectJSON) > 0 {
+		var doc interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&doc); err != nil {
+			// The other kinds of assertions still run.
+			failures = append(failures, fmt.Sprintf("body is not JSON: %v", err))
+		} else {
+			for _, expr := range t.ExpectJSON {
+				// Expressions were validated at startup.
+				e, _ := parseJSONExpectation(expr)
+				if msg := e.check(doc); msg != "" {
+					failures = append(failures, msg)
+				}
+			}
+		}
+	}
+
+	if len(t.ExpectXPath) > 0 {
+		// XPath applies to XML documents unless the server says it sent HTML.
+		root, err := parseDOM(body, strings.Contains(contentType, "html"))
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("body is not XML: %v", err))
+		} else {
+			for _, expr := range t.ExpectXPath {
+				p, _ := parseXPath(expr)
+				if len(p.find(root)) == 0 {
+					failures = append(failures, fmt.Sprintf("xpath %s: no match", expr))
+				}
+			}
+		}
+	}
+
+	if len(t.ExpectCSS) > 0 {
+		root, _ := parseDOM(body, true)
+		for _, expr := range t.ExpectCSS {
+			sel, _ := parseCSS(expr)
+			if len(sel.find(root)) == 0 {
+				failures = append(failures, fmt.Sprintf("css %s: no match", expr))
+			}
+		}
+	}
+
+	return failures
+}
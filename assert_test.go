@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const testJSON = `{
+	"status": "ok",
+	"version": "1.10.2",
+	"replicas": 3,
+	"ratio": 0.25,
+	"ready": true,
+	"owner": null,
+	"items": [{"name": "a", "tags": ["x"]}, {"name": "b"}]
+}`
+
+func decodeTestJSON(t *testing.T) interface{} {
+	t.Helper()
+	var doc interface{}
+	dec := json.NewDecoder(bytes.NewReader([]byte(testJSON)))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestJSONExpectation(t *testing.T) {
+	doc := decodeTestJSON(t)
+	tests := []struct {
+		expr string
+		pass bool
+	}{
+		{"status", true},
+		{"status=ok", true},
+		{"status = ok", true},
+		{"status!=ok", false},
+		{"replicas>=3", true},
+		{"replicas>3", false},
+		{"replicas<10", true},
+		{"ratio<=0.25", true},
+		{"ready=true", true},
+		{"owner=null", true},
+		{"items.1.name=b", true},
+		{"items.0.tags.0=x", true},
+		{"items.2", false},
+		{"items.x", false},
+		{"status.code", false},
+		{"missing", false},
+		// Strings compare as strings, numbers as numbers.
+		{"version>1.9", false},
+		{"replicas>=10", false},
+	}
+	for _, tt := range tests {
+		e, err := parseJSONExpectation(tt.expr)
+		if err != nil {
+			t.Errorf("parseJSONExpectation(%q): %v", tt.expr, err)
+			continue
+		}
+		if msg := e.check(doc); (msg == "") != tt.pass {
+			t.Errorf("%s: mismatch %q, want pass %v", tt.expr, msg, tt.pass)
+		}
+	}
+}
+
+func TestParseJSONExpectationErrors(t *testing.T) {
+	for _, expr := range []string{"", "=ok", " >= 3", "status!ok"} {
+		if _, err := parseJSONExpectation(expr); err == nil {
+			t.Errorf("parseJSONExpectation(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCheckExpectationsJSON(t *testing.T) {
+	target := Target{ExpectJSON: []string{"status=ok", "replicas>=5"}}
+	failures := checkExpectations(target, "application/json", []byte(testJSON))
+	if len(failures) != 1 || failures[0] != "replicas>=5: got 3" {
+		t.Errorf("failures %q, want the replicas mismatch", failures)
+	}
+	failures = checkExpectations(target, "text/html", []byte("<html></html>"))
+	if len(failures) != 1 || !strings.HasPrefix(failures[0], "body is not JSON") {
+		t.Errorf("failures %q, want a decoding failure", failures)
+	}
+}
+
+func TestParseSizeRange(t *testing.T) {
+	tests := []struct {
+		s   string
+		in  []int64
+		out []int64
+	}{
+		{"100-200", []int64{100, 150, 200}, []int64{99, 201}},
+		{"100-", []int64{100, 1 << 40}, []int64{0, 99}},
+		{"-200", []int64{0, 200}, []int64{201}},
+		{"42", []int64{42}, []int64{41, 43}},
+	}
+	for _, tt := range tests {
+		r, err := parseSizeRange(tt.s)
+		if err != nil {
+			t.Errorf("parseSizeRange(%q): %v", tt.s, err)
+			continue
+		}
+		for _, n := range tt.in {
+			if !r.contains(n) {
+				t.Errorf("%s does not contain %d", tt.s, n)
+			}
+		}
+		for _, n := range tt.out {
+			if r.contains(n) {
+				t.Errorf("%s contains %d", tt.s, n)
+			}
+		}
+	}
+	for _, s := range []string{"a-b", "10-x", "200-100", "1.5"} {
+		if _, err := parseSizeRange(s); err == nil {
+			t.Errorf("parseSizeRange(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestCheckExpectationsSize(t *testing.T) {
+	target := Target{ExpectSize: "1-5"}
+	if failures := checkExpectations(target, "text/plain", []byte("ok")); len(failures) != 0 {
+		t.Errorf("failures %q for a body in range", failures)
+	}
+	failures := checkExpectations(target, "text/plain", []byte("too long"))
+	if len(failures) != 1 || failures[0] != "size 8 outside 1-5" {
+		t.Errorf("failures %q, want the size mismatch", failures)
+	}
+}
+
+func TestSizeChange(t *testing.T) {
+	tests := []struct {
+		prev, size int64
+		pct        float64
+		change     float64
+		changed    bool
+	}{
+		{1000, 1000, 10, 0, false},
+		{1000, 1100, 10, 10, false},
+		{1000, 1101, 10, 10.1, true},
+		{1000, 500, 10, -50, true},
+		{0, 0, 10, 0, false},
+		{0, 10, 10, 0, true},
+		{1000, 2000, 0, 100, false},
+	}
+	for _, tt := range tests {
+		change, changed := sizeChange(tt.prev, tt.size, tt.pct)
+		if changed != tt.changed || math.Abs(change-tt.change) > 1e-9 {
+			t.Errorf("sizeChange(%d, %d, %v) = %v, %v, want %v, %v", tt.prev, tt.size, tt.pct, change, changed, tt.change, tt.changed)
+		}
+	}
+}
+
+func TestCheckNoCache(t *testing.T) {
+	tests := []struct {
+		header   http.Header
+		failures []string
+	}{
+		{http.Header{"Cache-Control": {"no-store"}}, nil},
+		{http.Header{"Cache-Control": {"private, max-age = 0"}}, nil},
+		{http.Header{"Cache-Control": {"No-Cache"}, "Age": {"0"}, "X-Cache": {"MISS"}}, nil},
+		{http.Header{"Pragma": {"no-cache"}}, nil},
+		{http.Header{"Cache-Control": {"public, max-age=300"}}, []string{`Cache-Control "public, max-age=300" allows caching`}},
+		{http.Header{}, []string{"no Cache-Control or Pragma header forbids caching"}},
+		{
+			http.Header{"Cache-Control": {"no-cache"}, "Age": {"12"}, "X-Cache": {"Hit from cloudfront"}},
+			[]string{"served from a cache (Age: 12)", "served from a cache (X-Cache: Hit from cloudfront)"},
+		},
+		{http.Header{"Cache-Control": {"no-cache"}, "Cf-Cache-Status": {"HIT"}}, []string{"served from a cache (CF-Cache-Status: HIT)"}},
+	}
+	for _, tt := range tests {
+		if got := checkNoCache(tt.header); !equalStrings(got, tt.failures) {
+			t.Errorf("checkNoCache(%v) = %q, want %q", tt.header, got, tt.failures)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// bannerProber connects to an SMTP or IMAP server and waits for its
+// greeting, whose arrival is reported as the time to first byte. With
+// -starttls the connection is then upgraded to TLS. smtps:// and imaps://
+// URLs use TLS from the start.
+type bannerProber struct {
+	t      Target
+	logger *log.Logger
+	dial   dialFunc
+}
+
+func (p *bannerProber) Probe(ctx context.Context) Record {
+	s := startProbe()
+	ctx = s.trace(ctx, p.logger, p.t)
+
+	conn, err := p.dial(ctx, "tcp", targetAddress(p.t))
+	if err != nil {
+		return s.fail(err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if implicitTLS(p.t) {
+		tc, err := s.handshake(ctx, p.t, conn)
+		if err != nil {
+			return s.fail(err)
+		}
+		conn = tc
+	}
+
+	s.phase.set(phaseServer)
+	c := textproto.NewConn(conn)
+	upgrade, bye := smtpStartTLS, "QUIT"
+	if p.t.Mode == modeIMAP {
+		upgrade, bye = imapStartTLS, "a2 LOGOUT"
+	}
+	if err := greeting(s, c, p.t.Mode); err != nil {
+		return s.fail(err)
+	}
+
+	if p.t.StartTLS {
+		s.phase.set(phaseRequest)
+		if err := upgrade(c); err != nil {
+			return s.fail(err)
+		}
+		tc, err := s.handshake(ctx, p.t, conn)
+		if err != nil {
+			return s.fail(err)
+		}
+		s.rec.Status += " (" + tlsVersionName(tc.ConnectionState().Version) + ")"
+		c = textproto.NewConn(tc)
+	}
+
+	s.phase.set(phaseRequest)
+	rec := s.done(p.t)
+	_ = c.PrintfLine("%s", bye)
+	return rec
+}
+
+// implicitTLS reports whether t is an smtps:// or imaps:// URL.
+func implicitTLS(t Target) bool {
+	return strings.HasPrefix(t.URL, "smtps://") || strings.HasPrefix(t.URL, "imaps://")
+}
+
+// greeting reads the banner of the server and records it as the status of
+// the probe. A server refusing the connection, with an SMTP code other than
+// 220 or an IMAP BYE, fails the probe.
+func greeting(s *probeState, c *textproto.Conn, mode string) error {
+	if mode == modeSMTP {
+		code, msg, err := c.ReadResponse(0)
+		if err != nil {
+			return err
+		}
+		s.greeted(fmt.Sprintf("%d %s", code, strings.SplitN(msg, "\n", 2)[0]))
+		s.rec.StatusCode = code
+		if code != 220 {
+			return fmt.Errorf("smtp: %d %s", code, msg)
+		}
+		return nil
+	}
+
+	line, err := c.ReadLine()
+	if err != nil {
+		return err
+	}
+	s.greeted(line)
+	if !strings.HasPrefix(line, "* OK") && !strings.HasPrefix(line, "* PREAUTH") {
+		return fmt.Errorf("imap: %s", line)
+	}
+	return nil
+}
+
+func (p *probeState) greeted(banner string) {
+	p.rec.TTFB = time.Since(p.rec.StartTime)
+	p.rec.Responded = true
+	p.rec.Status = banner
+}
+
+func smtpStartTLS(c *textproto.Conn) error {
+	id, err := c.Cmd("EHLO localhost")
+	if err != nil {
+		return err
+	}
+	c.StartResponse(id)
+	_, msg, err := c.ReadResponse(250)
+	c.EndResponse(id)
+	if err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	if !strings.Contains(strings.ToUpper(msg), "\nSTARTTLS") {
+		return fmt.Errorf("smtp: server does not offer STARTTLS")
+	}
+
+	id, err = c.Cmd("STARTTLS")
+	if err != nil {
+		return err
+	}
+	c.StartResponse(id)
+	defer c.EndResponse(id)
+	if _, _, err := c.ReadResponse(220); err != nil {
+		return fmt.Errorf("smtp: %w", err)
+	}
+	return nil
+}
+
+func imapStartTLS(c *textproto.Conn) error {
+	if err := c.PrintfLine("a1 STARTTLS"); err != nil {
+		return err
+	}
+	// Untagged responses may come before the tagged one.
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return err
+		}
+		if !strings.HasPrefix(line, "a1 ") {
+			continue
+		}
+		if !strings.HasPrefix(line, "a1 OK") {
+			return fmt.Errorf("imap: %s", line)
+		}
+		return nil
+	}
+}
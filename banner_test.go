@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bannerServer greets every connection with banner and answers each line
+// it reads with the next of replies.
+func bannerServer(t *testing.T, banner string, replies ...string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Write([]byte(banner))
+			r := bufio.NewReader(conn)
+			for _, reply := range replies {
+				if _, err := r.ReadString('\n'); err != nil {
+					break
+				}
+				conn.Write([]byte(reply))
+			}
+			ioutil.ReadAll(r)
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestBannerProbe(t *testing.T) {
+	tests := []struct {
+		mode, banner string
+		starttls     bool
+		replies      []string
+		status, err  string
+	}{
+		{modeSMTP, "220-mx.example.com ESMTP\r\n220 ready\r\n", false, nil, "220 mx.example.com ESMTP", ""},
+		{modeSMTP, "554 no service\r\n", false, nil, "554 no service", "smtp: 554 no service"},
+		{modeIMAP, "* OK [CAPABILITY IMAP4rev1] ready\r\n", false, nil, "* OK [CAPABILITY IMAP4rev1] ready", ""},
+		{modeIMAP, "* PREAUTH welcome\r\n", false, nil, "* PREAUTH welcome", ""},
+		{modeIMAP, "* BYE too many connections\r\n", false, nil, "* BYE too many connections", "imap: * BYE too many connections"},
+		{modeSMTP, "220 ready\r\n", true, []string{"250-mx.example.com\r\n250 SIZE 1000000\r\n"},
+			"220 ready", "smtp: server does not offer STARTTLS"},
+		{modeIMAP, "* OK ready\r\n", true, []string{"* CAPABILITY IMAP4rev1\r\na1 BAD unknown command\r\n"},
+			"* OK ready", "imap: a1 BAD unknown command"},
+	}
+	for _, tt := range tests {
+		addr := bannerServer(t, tt.banner, tt.replies...)
+		target := Target{URL: tt.mode + "://" + addr, Mode: tt.mode, StartTLS: tt.starttls, Timeout: Duration(5 * time.Second)}
+		prober, _ := newProber(target, log.New(ioutil.Discard, "", 0))
+		rec := prober.Probe(context.Background())
+		if rec.Status != tt.status || !rec.Responded || rec.TTFB <= 0 || (rec.Err == nil) != (tt.err == "") ||
+			(rec.Err != nil && !strings.HasPrefix(rec.Err.Error(), tt.err)) {
+			t.Errorf("%s %q: status %q, responded %v, ttfb %v, error %v, want %q, %q",
+				tt.mode, tt.banner, rec.Status, rec.Responded, rec.TTFB, rec.Err, tt.status, tt.err)
+		}
+	}
+}
+
+func TestImplicitTLS(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"smtps://mx.example.com", true},
+		{"imaps://mail.example.com:993", true},
+		{"smtp://mx.example.com", false},
+		{"mx.example.com:25", false},
+	}
+	for _, tt := range tests {
+		if got := implicitTLS(Target{URL: tt.url}); got != tt.want {
+			t.Errorf("implicitTLS(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+}
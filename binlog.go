@@ -0,0 +1,415 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// binlogKeep is how long the binlog sink keeps single probes before
+// compacting them into aggregates, set with -binlog-keep. Zero disables
+// compaction.
+var binlogKeep time.Duration
+
+// binlogBucket is the span of time aggregated into one compacted entry.
+const binlogBucket = time.Minute
+
+// binlogTiers coarsen aggregates as they age past -binlog-keep: per minute
+// for a week, then per hour and after 90 days per day, so that a run of
+// months stays small.
+var binlogTiers = []struct {
+	age    time.Duration
+	bucket time.Duration
+}{
+	{90 * 24 * time.Hour, 24 * time.Hour},
+	{7 * 24 * time.Hour, time.Hour},
+	{0, binlogBucket},
+}
+
+// maxBinlogEntry bounds the size of an entry read back, so that a corrupt
+// length does not exhaust memory.
+const maxBinlogEntry = 1 << 20
+
+// binlogEntry is a probe, or an aggregate of the probes of a target within
+// a bucket of binlogTiers once compacted. On disk each entry is a protobuf message
+// preceded by its length as a varint, the usual framing of delimited
+// protobuf streams:
+//
+//	message Entry {
+//	  string target = 1;
+//	  int64 time_unix_nano = 2;  // probe or bucket start
+//	  uint32 attempt = 3;
+//	  uint32 status = 4;
+//	  uint64 bytes = 5;          // summed for aggregates
+//	  uint64 dns_ns = 6;
+//	  uint64 connect_ns = 7;
+//	  uint64 tls_ns = 8;
+//	  uint64 ttfb_ns = 9;
+//	  uint64 total_ns = 10;      // summed for aggregates
+//	  string error_class = 11;
+//	  string error = 12;
+//	  repeated string failures = 13;
+//	  uint64 count = 14;         // probes aggregated, zero for a probe
+//	  uint64 failed = 15;        // aggregated probes that did not succeed
+//	  uint64 max_total_ns = 16;
+//	}
+type binlogEntry struct {
+	Target     string    `json:"target"`
+	Time       time.Time `json:"time"`
+	Attempt    int       `json:"attempt,omitempty"`
+	Status     int       `json:"status,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	DNSNS      int64     `json:"dns_ns,omitempty"`
+	ConnectNS  int64     `json:"connect_ns,omitempty"`
+	TLSNS      int64     `json:"tls_ns,omitempty"`
+	TTFBNS     int64     `json:"ttfb_ns,omitempty"`
+	TotalNS    int64     `json:"total_ns,omitempty"`
+	ErrorClass string    `json:"error_class,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Failures   []string  `json:"failures,omitempty"`
+	Count      int64     `json:"count,omitempty"`
+	Failed     int64     `json:"failed,omitempty"`
+	MaxTotalNS int64     `json:"max_total_ns,omitempty"`
+}
+
+func newBinlogEntry(t Target, rec Record) binlogEntry {
+	e := binlogEntry{
+		Target:    targetName(t),
+		Time:      rec.StartTime,
+		Attempt:   rec.Attempt,
+		Status:    rec.StatusCode,
+		Bytes:     rec.BytesRead,
+		DNSNS:     int64(rec.DNS),
+		ConnectNS: int64(rec.Connect),
+		TLSNS:     int64(rec.TLS),
+		TTFBNS:    int64(rec.TTFB),
+		TotalNS:   int64(rec.Total),
+		Failures:  rec.Failures,
+	}
+	if rec.Err != nil {
+		e.ErrorClass, e.Error = classifyError(rec.Err), rec.Err.Error()
+	}
+	return e
+}
+
+// failed reports whether a probe entry did not succeed, like probeOK.
+func (e binlogEntry) failed() bool {
+	return e.ErrorClass != "" || e.Status >= 400 || len(e.Failures) > 0
+}
+
+func (e binlogEntry) marshal() []byte {
+	var msg []byte
+	str := func(field uint64, s string) {
+		if s != "" {
+			msg = appendUvarint(msg, field<<3|2)
+			msg = appendUvarint(msg, uint64(len(s)))
+			msg = append(msg, s...)
+		}
+	}
+	num := func(field uint64, v int64) {
+		if v != 0 {
+			msg = appendUvarint(msg, field<<3)
+			msg = appendUvarint(msg, uint64(v))
+		}
+	}
+
+	str(1, e.Target)
+	num(2, e.Time.UnixNano())
+	num(3, int64(e.Attempt))
+	num(4, int64(e.Status))
+	num(5, e.Bytes)
+	num(6, e.DNSNS)
+	num(7, e.ConnectNS)
+	num(8, e.TLSNS)
+	num(9, e.TTFBNS)
+	num(10, e.TotalNS)
+	str(11, e.ErrorClass)
+	str(12, e.Error)
+	for _, f := range e.Failures {
+		msg = appendUvarint(msg, 13<<3|2)
+		msg = appendUvarint(msg, uint64(len(f)))
+		msg = append(msg, f...)
+	}
+	num(14, e.Count)
+	num(15, e.Failed)
+	num(16, e.MaxTotalNS)
+
+	return append(appendUvarint(nil, uint64(len(msg))), msg...)
+}
+
+func unmarshalBinlogEntry(msg []byte) (binlogEntry, error) {
+	var e binlogEntry
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return e, errors.New("invalid field tag")
+		}
+		msg = msg[n:]
+		v, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return e, errors.New("invalid field value")
+		}
+		msg = msg[n:]
+
+		if tag&7 == 2 {
+			if v > uint64(len(msg)) {
+				return e, errors.New("field overruns entry")
+			}
+			s := string(msg[:v])
+			msg = msg[v:]
+			switch tag >> 3 {
+			case 1:
+				e.Target = s
+			case 11:
+				e.ErrorClass = s
+			case 12:
+				e.Error = s
+			case 13:
+				e.Failures = append(e.Failures, s)
+			}
+			continue
+		}
+		if tag&7 != 0 {
+			return e, fmt.Errorf("unexpected wire type %d", tag&7)
+		}
+		switch tag >> 3 {
+		case 2:
+			e.Time = time.Unix(0, int64(v))
+		case 3:
+			e.Attempt = int(v)
+		case 4:
+			e.Status = int(v)
+		case 5:
+			e.Bytes = int64(v)
+		case 6:
+			e.DNSNS = int64(v)
+		case 7:
+			e.ConnectNS = int64(v)
+		case 8:
+			e.TLSNS = int64(v)
+		case 9:
+			e.TTFBNS = int64(v)
+		case 10:
+			e.TotalNS = int64(v)
+		case 14:
+			e.Count = int64(v)
+		case 15:
+			e.Failed = int64(v)
+		case 16:
+			e.MaxTotalNS = int64(v)
+		}
+	}
+	return e, nil
+}
+
+// readBinlog reads the entries of a binary log. An entry cut short at the
+// end of the file, left by a crash while writing it, is ignored.
+func readBinlog(r io.Reader) ([]binlogEntry, error) {
+	br := bufio.NewReader(r)
+	var entries []binlogEntry
+	for {
+		size, err := binary.ReadUvarint(br)
+		if err != nil {
+			// The end of the file, or a length cut short.
+			return entries, nil
+		}
+		if size > maxBinlogEntry {
+			return entries, fmt.Errorf("binlog entry %d: size %d exceeds %d bytes", len(entries)+1, size, maxBinlogEntry)
+		}
+		msg := make([]byte, size)
+		if _, err := io.ReadFull(br, msg); err != nil {
+			return entries, nil
+		}
+		e, err := unmarshalBinlogEntry(msg)
+		if err != nil {
+			return entries, fmt.Errorf("binlog entry %d: %w", len(entries)+1, err)
+		}
+		entries = append(entries, e)
+	}
+}
+
+// compactBinlog folds the probes started before cutoff into aggregates per
+// target and bucket of binlogTiers, merging them with earlier aggregates.
+func compactBinlog(entries []binlogEntry, cutoff time.Time) []binlogEntry {
+	type key struct {
+		target string
+		size   time.Duration
+		bucket int64
+	}
+	aggregates := make(map[key]*binlogEntry)
+	var keys []key
+	var recent []binlogEntry
+	for _, e := range entries {
+		if e.Count == 0 && !e.Time.Before(cutoff) {
+			recent = append(recent, e)
+			continue
+		}
+		size := binlogBucket
+		for _, tier := range binlogTiers {
+			if cutoff.Sub(e.Time) >= tier.age {
+				size = tier.bucket
+				break
+			}
+		}
+		k := key{e.Target, size, e.Time.Truncate(size).UnixNano()}
+		a, ok := aggregates[k]
+		if !ok {
+			a = &binlogEntry{Target: e.Target, Time: time.Unix(0, k.bucket)}
+			aggregates[k] = a
+			keys = append(keys, k)
+		}
+		if e.Count == 0 {
+			e.Count, e.MaxTotalNS = 1, e.TotalNS
+			if e.failed() {
+				e.Failed = 1
+			}
+		}
+		a.Count += e.Count
+		a.Failed += e.Failed
+		a.Bytes += e.Bytes
+		a.TotalNS += e.TotalNS
+		if e.MaxTotalNS > a.MaxTotalNS {
+			a.MaxTotalNS = e.MaxTotalNS
+		}
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].bucket < keys[j].bucket })
+	compacted := make([]binlogEntry, 0, len(keys)+len(recent))
+	for _, k := range keys {
+		compacted = append(compacted, *aggregates[k])
+	}
+	return append(compacted, recent...)
+}
+
+// binlogSink appends every probe to a binary log, synced to disk before
+// the next probe is written so a crash loses nothing. Probes older than
+// binlogKeep are compacted into aggregates from time to time, so the file
+// stays small during long runs.
+type binlogSink struct {
+	path string
+
+	mu        sync.Mutex
+	f         *os.File
+	compacted time.Time
+}
+
+func newBinlogSink(path string) (*binlogSink, error) {
+	s := &binlogSink{path: path}
+	// Compacting first also drops a partial entry left by a crash, which
+	// would corrupt the entries appended after it.
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// compact rewrites the log with old probes aggregated, replacing the file
+// only once the new one is safely on disk. It must be called with s.mu
+// held or before the sink is used.
+func (s *binlogSink) compact() error {
+	var entries []binlogEntry
+	if f, err := os.Open(s.path); err == nil {
+		entries, err = readBinlog(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("binlog sink: %s: %w", s.path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("binlog sink: %w", err)
+	}
+
+	now := time.Now()
+	if binlogKeep > 0 {
+		entries = compactBinlog(entries, now.Add(-binlogKeep))
+	}
+	tmp, err := os.Create(s.path + ".tmp")
+	if err != nil {
+		return fmt.Errorf("binlog sink: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	for _, e := range entries {
+		_, _ = w.Write(e.marshal())
+	}
+	err = w.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("binlog sink: %w", err)
+	}
+
+	if s.f != nil {
+		s.f.Close()
+	}
+	if s.f, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return fmt.Errorf("binlog sink: %w", err)
+	}
+	s.compacted = now
+	return nil
+}
+
+func (s *binlogSink) Write(t Target, rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(newBinlogEntry(t, rec).marshal()); err != nil {
+		return fmt.Errorf("binlog sink: %w", err)
+	}
+	if err := s.f.Sync(); err != nil {
+		return fmt.Errorf("binlog sink: %w", err)
+	}
+
+	// Compacting four times per -binlog-keep bounds the file to about 1.25
+	// times the probes it keeps.
+	every := binlogKeep / 4
+	if every < binlogBucket {
+		every = binlogBucket
+	}
+	if binlogKeep > 0 && time.Since(s.compacted) >= every {
+		return s.compact()
+	}
+	return nil
+}
+
+func (s *binlogSink) Flush(t Target, summary Summary) error {
+	return nil
+}
+
+func (s *binlogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.f.Close(); err != nil {
+		return fmt.Errorf("binlog sink: %w", err)
+	}
+	return nil
+}
+
+// dumpBinlog prints the entries of a binary log as JSON lines.
+func dumpBinlog(path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	entries, err := readBinlog(f)
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			return err
+		}
+	}
+	return err
+}
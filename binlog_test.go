@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testEntries = []binlogEntry{
+	{Target: "api", Time: time.Unix(1700000000, 123), Attempt: 1, Status: 200, Bytes: 512,
+		DNSNS: 1000, ConnectNS: 2000, TLSNS: 3000, TTFBNS: 40000, TotalNS: 50000},
+	{Target: "api", Time: time.Unix(1700000002, 0), Attempt: 2, ErrorClass: errTimeout,
+		Error: "context deadline exceeded", TotalNS: 60000},
+	{Target: "web", Time: time.Unix(1700000004, 0), Status: 200, Failures: []string{"size 0 outside 1-", "json .ok: false"}},
+	{Target: "web", Time: time.Unix(1699990000, 0), Count: 12, Failed: 3, Bytes: 1 << 40, TotalNS: 1 << 50, MaxTotalNS: 1 << 45},
+}
+
+func TestBinlogRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	for _, e := range testEntries {
+		buf.Write(e.marshal())
+	}
+	got, err := readBinlog(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(testEntries) {
+		t.Fatalf("read %d entries, want %d", len(got), len(testEntries))
+	}
+	for i := range got {
+		if !got[i].Time.Equal(testEntries[i].Time) {
+			t.Errorf("entry %d: time %v, want %v", i, got[i].Time, testEntries[i].Time)
+		}
+		got[i].Time = testEntries[i].Time
+		if !reflect.DeepEqual(got[i], testEntries[i]) {
+			t.Errorf("entry %d: got %+v, want %+v", i, got[i], testEntries[i])
+		}
+	}
+}
+
+func TestReadBinlogPartial(t *testing.T) {
+	full := testEntries[0].marshal()
+	second := testEntries[1].marshal()
+	tests := []struct {
+		name string
+		data []byte
+		want int
+	}{
+		{"empty", nil, 0},
+		{"cut in the length", append(append([]byte{}, full...), 0x80), 1},
+		{"cut in the message", append(append([]byte{}, full...), second[:len(second)-3]...), 1},
+		{"whole", append(append([]byte{}, full...), second...), 2},
+	}
+	for _, tt := range tests {
+		entries, err := readBinlog(bytes.NewReader(tt.data))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if len(entries) != tt.want {
+			t.Errorf("%s: read %d entries, want %d", tt.name, len(entries), tt.want)
+		}
+	}
+}
+
+func TestReadBinlogCorrupt(t *testing.T) {
+	full := testEntries[0].marshal()
+	full = full[:len(full):len(full)]
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"wire type", append(full, 2, 3<<3|5, 1), "unexpected wire type"},
+		{"overrun", append(full, 3, 1<<3|2, 9, 'a'), "overruns"},
+		{"tag", append(full, 1, 0x80), "invalid field tag"},
+		{"value", append(full, 2, 2<<3, 0x80), "invalid field value"},
+		{"size", append(full, appendUvarint(nil, 1<<62)...), "exceeds"},
+	}
+	for _, tt := range tests {
+		entries, err := readBinlog(bytes.NewReader(tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: error %v, want %q", tt.name, err, tt.want)
+		}
+		if len(entries) != 1 {
+			t.Errorf("%s: kept %d entries, want the 1 before the corrupt one", tt.name, len(entries))
+		}
+	}
+}
+
+func TestCompactBinlog(t *testing.T) {
+	cutoff := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	probe := func(target string, at time.Time, status int, total time.Duration) binlogEntry {
+		return binlogEntry{Target: target, Time: at, Status: status, TotalNS: int64(total)}
+	}
+	entries := []binlogEntry{
+		// Aggregated per minute.
+		probe("a", cutoff.Add(-90*time.Second), 200, 10*time.Millisecond),
+		probe("a", cutoff.Add(-80*time.Second), 500, 30*time.Millisecond),
+		probe("b", cutoff.Add(-80*time.Second), 200, 5*time.Millisecond),
+		// Per hour after a week.
+		{Target: "a", Time: cutoff.Add(-8 * 24 * time.Hour).Truncate(time.Hour), Count: 2, Failed: 1, TotalNS: 10, MaxTotalNS: 7},
+		{Target: "a", Time: cutoff.Add(-8 * 24 * time.Hour).Truncate(time.Hour).Add(time.Minute), Count: 3, TotalNS: 20, MaxTotalNS: 9},
+		// Per day after 90 days.
+		{Target: "a", Time: cutoff.Add(-100 * 24 * time.Hour).Truncate(24 * time.Hour).Add(time.Hour), Count: 1, TotalNS: 1, MaxTotalNS: 1},
+		{Target: "a", Time: cutoff.Add(-100 * 24 * time.Hour).Truncate(24 * time.Hour).Add(5 * time.Hour), Count: 1, TotalNS: 2, MaxTotalNS: 2},
+		// Kept as is.
+		probe("a", cutoff.Add(time.Second), 200, time.Millisecond),
+	}
+	got := compactBinlog(entries, cutoff)
+	want := []binlogEntry{
+		{Target: "a", Time: cutoff.Add(-100 * 24 * time.Hour).Truncate(24 * time.Hour), Count: 2, TotalNS: 3, MaxTotalNS: 2},
+		{Target: "a", Time: cutoff.Add(-8 * 24 * time.Hour).Truncate(time.Hour), Count: 5, Failed: 1, TotalNS: 30, MaxTotalNS: 9},
+		{Target: "a", Time: cutoff.Add(-2 * time.Minute), Count: 2, Failed: 1, TotalNS: int64(40 * time.Millisecond), MaxTotalNS: int64(30 * time.Millisecond)},
+		{Target: "b", Time: cutoff.Add(-2 * time.Minute), Count: 1, TotalNS: int64(5 * time.Millisecond), MaxTotalNS: int64(5 * time.Millisecond)},
+		probe("a", cutoff.Add(time.Second), 200, time.Millisecond),
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d: %+v", len(got), len(want), got)
+	}
+	for i := range got {
+		if !got[i].Time.Equal(want[i].Time) {
+			t.Errorf("entry %d: time %v, want %v", i, got[i].Time, want[i].Time)
+		}
+		got[i].Time = want[i].Time
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("entry %d: got %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	// Compacting again changes nothing.
+	again := compactBinlog(got, cutoff)
+	if len(again) != len(got) {
+		t.Errorf("second compaction left %d entries, want %d", len(again), len(got))
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// burstPercentiles are the latency percentiles reported after a burst.
+var burstPercentiles = []float64{50, 75, 90, 95, 99, 99.9}
+
+// runBurst sends n probes of t as fast as parallel probes in flight allow,
+// then prints the distribution of their latencies and the breakdown of their
+// failures. Probes are written to the sinks like those of a run.
+func runBurst(ctx context.Context, t Target, n, parallel int, sinks sinkList) {
+	sinks.start(t)
+	prober, counter := newProber(t, targetLogger(t))
+	run := Run{Target: t, Start: time.Now(), Records: make([]Record, 0, n)}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	for attempt := 1; attempt <= n; attempt++ {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(attempt int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			pCtx, cancel := context.WithTimeout(ctx, time.Duration(t.Timeout))
+			defer cancel()
+			rec := prober.Probe(pCtx)
+			rec.Attempt = attempt
+
+			mu.Lock()
+			run.Records = append(run.Records, rec)
+			mu.Unlock()
+			sinks.write(t, rec)
+		}(attempt)
+	}
+	wg.Wait()
+	run.End = time.Now()
+	run.BytesSent, run.BytesReceived = counter.totals()
+
+	printBurst(t, run, parallel)
+	sinks.flush(t, summarize(run))
+}
+
+func printBurst(t Target, run Run, parallel int) {
+	elapsed := run.End.Sub(run.Start)
+	fmt.Printf("--- burst of %d %s %s ---\n", len(run.Records), t.verb(), t.URL)
+	fmt.Printf("%d probes in %v with up to %d in parallel, %.1f/s\n",
+		len(run.Records), formatDuration(elapsed), parallel, float64(len(run.Records))/elapsed.Seconds())
+
+	statuses := make(map[string]int)
+	failures := make(map[string]int)
+	var latencies, ttfbs []time.Duration
+	for _, rec := range run.Records {
+		if rec.Responded && rec.StatusCode > 0 {
+			statuses[strconv.Itoa(rec.StatusCode)]++
+		}
+		switch {
+		case rec.Err != nil:
+			failures[classifyError(rec.Err)]++
+		case !rec.Responded:
+			failures[errOther]++
+		case rec.StatusCode >= 400:
+			failures["http_"+strconv.Itoa(rec.StatusCode)]++
+		case len(rec.Failures) > 0:
+			failures["assertion"]++
+		default:
+			latencies = append(latencies, rec.Total)
+			if rec.TTFB > 0 {
+				ttfbs = append(ttfbs, rec.TTFB)
+			}
+		}
+	}
+
+	fmt.Printf("%d succeeded, %d failed", len(latencies), len(run.Records)-len(latencies))
+	if len(failures) > 0 {
+		fmt.Printf(": %s", formatErrorCounts(failures))
+	}
+	fmt.Println()
+	if len(statuses) > 0 {
+		fmt.Printf("status codes: %s\n", formatErrorCounts(statuses))
+	}
+	if len(latencies) == 0 {
+		return
+	}
+	fmt.Printf("latency %s\n", formatDistribution(latencies))
+	if len(ttfbs) > 0 {
+		fmt.Printf("ttfb    %s\n", formatDistribution(ttfbs))
+	}
+}
+
+// flagsFirst moves the flags in args, with their values, before the other
+// arguments, so that flags following a URL are parsed too. Arguments after
+// "--" stay where they are.
+func flagsFirst(fs *flag.FlagSet, args []string) []string {
+	var flags, rest []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			rest = append(rest, args[i:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			rest = append(rest, arg)
+			continue
+		}
+		flags = append(flags, arg)
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+		f := fs.Lookup(name)
+		if f == nil {
+			continue
+		}
+		if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+			continue
+		}
+		if i+1 < len(args) {
+			i++
+			flags = append(flags, args[i])
+		}
+	}
+	return append(flags, rest...)
+}
+
+// formatDistribution renders the minimum, mean, burstPercentiles and
+// maximum of durations, which it sorts.
+func formatDistribution(durations []time.Duration) string {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	var sum time.Duration
+	for _, d := range durations {
+		sum += d
+	}
+	parts := []string{
+		"min " + formatDuration(durations[0]),
+		"mean " + formatDuration(sum/time.Duration(len(durations))),
+	}
+	for _, p := range burstPercentiles {
+		parts = append(parts, "p"+strconv.FormatFloat(p, 'f', -1, 64)+" "+formatDuration(percentile(durations, p)))
+	}
+	parts = append(parts, "max "+formatDuration(durations[len(durations)-1]))
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlagsFirst(t *testing.T) {
+	fs := flag.NewFlagSet("burst", flag.ContinueOnError)
+	fs.Int("n", 100, "")
+	fs.Bool("insecure", false, "")
+	tests := []struct {
+		args, want []string
+	}{
+		{[]string{"-n", "10", "https://example.com/"}, []string{"-n", "10", "https://example.com/"}},
+		{[]string{"https://example.com/", "-n", "10"}, []string{"-n", "10", "https://example.com/"}},
+		{[]string{"https://example.com/", "-insecure", "-n=5"}, []string{"-insecure", "-n=5", "https://example.com/"}},
+		{[]string{"https://example.com/", "--", "-n", "10"}, []string{"https://example.com/", "--", "-n", "10"}},
+		{[]string{"https://example.com/", "-unknown", "x"}, []string{"-unknown", "https://example.com/", "x"}},
+		{[]string{"-", "-n"}, []string{"-n", "-"}},
+	}
+	for _, tt := range tests {
+		if got := flagsFirst(fs, tt.args); !equalStrings(got, tt.want) {
+			t.Errorf("flagsFirst(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDistribution(t *testing.T) {
+	durations := make([]time.Duration, 100)
+	for i := range durations {
+		durations[i] = time.Duration(100-i) * time.Millisecond
+	}
+	want := "min 1.0 ms, mean 50.5 ms, p50 50.0 ms, p75 75.0 ms, p90 90.0 ms, p95 95.0 ms, p99 99.0 ms, p99.9 100.0 ms, max 100.0 ms"
+	if got := formatDistribution(durations); got != want {
+		t.Errorf("formatDistribution = %q, want %q", got, want)
+	}
+}
+
+func TestRunBurst(t *testing.T) {
+	var inflight, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	sink := &recordingSink{}
+	target := Target{URL: srv.URL, Mode: modeHTTP, Method: http.MethodGet, Timeout: Duration(5 * time.Second)}
+	runBurst(context.Background(), target, 12, 3, sinkList{sink})
+	if len(sink.records) != 12 || peak > 3 {
+		t.Errorf("%d probes written with up to %d in parallel, want 12 with up to 3", len(sink.records), peak)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Certificate name verification modes for -verify-name.
+const (
+	verifyNameAuto = "auto"
+	verifyNameHost = "host"
+	verifyNameURL  = "url"
+	verifyNameNone = "none"
+)
+
+// newClient builds the HTTP client used to probe t. All bytes sent and
+// received on its connections are added to the returned counter.
+func newClient(t Target) (*http.Client, *byteCounter) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig(t)
+	proxy := proxyFunc(t)
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		// https requests through an HTTP proxy open a CONNECT tunnel once
+		// connected to the proxy, after the handshake for https proxies.
+		phase, ok := req.Context().Value(phaseTrackerKey{}).(*phaseTracker)
+		if ok && u != nil && req.URL.Scheme == "https" {
+			switch u.Scheme {
+			case "http":
+				phase.tunnel(phaseConnect)
+			case "https":
+				phase.tunnel(phaseTLS)
+			}
+		}
+		return u, err
+	}
+	// send asks for gzip itself to count the encoded size.
+	transport.DisableCompression = t.Mode == modeHTTP
+	if t.Mode == modeWebSocket {
+		// The upgrade handshake needs HTTP/1.1.
+		transport.ForceAttemptHTTP2 = false
+		transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	counter := &byteCounter{}
+	transport.DialContext = newDial(t, counter)
+	return &http.Client{Transport: transport}, counter
+}
+
+type dialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// newDial returns the dial function for t's connections, using the DNS
+// options of t and counting bytes in counter.
+func newDial(t Target, counter *byteCounter) dialFunc {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	dial := dialer.DialContext
+	if r := newResolver(t); r != nil {
+		dial = r.dialContext(dialer)
+	}
+	return counter.wrap(dial)
+}
+
+// newResolver returns the retrying resolver configured by t's DNS options,
+// or nil to use the system resolver.
+func newResolver(t Target) *retryingResolver {
+	if t.DNSTimeout <= 0 && t.DNSAttempts <= 0 {
+		return nil
+	}
+	r := &retryingResolver{
+		resolver: &net.Resolver{PreferGo: true},
+		timeout:  time.Duration(t.DNSTimeout),
+		attempts: t.DNSAttempts,
+	}
+	if r.attempts <= 0 {
+		r.attempts = 1
+	}
+	return r
+}
+
+// byteCounter counts the wire bytes of every connection of a client,
+// including TLS overhead.
+type byteCounter struct {
+	sent     int64
+	received int64
+}
+
+func (c *byteCounter) wrap(dial dialFunc) dialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		return &countingConn{Conn: conn, counter: c}, nil
+	}
+}
+
+func (c *byteCounter) totals() (sent, received int64) {
+	return atomic.LoadInt64(&c.sent), atomic.LoadInt64(&c.received)
+}
+
+type countingConn struct {
+	net.Conn
+	counter *byteCounter
+}
+
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.counter.received, int64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.counter.sent, int64(n))
+	return n, err
+}
+
+// tlsConfig decides which name the server certificate is checked against.
+// When the URL points at an IP literal and a Host header is given, the
+// certificate is expected to be issued for the Host rather than the IP.
+func tlsConfig(t Target) *tls.Config {
+	switch t.certName() {
+	case "":
+		return &tls.Config{
+			InsecureSkipVerify: true,
+			VerifyConnection:   verifyChainOnly,
+		}
+	case urlHostname(t.URL):
+		return &tls.Config{}
+	default:
+		return &tls.Config{ServerName: t.certName()}
+	}
+}
+
+// certName returns the name the certificate must be valid for, or "" when
+// name verification is disabled.
+func (t Target) certName() string {
+	switch t.VerifyName {
+	case verifyNameNone:
+		return ""
+	case verifyNameHost:
+		if t.HostHeader != "" {
+			return t.HostHeader
+		}
+	case verifyNameAuto:
+		if t.HostHeader != "" && net.ParseIP(urlHostname(t.URL)) != nil {
+			return t.HostHeader
+		}
+	}
+	return urlHostname(t.URL)
+}
+
+// verifyChainOnly checks the certificate chain but not the server name.
+func verifyChainOnly(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("tls: server sent no certificates")
+	}
+	opts := x509.VerifyOptions{Intermediates: x509.NewCertPool()}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// describeCertName reports whether the leaf certificate of cs is valid for
+// the Host header and for the host in the URL.
+func describeCertName(t Target, cs tls.ConnectionState) string {
+	if len(cs.PeerCertificates) == 0 {
+		return "no certificate"
+	}
+	leaf := cs.PeerCertificates[0]
+	match := func(name string) string {
+		if leaf.VerifyHostname(name) == nil {
+			return "match"
+		}
+		return "mismatch"
+	}
+	urlHost := urlHostname(t.URL)
+	return fmt.Sprintf("certificate %q: host %s %s, url %s %s", leaf.Subject.CommonName,
+		t.HostHeader, match(t.HostHeader), urlHost, match(urlHost))
+}
+
+// urlHostname returns the host of a URL, or of a bare host:port or host
+// name as used by the tcp, tls and dns modes.
+func urlHostname(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		if host, _, err := net.SplitHostPort(rawURL); err == nil {
+			return host
+		}
+		return strings.Trim(rawURL, "[]")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// isGoAway reports whether a request failed because of an HTTP/2 GOAWAY
+// frame. The bundled HTTP/2 transport does not export its error types, so
+// this matches the message of its error, without the URL around it. A
+// graceful GOAWAY the transport handles by moving on to a new connection
+// fails no request and is not seen.
+func isGoAway(err error) bool {
+	var uerr *url.Error
+	if errors.As(err, &uerr) {
+		err = uerr.Err
+	}
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.HasPrefix(msg, "http2") && strings.Contains(msg, "GOAWAY")
+}
+
+// timeoutNote annotates a log line of a response that nearly timed out.
+func timeoutNote(t Target, rec Record) string {
+	if !rec.NearTimeout {
+		return ""
+	}
+	return fmt.Sprintf(" (%.0f%% of timeout)", t.timeoutPercent(rec.Total))
+}
+
+// dnsNote annotates a log line with the DNS lookup time, if any.
+func dnsNote(rec Record) string {
+	if rec.DNS == 0 {
+		return ""
+	}
+	return " dns=" + formatDuration(rec.DNS)
+}
+
+// tunnelNote annotates a log line with the time the CONNECT request to a
+// proxy took, if any.
+func tunnelNote(rec Record) string {
+	if rec.Tunnel == 0 {
+		return ""
+	}
+	return " tunnel=" + formatDuration(rec.Tunnel)
+}
+
+// connNote annotates a log line with how the connection was handled. DNS
+// probes have no connection.
+func connNote(t Target, rec Record) string {
+	switch {
+	case t.Mode == modeDNS:
+	case rec.ConnClose:
+		return " conn=close"
+	case !rec.ConnReused:
+		return " conn=new"
+	}
+	return ""
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+)
+
+func TestURLHostname(t *testing.T) {
+	tests := []struct {
+		rawURL string
+		want   string
+	}{
+		{"https://example.com/health", "example.com"},
+		{"https://example.com:8443/", "example.com"},
+		{"https://[2001:db8::1]:8443/", "2001:db8::1"},
+		{"example.com:443", "example.com"},
+		{"[2001:db8::1]:53", "2001:db8::1"},
+		{"example.com", "example.com"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"https://%zz/", ""},
+	}
+	for _, tt := range tests {
+		if got := urlHostname(tt.rawURL); got != tt.want {
+			t.Errorf("urlHostname(%q) = %q, want %q", tt.rawURL, got, tt.want)
+		}
+	}
+}
+
+func TestCertName(t *testing.T) {
+	tests := []struct {
+		url, host, verify string
+		want              string
+	}{
+		{"https://203.0.113.10/", "www.example.com", verifyNameAuto, "www.example.com"},
+		{"https://origin.example.com/", "www.example.com", verifyNameAuto, "origin.example.com"},
+		{"https://203.0.113.10/", "", verifyNameAuto, "203.0.113.10"},
+		{"https://origin.example.com/", "www.example.com", verifyNameHost, "www.example.com"},
+		{"https://origin.example.com/", "", verifyNameHost, "origin.example.com"},
+		{"https://203.0.113.10/", "www.example.com", verifyNameURL, "203.0.113.10"},
+		{"https://203.0.113.10/", "www.example.com", verifyNameNone, ""},
+	}
+	for _, tt := range tests {
+		target := Target{URL: tt.url, HostHeader: tt.host, VerifyName: tt.verify}
+		if got := target.certName(); got != tt.want {
+			t.Errorf("certName of %s with host %q and -verify-name %s = %q, want %q", tt.url, tt.host, tt.verify, got, tt.want)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	tests := []struct {
+		t          Target
+		serverName string
+		insecure   bool
+	}{
+		{Target{URL: "https://example.com/", VerifyName: verifyNameAuto}, "", false},
+		{Target{URL: "https://203.0.113.10/", HostHeader: "www.example.com", VerifyName: verifyNameAuto}, "www.example.com", false},
+		{Target{URL: "https://example.com/", VerifyName: verifyNameNone}, "", true},
+	}
+	for _, tt := range tests {
+		cfg := tlsConfig(tt.t)
+		if cfg.ServerName != tt.serverName || cfg.InsecureSkipVerify != tt.insecure {
+			t.Errorf("tlsConfig(%s, -verify-name %s): server name %q, insecure %v, want %q, %v",
+				tt.t.URL, tt.t.VerifyName, cfg.ServerName, cfg.InsecureSkipVerify, tt.serverName, tt.insecure)
+		}
+		if tt.insecure && cfg.VerifyConnection == nil {
+			t.Errorf("tlsConfig(%s, -verify-name %s) does not verify the chain", tt.t.URL, tt.t.VerifyName)
+		}
+	}
+}
+
+func TestIsGoAway(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("http2: server sent GOAWAY and closed the connection; LastStreamID=1, ErrCode=NO_ERROR, debug=\"\""), true},
+		{&url.Error{Op: "Get", URL: "https://example.com/", Err: errors.New("http2: Transport received Server's graceful shutdown GOAWAY")}, true},
+		{&url.Error{Op: "Get", URL: "https://example.com/GOAWAY", Err: errors.New("EOF")}, false},
+		{errors.New("connection reset by peer"), false},
+	}
+	for _, tt := range tests {
+		if got := isGoAway(tt.err); got != tt.want {
+			t.Errorf("isGoAway(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Config is the on-disk configuration read with -config.
+type Config struct {
+	Targets []Target `json:"targets"`
+}
+
+// Target is a single probed endpoint. Zero-valued fields fall back to the
+// values given on the command line.
+type Target struct {
+	Name     string   `json:"name,omitempty"`
+	URL      string   `json:"url"`
+	Mode     string   `json:"mode,omitempty"`
+	Method   string   `json:"method,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+	Timeout  Duration `json:"timeout,omitempty"`
+
+	// Weight is the relative share of probes of the target with the
+	// weighted strategy, 1 unless set. A weight of 0 is kept, so a target
+	// can be taken out of rotation without removing it.
+	Weight *float64 `json:"weight,omitempty"`
+
+	// NearTimeout is the share of Timeout, in percent, above which a
+	// response is reported as close to timing out.
+	NearTimeout float64 `json:"near_timeout,omitempty"`
+
+	Headers    []string `json:"headers,omitempty"`
+	HostHeader string   `json:"host_header,omitempty"`
+	VerifyName string   `json:"verify_name,omitempty"`
+	StartTLS   bool     `json:"starttls,omitempty"`
+
+	// Proxy is the proxy URL, and PAC the proxy auto-config file choosing
+	// the proxy instead. Without either, the proxy environment variables
+	// apply.
+	Proxy     string `json:"proxy,omitempty"`
+	ProxyUser string `json:"proxy_user,omitempty"`
+	PAC       string `json:"pac,omitempty"`
+
+	// RequestFile holds a raw HTTP request that replaces the method, path,
+	// headers and body of every request.
+	RequestFile string `json:"request_file,omitempty"`
+
+	// Plain makes URLs without a scheme default to http:// instead of
+	// https://, for internal targets.
+	Plain bool `json:"plain,omitempty"`
+
+	// H2Ping sends an HTTP/2 PING between probes to measure the RTT.
+	H2Ping bool `json:"h2_ping,omitempty"`
+
+	// Raw leaves response bodies as sent, without asking for gzip.
+	Raw bool `json:"raw,omitempty"`
+
+	// HMACKey signs every request with an HMAC of the HMACFields request
+	// components, sent in the HMACHeader header.
+	HMACKey      string `json:"hmac_key,omitempty"`
+	HMACHeader   string `json:"hmac_header,omitempty"`
+	HMACFields   string `json:"hmac_fields,omitempty"`
+	HMACHash     string `json:"hmac_hash,omitempty"`
+	HMACEncoding string `json:"hmac_encoding,omitempty"`
+
+	DNSBackoff  Duration `json:"dns_backoff,omitempty"`
+	AutoBackoff Duration `json:"auto_backoff,omitempty"`
+	DNSTimeout  Duration `json:"dns_timeout,omitempty"`
+	DNSAttempts int      `json:"dns_attempts,omitempty"`
+
+	ExpectJSON  []string `json:"expect_json,omitempty"`
+	ExpectXPath []string `json:"expect_xpath,omitempty"`
+	ExpectCSS   []string `json:"expect_css,omitempty"`
+
+	ExpectSize       string  `json:"expect_size,omitempty"`
+	ExpectSizeChange float64 `json:"expect_size_change,omitempty"`
+	VerifyNoCache    bool    `json:"verify_no_cache,omitempty"`
+}
+
+// Duration is a time.Duration that is encoded as a string like "2s" in JSON.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"2s\": %w", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// loadConfig reads the config file at path. Unless expand is false, ${VAR}
+// references are replaced with environment variables first.
+func loadConfig(path string, expand bool) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if expand {
+		if b, err = expandEnvJSON(b); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var cfg Config
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i, t := range cfg.Targets {
+		if t.URL == "" {
+			return nil, fmt.Errorf("%s: target %d has no url", path, i)
+		}
+	}
+
+	return &cfg, nil
+}
+
+var envRef = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnv replaces ${VAR} references in s with environment variables. An
+// unset variable is an error rather than an empty string, so a missing
+// secret does not silently produce a wrong request.
+func expandEnv(s string) (string, error) {
+	return expandEnvFunc(s, func(v string) string { return v })
+}
+
+// expandEnvJSON is expandEnv for JSON documents: values are escaped so they
+// can be substituted inside JSON strings.
+func expandEnvJSON(b []byte) ([]byte, error) {
+	s, err := expandEnvFunc(string(b), func(v string) string {
+		quoted, _ := json.Marshal(v)
+		return string(quoted[1 : len(quoted)-1])
+	})
+	return []byte(s), err
+}
+
+func expandEnvFunc(s string, escape func(string) string) (string, error) {
+	var err error
+	out := envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		name := envRef.FindStringSubmatch(ref)[1]
+		v, ok := os.LookupEnv(name)
+		if !ok && err == nil {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		return escape(v)
+	})
+	return out, err
+}
+
+// weight returns the Weight of t.
+func (t Target) weight() float64 {
+	if t.Weight == nil {
+		return 1
+	}
+	return *t.Weight
+}
+
+// nearTimeout reports whether d used at least the NearTimeout share of the
+// request timeout.
+func (t Target) nearTimeout(d time.Duration) bool {
+	return t.NearTimeout > 0 && t.Timeout > 0 && t.timeoutPercent(d) >= t.NearTimeout
+}
+
+func (t Target) timeoutPercent(d time.Duration) float64 {
+	return float64(d) / float64(t.Timeout) * 100
+}
+
+// readURLs reads one URL per line, skipping blank lines and # comments, so
+// targets can be piped in from discovery scripts.
+func readURLs(r io.Reader) ([]string, error) {
+	var urls []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading urls from stdin: %w", err)
+	}
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no urls on stdin")
+	}
+	return urls, nil
+}
+
+// withDefaults fills unset target fields from the command line values.
+func (t Target) withDefaults(def Target) Target {
+	if t.Mode == "" {
+		t.Mode = def.Mode
+		if i := strings.Index(t.URL, "://"); t.Mode == modeHTTP && i > 0 {
+			switch t.URL[:i] {
+			case "ftp":
+				t.Mode = modeFTP
+			case "smtp", "smtps":
+				t.Mode = modeSMTP
+			case "imap", "imaps":
+				t.Mode = modeIMAP
+			}
+		}
+	}
+	if !t.Plain {
+		t.Plain = def.Plain
+	}
+	t.URL = withScheme(t.URL, t.Mode, t.Plain)
+	if t.Method == "" {
+		t.Method = def.Method
+	}
+	if t.Interval == 0 {
+		t.Interval = def.Interval
+	}
+	if t.Timeout == 0 {
+		t.Timeout = def.Timeout
+	}
+	if t.NearTimeout == 0 {
+		t.NearTimeout = def.NearTimeout
+	}
+	if t.Headers == nil {
+		t.Headers = def.Headers
+	}
+	if t.HostHeader == "" {
+		t.HostHeader = def.HostHeader
+	}
+	if t.VerifyName == "" {
+		t.VerifyName = def.VerifyName
+	}
+	if !t.StartTLS {
+		t.StartTLS = def.StartTLS
+	}
+	if t.Proxy == "" && t.PAC == "" {
+		t.Proxy, t.PAC = def.Proxy, def.PAC
+	}
+	if t.ProxyUser == "" {
+		t.ProxyUser = def.ProxyUser
+	}
+	if t.RequestFile == "" {
+		t.RequestFile = def.RequestFile
+	}
+	if t.HMACKey == "" {
+		t.HMACKey = def.HMACKey
+	}
+	if t.HMACHeader == "" {
+		t.HMACHeader = def.HMACHeader
+	}
+	if t.HMACFields == "" {
+		t.HMACFields = def.HMACFields
+	}
+	if t.HMACHash == "" {
+		t.HMACHash = def.HMACHash
+	}
+	if t.HMACEncoding == "" {
+		t.HMACEncoding = def.HMACEncoding
+	}
+	if !t.H2Ping {
+		t.H2Ping = def.H2Ping
+	}
+	if !t.Raw {
+		t.Raw = def.Raw
+	}
+	if t.DNSBackoff == 0 {
+		t.DNSBackoff = def.DNSBackoff
+	}
+	if t.AutoBackoff == 0 {
+		t.AutoBackoff = def.AutoBackoff
+	}
+	if t.DNSTimeout == 0 {
+		t.DNSTimeout = def.DNSTimeout
+	}
+	if t.DNSAttempts == 0 {
+		t.DNSAttempts = def.DNSAttempts
+	}
+	if t.ExpectJSON == nil {
+		t.ExpectJSON = def.ExpectJSON
+	}
+	if t.ExpectXPath == nil {
+		t.ExpectXPath = def.ExpectXPath
+	}
+	if t.ExpectCSS == nil {
+		t.ExpectCSS = def.ExpectCSS
+	}
+	if t.ExpectSize == "" {
+		t.ExpectSize = def.ExpectSize
+	}
+	if t.ExpectSizeChange == 0 {
+		t.ExpectSizeChange = def.ExpectSizeChange
+	}
+	if !t.VerifyNoCache {
+		t.VerifyNoCache = def.VerifyNoCache
+	}
+	return t
+}
+
+func (t Target) validate() error {
+	if err := t.validateMode(); err != nil {
+		return fmt.Errorf("%s: %w", t.URL, err)
+	}
+	if t.weight() < 0 {
+		return fmt.Errorf("%s: weight must not be negative", t.URL)
+	}
+	switch t.VerifyName {
+	case verifyNameAuto, verifyNameHost, verifyNameURL, verifyNameNone:
+	default:
+		return fmt.Errorf("%s: invalid verify name mode %q", t.URL, t.VerifyName)
+	}
+	if t.Proxy != "" || t.PAC != "" {
+		switch t.Mode {
+		case modeHTTP, modeGRPC, modeWebSocket, modeHLS:
+		default:
+			return fmt.Errorf("%s: proxies are not supported in %s mode", t.URL, t.Mode)
+		}
+	}
+	if t.Proxy != "" && t.PAC != "" {
+		return fmt.Errorf("%s: -proxy and -pac are exclusive", t.URL)
+	}
+	if t.Proxy != "" {
+		if _, err := parseProxyURL(t.Proxy); err != nil {
+			return fmt.Errorf("%s: %w", t.URL, err)
+		}
+	}
+	if t.PAC != "" {
+		if _, err := loadPAC(t.PAC); err != nil {
+			return fmt.Errorf("%s: %w", t.URL, err)
+		}
+	}
+	if t.RequestFile != "" {
+		if t.Mode != modeHTTP {
+			return fmt.Errorf("%s: -request-file needs http mode", t.URL)
+		}
+		if _, err := readRequestFile(t.RequestFile); err != nil {
+			return fmt.Errorf("%s: %w", t.URL, err)
+		}
+	}
+	if t.H2Ping {
+		if err := t.validateH2Ping(); err != nil {
+			return fmt.Errorf("%s: %w", t.URL, err)
+		}
+	}
+	if t.HMACKey != "" {
+		if err := t.validateHMAC(); err != nil {
+			return fmt.Errorf("%s: %w", t.URL, err)
+		}
+	}
+	for _, h := range t.Headers {
+		if _, _, err := parseHeader(h); err != nil {
+			return fmt.Errorf("%s: %w", t.URL, err)
+		}
+	}
+	if t.ExpectSize != "" {
+		if _, err := parseSizeRange(t.ExpectSize); err != nil {
+			return fmt.Errorf("%s: %w", t.URL, err)
+		}
+	}
+	for _, expr := range t.ExpectJSON {
+		if _, err := parseJSONExpectation(expr); err != nil {
+			return fmt.Errorf("%s: %w", t.URL, err)
+		}
+	}
+	for _, expr := range t.ExpectXPath {
+		if _, err := parseXPath(expr); err != nil {
+			return fmt.Errorf("%s: %w", t.URL, err)
+		}
+	}
+	for _, expr := range t.ExpectCSS {
+		if _, err := parseCSS(expr); err != nil {
+			return fmt.Errorf("%s: %w", t.URL, err)
+		}
+	}
+	return nil
+}
+
+// parseHeader splits a "Name: value" header argument.
+func parseHeader(h string) (name, value string, err error) {
+	i := strings.Index(h, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid header %q, expected \"Name: value\"", h)
+	}
+	return strings.TrimSpace(h[:i]), strings.TrimSpace(h[i+1:]), nil
+}
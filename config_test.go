@@ -0,0 +1,195 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	os.Setenv("HILICURL_TEST_TOKEN", `se"cret`)
+	defer os.Unsetenv("HILICURL_TEST_TOKEN")
+
+	path := writeConfig(t, `{"targets": [
+		{"url": "https://a.example.com/", "interval": "500ms", "timeout": "2s", "weight": 0},
+		{"name": "b", "url": "b.example.com", "headers": ["Authorization: Bearer ${HILICURL_TEST_TOKEN}"]}
+	]}`)
+	cfg, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Targets) != 2 {
+		t.Fatalf("loaded %d targets, want 2", len(cfg.Targets))
+	}
+	a, b := cfg.Targets[0], cfg.Targets[1]
+	if a.Interval != Duration(500*time.Millisecond) || a.Timeout != Duration(2*time.Second) {
+		t.Errorf("interval %v, timeout %v, want 500ms and 2s", a.Interval, a.Timeout)
+	}
+	if a.Weight == nil || *a.Weight != 0 || b.Weight != nil {
+		t.Errorf("weights %v and %v, want 0 and unset", a.Weight, b.Weight)
+	}
+	if want := []string{`Authorization: Bearer se"cret`}; !reflect.DeepEqual(b.Headers, want) {
+		t.Errorf("headers %q, want %q", b.Headers, want)
+	}
+
+	unexpanded, err := loadConfig(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := unexpanded.Targets[1].Headers[0]; !strings.Contains(h, "${HILICURL_TEST_TOKEN}") {
+		t.Errorf("header %q was expanded without expand", h)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`{"targets": [{"url": "https://example.com/", "intervall": "1s"}]}`, "unknown field"},
+		{`{"targets": [{"url": "https://example.com/", "interval": 1}]}`, "duration must be a string"},
+		{`{"targets": [{"url": "https://example.com/", "interval": "1 minute"}]}`, "unknown unit"},
+		{`{"targets": [{"name": "nameless"}]}`, "target 0 has no url"},
+		{`{"targets": [{"url": "https://${HILICURL_TEST_UNSET}/"}]}`, "HILICURL_TEST_UNSET is not set"},
+	}
+	for _, tt := range tests {
+		_, err := loadConfig(writeConfig(t, tt.src), true)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("loadConfig(%s): error %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	tests := []struct {
+		h           string
+		name, value string
+		ok          bool
+	}{
+		{"X-Test: 1", "X-Test", "1", true},
+		{"  Accept :text/html  ", "Accept", "text/html", true},
+		{"Authorization: Basic a:b", "Authorization", "Basic a:b", true},
+		{"X-Empty:", "X-Empty", "", true},
+		{"no colon", "", "", false},
+		{": value", "", "", false},
+	}
+	for _, tt := range tests {
+		name, value, err := parseHeader(tt.h)
+		if (err == nil) != tt.ok || name != tt.name || value != tt.value {
+			t.Errorf("parseHeader(%q) = %q, %q, %v", tt.h, name, value, err)
+		}
+	}
+}
+
+func TestReadURLs(t *testing.T) {
+	urls, err := readURLs(strings.NewReader("# targets\nhttps://a.example.com/\n\n  b.example.com  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"https://a.example.com/", "b.example.com"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("readURLs = %q, want %q", urls, want)
+	}
+	if _, err := readURLs(strings.NewReader("# nothing\n")); err == nil {
+		t.Error("readURLs without urls succeeded, want an error")
+	}
+}
+
+func TestWithDefaults(t *testing.T) {
+	def := Target{
+		Mode:     modeHTTP,
+		Method:   "GET",
+		Interval: Duration(time.Second),
+		Timeout:  Duration(5 * time.Second),
+		Headers:  []string{"X-Default: 1"},
+	}
+	tests := []struct {
+		t    Target
+		want Target
+	}{
+		{Target{URL: "example.com"},
+			Target{URL: "https://example.com", Mode: modeHTTP, Method: "GET", Interval: Duration(time.Second),
+				Timeout: Duration(5 * time.Second), Headers: []string{"X-Default: 1"}}},
+		{Target{URL: "http://example.com/", Method: "HEAD", Interval: Duration(time.Minute), Headers: []string{}},
+			Target{URL: "http://example.com/", Mode: modeHTTP, Method: "HEAD", Interval: Duration(time.Minute),
+				Timeout: Duration(5 * time.Second), Headers: []string{}}},
+		{Target{URL: "ftp://files.example.com/"},
+			Target{URL: "ftp://files.example.com/", Mode: modeFTP, Method: "GET", Interval: Duration(time.Second),
+				Timeout: Duration(5 * time.Second), Headers: []string{"X-Default: 1"}}},
+	}
+	for _, tt := range tests {
+		if got := tt.t.withDefaults(def); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("withDefaults(%+v) = %+v, want %+v", tt.t, got, tt.want)
+		}
+	}
+}
+
+func TestExpandEnv(t *testing.T) {
+	os.Setenv("HILICURL_TEST_HOST", "api.example.com")
+	os.Setenv("HILICURL_TEST_QUOTE", `a"b\c`)
+	os.Setenv("HILICURL_TEST_EMPTY", "")
+	defer os.Unsetenv("HILICURL_TEST_HOST")
+	defer os.Unsetenv("HILICURL_TEST_QUOTE")
+	defer os.Unsetenv("HILICURL_TEST_EMPTY")
+
+	tests := []struct {
+		s, want, wantJSON string
+	}{
+		{"https://${HILICURL_TEST_HOST}/health", "https://api.example.com/health", "https://api.example.com/health"},
+		{"token=${HILICURL_TEST_QUOTE}", `token=a"b\c`, `token=a\"b\\c`},
+		{"x${HILICURL_TEST_EMPTY}y", "xy", "xy"},
+		{"$HILICURL_TEST_HOST and ${1X}", "$HILICURL_TEST_HOST and ${1X}", "$HILICURL_TEST_HOST and ${1X}"},
+	}
+	for _, tt := range tests {
+		got, err := expandEnv(tt.s)
+		if err != nil || got != tt.want {
+			t.Errorf("expandEnv(%q) = %q, %v, want %q", tt.s, got, err, tt.want)
+		}
+		gotJSON, err := expandEnvJSON([]byte(tt.s))
+		if err != nil || string(gotJSON) != tt.wantJSON {
+			t.Errorf("expandEnvJSON(%q) = %q, %v, want %q", tt.s, gotJSON, err, tt.wantJSON)
+		}
+	}
+	if _, err := expandEnv("${HILICURL_TEST_UNSET}"); err == nil {
+		t.Error("expanding an unset variable succeeded, want an error")
+	}
+}
+
+func TestNearTimeout(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		near    float64
+		timeout time.Duration
+		d       time.Duration
+		want    bool
+		note    string
+	}{
+		{80, time.Second, 500 * ms, false, ""},
+		{80, time.Second, 800 * ms, true, " (80% of timeout)"},
+		{80, time.Second, 999 * ms, true, " (100% of timeout)"},
+		{0, time.Second, 999 * ms, false, ""},
+		{80, 0, time.Hour, false, ""},
+	}
+	for _, tt := range tests {
+		target := Target{NearTimeout: tt.near, Timeout: Duration(tt.timeout)}
+		got := target.nearTimeout(tt.d)
+		if got != tt.want {
+			t.Errorf("nearTimeout(%v) with %v%% of %v = %v, want %v", tt.d, tt.near, tt.timeout, got, tt.want)
+		}
+		if note := timeoutNote(target, Record{Total: tt.d, NearTimeout: got}); note != tt.note {
+			t.Errorf("timeoutNote for %v of %v = %q, want %q", tt.d, tt.timeout, note, tt.note)
+		}
+	}
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// domNode is a minimal element tree used for XPath and CSS assertions. The
+// document root has an empty name.
+type domNode struct {
+	name     string
+	attrs    map[string]string
+	parent   *domNode
+	children []*domNode
+}
+
+var rawTextElements = regexp.MustCompile(`(?is)(<script[^>]*>).*?(</script>)|(<style[^>]*>).*?(</style>)`)
+
+// parseDOM builds an element tree from an XML document or, when html is
+// set, from an HTML page using the lenient mode of encoding/xml. HTML parse
+// errors are not fatal: whatever was read before the error is kept.
+func parseDOM(body []byte, html bool) (*domNode, error) {
+	if html {
+		// Script and style contents are not markup and would derail the
+		// XML tokenizer.
+		body = rawTextElements.ReplaceAll(body, []byte("$1$2$3$4"))
+	}
+
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	if html {
+		dec.Strict = false
+		dec.AutoClose = xml.HTMLAutoClose
+		dec.Entity = xml.HTMLEntity
+	}
+
+	name := func(n xml.Name) string {
+		if html {
+			return strings.ToLower(n.Local)
+		}
+		return n.Local
+	}
+
+	root := &domNode{}
+	cur := root
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return root, nil
+		}
+		if err != nil {
+			if html {
+				return root, nil
+			}
+			return nil, err
+		}
+
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			n := &domNode{name: name(tok.Name), attrs: make(map[string]string), parent: cur}
+			for _, a := range tok.Attr {
+				n.attrs[name(a.Name)] = a.Value
+			}
+			cur.children = append(cur.children, n)
+			cur = n
+		case xml.EndElement:
+			for n := cur; n.parent != nil; n = n.parent {
+				if n.name == name(tok.Name) {
+					cur = n.parent
+					break
+				}
+			}
+		}
+	}
+}
+
+// subtree returns n and all of its descendants in document order.
+func (n *domNode) subtree() []*domNode {
+	nodes := []*domNode{n}
+	for _, c := range n.children {
+		nodes = append(nodes, c.subtree()...)
+	}
+	return nodes
+}
+
+func (n *domNode) hasClass(class string) bool {
+	for _, c := range strings.Fields(n.attrs["class"]) {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// attrTest matches [@name], [@name='value'] in XPath and [name],
+// [name=value] in CSS.
+type attrTest struct {
+	name     string
+	value    string
+	hasValue bool
+}
+
+func parseAttrTest(s string) attrTest {
+	name, value, hasValue := s, "", false
+	if i := strings.Index(s, "="); i >= 0 {
+		name, value, hasValue = s[:i], strings.Trim(s[i+1:], `'"`), true
+	}
+	return attrTest{name: strings.TrimSpace(name), value: value, hasValue: hasValue}
+}
+
+func (a attrTest) matches(n *domNode) bool {
+	v, ok := n.attrs[a.name]
+	return ok && (!a.hasValue || v == a.value)
+}
+
+// xpath is the supported XPath subset: absolute location paths built from
+// "/" and "//" steps with a name or "*" test, [@attr], [@attr='v'] and [n]
+// predicates, and an optional final @attr step.
+type xpath []xpathStep
+
+type xpathStep struct {
+	descendant bool
+	name       string
+	attr       string
+	attrs      []attrTest
+	position   int
+}
+
+func parseXPath(expr string) (xpath, error) {
+	if !strings.HasPrefix(expr, "/") {
+		return nil, fmt.Errorf("xpath %q must start with /", expr)
+	}
+
+	var path xpath
+	rest := expr
+	for rest != "" {
+		var step xpathStep
+		switch {
+		case strings.HasPrefix(rest, "//"):
+			step.descendant, rest = true, rest[2:]
+		case strings.HasPrefix(rest, "/"):
+			rest = rest[1:]
+		default:
+			return nil, fmt.Errorf("invalid xpath %q", expr)
+		}
+
+		end := stepEnd(rest)
+		text := rest[:end]
+		rest = rest[end:]
+
+		if i := strings.Index(text, "["); i >= 0 {
+			for _, pred := range strings.Split(strings.TrimSuffix(text[i+1:], "]"), "][") {
+				if strings.HasPrefix(pred, "@") {
+					step.attrs = append(step.attrs, parseAttrTest(pred[1:]))
+					continue
+				}
+				n, err := strconv.Atoi(pred)
+				if err != nil || n < 1 {
+					return nil, fmt.Errorf("unsupported xpath predicate [%s] in %q", pred, expr)
+				}
+				step.position = n
+			}
+			text = text[:i]
+		}
+
+		switch {
+		case strings.HasPrefix(text, "@"):
+			if rest != "" {
+				return nil, fmt.Errorf("attribute step must be last in %q", expr)
+			}
+			step.attr = text[1:]
+		case text == "":
+			return nil, fmt.Errorf("empty step in xpath %q", expr)
+		default:
+			step.name = text
+		}
+		path = append(path, step)
+	}
+	return path, nil
+}
+
+// stepEnd returns the length of the step at the start of s, ignoring
+// slashes inside predicates.
+func stepEnd(s string) int {
+	depth := 0
+	for i, r := range s {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case '/':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+// find returns the nodes selected by the path. An attribute step selects the
+// elements carrying that attribute.
+func (p xpath) find(root *domNode) []*domNode {
+	nodes := []*domNode{root}
+	for _, step := range p {
+		seen := make(map[*domNode]bool)
+		var next []*domNode
+		for _, n := range nodes {
+			parents := []*domNode{n}
+			if step.descendant {
+				parents = n.subtree()
+			}
+			for _, parent := range parents {
+				for _, m := range step.apply(parent) {
+					if !seen[m] {
+						seen[m] = true
+						next = append(next, m)
+					}
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+func (s xpathStep) apply(parent *domNode) []*domNode {
+	if s.attr != "" {
+		if _, ok := parent.attrs[s.attr]; ok {
+			return []*domNode{parent}
+		}
+		return nil
+	}
+
+	var matched []*domNode
+	for _, c := range parent.children {
+		if s.name != "*" && c.name != s.name {
+			continue
+		}
+		ok := true
+		for _, a := range s.attrs {
+			ok = ok && a.matches(c)
+		}
+		if ok {
+			matched = append(matched, c)
+		}
+	}
+	if s.position > 0 {
+		if s.position > len(matched) {
+			return nil
+		}
+		return matched[s.position-1 : s.position]
+	}
+	return matched
+}
+
+// cssSelector is the supported CSS subset: compound selectors made of a tag
+// name or "*", #id, .class, [attr] and [attr=value], joined by descendant
+// (space) or child (>) combinators.
+type cssSelector []cssCompound
+
+type cssCompound struct {
+	child   bool // joined to the previous compound with ">"
+	tag     string
+	id      string
+	classes []string
+	attrs   []attrTest
+}
+
+func parseCSS(expr string) (cssSelector, error) {
+	var sel cssSelector
+	child := false
+	rest := strings.TrimSpace(expr)
+	for rest != "" {
+		if rest[0] == '>' {
+			if child || len(sel) == 0 {
+				return nil, fmt.Errorf("invalid css selector %q", expr)
+			}
+			child = true
+			rest = strings.TrimSpace(rest[1:])
+			continue
+		}
+
+		end := compoundEnd(rest)
+		c, err := parseCompound(rest[:end])
+		if err != nil {
+			return nil, fmt.Errorf("invalid css selector %q: %w", expr, err)
+		}
+		c.child = child
+		sel = append(sel, c)
+		child = false
+		rest = strings.TrimSpace(rest[end:])
+	}
+	if len(sel) == 0 || child {
+		return nil, fmt.Errorf("invalid css selector %q", expr)
+	}
+	return sel, nil
+}
+
+// compoundEnd returns the length of the compound selector at the start of s.
+func compoundEnd(s string) int {
+	inAttr := false
+	for i, r := range s {
+		switch {
+		case r == '[':
+			inAttr = true
+		case r == ']':
+			inAttr = false
+		case !inAttr && (r == ' ' || r == '\t' || r == '>'):
+			return i
+		}
+	}
+	return len(s)
+}
+
+func parseCompound(s string) (cssCompound, error) {
+	var c cssCompound
+	i := strings.IndexAny(s, "#.[")
+	if i < 0 {
+		i = len(s)
+	}
+	c.tag, s = strings.ToLower(s[:i]), s[i:]
+
+	for s != "" {
+		switch s[0] {
+		case '[':
+			end := strings.Index(s, "]")
+			if end < 0 {
+				return c, fmt.Errorf("unterminated attribute selector")
+			}
+			c.attrs = append(c.attrs, parseAttrTest(s[1:end]))
+			s = s[end+1:]
+		case '#', '.':
+			end := strings.IndexAny(s[1:], "#.[") + 1
+			if end == 0 {
+				end = len(s)
+			}
+			if s[0] == '#' {
+				c.id = s[1:end]
+			} else {
+				c.classes = append(c.classes, s[1:end])
+			}
+			s = s[end:]
+		default:
+			return c, fmt.Errorf("unexpected %q", s)
+		}
+	}
+	return c, nil
+}
+
+func (c cssCompound) matches(n *domNode) bool {
+	if n.name == "" || (c.tag != "" && c.tag != "*" && c.tag != n.name) {
+		return false
+	}
+	if c.id != "" && n.attrs["id"] != c.id {
+		return false
+	}
+	for _, class := range c.classes {
+		if !n.hasClass(class) {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		if !a.matches(n) {
+			return false
+		}
+	}
+	return true
+}
+
+// find returns the elements matched by the selector.
+func (s cssSelector) find(root *domNode) []*domNode {
+	var nodes []*domNode
+	for _, n := range root.subtree() {
+		if s.matchAt(n, len(s)-1) {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+func (s cssSelector) matchAt(n *domNode, i int) bool {
+	if n == nil || !s[i].matches(n) {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	if s[i].child {
+		return s.matchAt(n.parent, i-1)
+	}
+	for a := n.parent; a != nil; a = a.parent {
+		if s.matchAt(a, i-1) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testPage = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Shop</title>
+  <style>p > a { color: red; }</style>
+  <script>if (a < b && c > d) { x = "</p>"; }</script>
+</head>
+<body>
+  <div id="main" class="content wide">
+    <p class="intro">Hello<br>world</p>
+    <ul>
+      <li><a href="/one" data-id="1">One</a></li>
+      <li><a href="/two">Two</a></li>
+      <li class="last"><a href="/three">Three</a></li>
+    </ul>
+  </div>
+  <img src="logo.png">
+</body>
+</html>`
+
+func names(nodes []*domNode) string {
+	var s []string
+	for _, n := range nodes {
+		s = append(s, n.name)
+	}
+	return strings.Join(s, ",")
+}
+
+func TestParseDOMHTML(t *testing.T) {
+	root, err := parseDOM([]byte(testPage), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"script", "style", "img", "br"} {
+		if n := len(mustXPath(t, "//"+name).find(root)); n != 1 {
+			t.Errorf("found %d %s elements, want 1", n, name)
+		}
+	}
+	if n := len(mustXPath(t, "//li").find(root)); n != 3 {
+		t.Errorf("found %d li elements, want 3", n)
+	}
+}
+
+func mustXPath(t *testing.T, expr string) xpath {
+	t.Helper()
+	p, err := parseXPath(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestXPath(t *testing.T) {
+	root, err := parseDOM([]byte(testPage), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr string
+		want int
+	}{
+		{"/html/head/title", 1},
+		{"/html/body/div", 1},
+		{"//div[@id='main']", 1},
+		{"//div[@id='other']", 0},
+		{"//a", 3},
+		{"//a[@data-id]", 1},
+		{"//li[2]/a", 1},
+		{"//li[4]", 0},
+		{"//ul/*", 3},
+		{"//a/@href", 3},
+		{"//li[@class='last']/a[@href='/three']", 1},
+		{"/body", 0},
+	}
+	for _, tt := range tests {
+		if got := len(mustXPath(t, tt.expr).find(root)); got != tt.want {
+			t.Errorf("%s matched %d nodes, want %d", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseXPathErrors(t *testing.T) {
+	for _, expr := range []string{"html", "//", "/a/@b/c", "//a[last()]", "//a[0]"} {
+		if _, err := parseXPath(expr); err == nil {
+			t.Errorf("parseXPath(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestCSS(t *testing.T) {
+	root, err := parseDOM([]byte(testPage), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{"title", "title"},
+		{"style", "style"},
+		{"#main", "div"},
+		{"div.content.wide", "div"},
+		{"div.narrow", ""},
+		{".intro", "p"},
+		{"ul > li > a", "a,a,a"},
+		{"div a[href=/two]", "a"},
+		{"body > a", ""},
+		{"li.last a", "a"},
+		{"a[data-id]", "a"},
+		{"*#main > p", "p"},
+	}
+	for _, tt := range tests {
+		sel, err := parseCSS(tt.expr)
+		if err != nil {
+			t.Errorf("parseCSS(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := names(sel.find(root)); got != tt.want {
+			t.Errorf("%s matched %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestParseCSSErrors(t *testing.T) {
+	for _, expr := range []string{"", ">", "a >", "a > > b", "a[href"} {
+		if _, err := parseCSS(expr); err == nil {
+			t.Errorf("parseCSS(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestParseDOMXML(t *testing.T) {
+	root, err := parseDOM([]byte(`<?xml version="1.0"?><feed><Entry id="a"/><Entry id="b"/></feed>`), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(mustXPath(t, "/feed/Entry[@id='b']").find(root)); got != 1 {
+		t.Errorf("matched %d entries, want 1", got)
+	}
+	if _, err := parseDOM([]byte(`<feed><entry></feed>`), false); err == nil {
+		t.Error("parsing malformed XML succeeded, want an error")
+	}
+}
+
+func TestCheckExpectationsDOM(t *testing.T) {
+	target := Target{
+		ExpectXPath: []string{"//li[@class='last']", "//table"},
+		ExpectCSS:   []string{"#main .intro", "div.missing"},
+	}
+	failures := checkExpectations(target, "text/html; charset=utf-8", []byte(testPage))
+	want := "xpath //table: no match,css div.missing: no match"
+	if got := strings.Join(failures, ","); got != want {
+		t.Errorf("failures %q, want %q", got, want)
+	}
+
+	target = Target{ExpectXPath: []string{"/feed"}}
+	if failures := checkExpectations(target, "application/xml", []byte("<feed><entry>")); len(failures) != 1 {
+		t.Errorf("failures %q for malformed XML, want one", failures)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultMailSubject = `[hilicurl] {{.Target}} is {{.State}}`
+	defaultMailBody    = `{{.Target}} ({{.URL}}) is {{.State}} as of {{.Time.Format "2006-01-02 15:04:05 MST"}}.
+{{if eq .State "down"}}
+Down since {{.Since.Format "2006-01-02 15:04:05 MST"}} after {{.Failures}} failed probes.
+{{- if .Status}}
+Last status: {{.Status}}{{end}}
+{{- if .Error}}
+Last error: {{.Error}}{{end}}
+{{- if .BurnRate}}
+Error budget burn rate: {{printf "%.1f" .BurnRate}}x{{end}}
+{{else}}
+It was down for {{.Duration}}.
+{{end}}`
+)
+
+// emailNotifier sends alert events by mail. At most one down mail is sent
+// per minInterval; events in between are dropped and counted in the next
+// mail. Recovery is always mailed for targets whose down mail was sent, and
+// never for the others.
+type emailNotifier struct {
+	addr    string
+	auth    smtp.Auth
+	from    string
+	to      []string
+	subject *template.Template
+	body    *template.Template
+
+	minInterval time.Duration
+
+	mu         sync.Mutex
+	lastSent   time.Time
+	suppressed int
+	mailedDown map[string]bool
+}
+
+func newEmailNotifier(addr, user, password, from string, to []string, subject, body string, minInterval time.Duration) (*emailNotifier, error) {
+	if len(to) == 0 || from == "" {
+		return nil, fmt.Errorf("mail alerts need a sender and at least one recipient")
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp server %q: %w", addr, err)
+	}
+
+	n := &emailNotifier{
+		addr:        addr,
+		from:        from,
+		to:          to,
+		minInterval: minInterval,
+		mailedDown:  make(map[string]bool),
+	}
+	if user != "" {
+		n.auth = smtp.PlainAuth("", user, password, host)
+	}
+	if n.subject, err = template.New("subject").Parse(subject); err != nil {
+		return nil, fmt.Errorf("mail subject: %w", err)
+	}
+	if n.body, err = template.New("body").Parse(body); err != nil {
+		return nil, fmt.Errorf("mail body: %w", err)
+	}
+	return n, nil
+}
+
+func (n *emailNotifier) notify(ev alertEvent) error {
+	n.mu.Lock()
+	var send bool
+	if ev.State == stateUp {
+		send = n.mailedDown[ev.Target]
+		delete(n.mailedDown, ev.Target)
+	} else {
+		send = n.lastSent.IsZero() || time.Since(n.lastSent) >= n.minInterval
+		n.mailedDown[ev.Target] = send
+	}
+	if !send {
+		n.suppressed++
+		n.mu.Unlock()
+		return nil
+	}
+	suppressed := n.suppressed
+	n.lastSent, n.suppressed = time.Now(), 0
+	n.mu.Unlock()
+
+	var subject, body bytes.Buffer
+	if err := n.subject.Execute(&subject, ev); err != nil {
+		return fmt.Errorf("mail subject: %w", err)
+	}
+	if err := n.body.Execute(&body, ev); err != nil {
+		return fmt.Errorf("mail body: %w", err)
+	}
+	if suppressed > 0 {
+		fmt.Fprintf(&body, "\n%d earlier notifications were suppressed by rate limiting.\n", suppressed)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", strings.ReplaceAll(subject.String(), "\n", " "))
+	fmt.Fprintf(&msg, "Date: %s\r\n", ev.Time.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+
+	if err := smtp.SendMail(n.addr, n.auth, n.from, n.to, msg.Bytes()); err != nil {
+		return fmt.Errorf("sending mail: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// smtpServer accepts mail without authentication and sends the data of
+// each message on the returned channel.
+func smtpServer(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	mails := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			c := textproto.NewConn(conn)
+			c.PrintfLine("220 localhost ESMTP")
+			for {
+				line, err := c.ReadLine()
+				if err != nil {
+					break
+				}
+				switch verb := strings.ToUpper(strings.Fields(line + " ")[0]); verb {
+				case "DATA":
+					c.PrintfLine("354 go ahead")
+					data, _ := c.ReadDotBytes()
+					mails <- string(data)
+					c.PrintfLine("250 queued")
+				case "QUIT":
+					c.PrintfLine("221 bye")
+				default:
+					c.PrintfLine("250 ok")
+				}
+			}
+			c.Close()
+		}
+	}()
+	return ln.Addr().String(), mails
+}
+
+func TestEmailNotifier(t *testing.T) {
+	addr, mails := smtpServer(t)
+	n, err := newEmailNotifier(addr, "", "", "hilicurl@example.com", []string{"ops@example.com", "dev@example.com"},
+		defaultMailSubject, defaultMailBody, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	since := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	down := alertEvent{Target: "api", URL: "https://example.com/", State: stateDown, Time: since.Add(time.Minute),
+		Since: since, Failures: 3, Status: "503 Service Unavailable"}
+
+	if err := n.notify(down); err != nil {
+		t.Fatal(err)
+	}
+	mail := <-mails
+	for _, want := range []string{
+		"From: hilicurl@example.com\n",
+		"To: ops@example.com, dev@example.com\n",
+		"Subject: [hilicurl] api is down\n",
+		"Date: Mon, 01 Jan 2024 12:01:00 +0000\n",
+		"api (https://example.com/) is down as of 2024-01-01 12:01:00 UTC.\n",
+		"Down since 2024-01-01 12:00:00 UTC after 3 failed probes.\nLast status: 503 Service Unavailable\n",
+	} {
+		if !strings.Contains(mail, want) {
+			t.Errorf("mail lacks %q:\n%s", want, mail)
+		}
+	}
+	if strings.Contains(mail, "Last error") || strings.Contains(mail, "burn rate") {
+		t.Errorf("mail has an empty field:\n%s", mail)
+	}
+
+	// A second target going down within the interval is suppressed, and
+	// so is its recovery.
+	other := down
+	other.Target = "web"
+	up := alertEvent{Target: "web", State: stateUp, Time: since.Add(5 * time.Minute), Since: since, Duration: 4 * time.Minute}
+	for _, ev := range []alertEvent{other, up} {
+		if err := n.notify(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	up.Target = "api"
+	if err := n.notify(up); err != nil {
+		t.Fatal(err)
+	}
+	mail = <-mails
+	for _, want := range []string{"Subject: [hilicurl] api is up\n", "It was down for 4m0s.\n", "\n2 earlier notifications were suppressed"} {
+		if !strings.Contains(mail, want) {
+			t.Errorf("recovery mail lacks %q:\n%s", want, mail)
+		}
+	}
+	select {
+	case mail := <-mails:
+		t.Errorf("unexpected mail:\n%s", mail)
+	default:
+	}
+}
+
+func TestNewEmailNotifierErrors(t *testing.T) {
+	tests := []struct {
+		addr, from, subject string
+		to                  []string
+	}{
+		{"localhost:25", "", defaultMailSubject, []string{"ops@example.com"}},
+		{"localhost:25", "hilicurl@example.com", defaultMailSubject, nil},
+		{"localhost", "hilicurl@example.com", defaultMailSubject, []string{"ops@example.com"}},
+		{"localhost:25", "hilicurl@example.com", "{{.Target", []string{"ops@example.com"}},
+	}
+	for _, tt := range tests {
+		if _, err := newEmailNotifier(tt.addr, "", "", tt.from, tt.to, tt.subject, defaultMailBody, 0); err == nil {
+			t.Errorf("newEmailNotifier(%q, %q, %q, %q) succeeded, want an error", tt.addr, tt.from, tt.to, tt.subject)
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Error classes used in the per-request log and the summary breakdown.
+const (
+	errDNSNXDomain = "dns_nxdomain"
+	errDNSServFail = "dns_servfail"
+	errDNSTimeout  = "dns_timeout"
+	errDNSOther    = "dns_error"
+	errTimeout     = "timeout"
+	errCanceled    = "canceled"
+	errOther       = "error"
+
+	// Responses breaking the HTTP protocol, which usually points at a
+	// broken proxy or load balancer rather than a slow server.
+	errMalformed = "malformed_response"
+	errTruncated = "truncated_response"
+	errProtocol  = "protocol_error"
+)
+
+// classifyError maps a request error to one of the error classes.
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		switch {
+		case dnsErr.IsNotFound:
+			return errDNSNXDomain
+		case dnsErr.IsTimeout:
+			return errDNSTimeout
+		case strings.Contains(dnsErr.Err, "server misbehaving"):
+			// The resolver reports SERVFAIL and REFUSED answers this way.
+			return errDNSServFail
+		}
+		return errDNSOther
+	}
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return errTimeout
+	case errors.Is(err, context.Canceled):
+		return errCanceled
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return errTimeout
+	}
+	if class := protocolErrorClass(err); class != "" {
+		return class
+	}
+	return errOther
+}
+
+// http2ProtocolErrors are the HTTP/2 error codes of frames or streams that
+// violate the protocol.
+var http2ProtocolErrors = []string{
+	"PROTOCOL_ERROR", "FLOW_CONTROL_ERROR", "FRAME_SIZE_ERROR", "COMPRESSION_ERROR", "STREAM_CLOSED",
+}
+
+// protocolErrorClass returns the class of a response that could not be
+// parsed, ended early or violated the protocol otherwise, or "" for other
+// errors. Most parse errors of net/http are not exported, so they are
+// recognized by their message.
+func protocolErrorClass(err error) string {
+	var protoErr *http.ProtocolError
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		// The connection closed within the headers or body.
+		return errTruncated
+	}
+	if errors.As(err, &protoErr) {
+		return errProtocol
+	}
+
+	msg := err.Error()
+	for _, s := range []string{
+		"malformed HTTP", "malformed MIME header", "bad Content-Length", "transfer encoding",
+		"chunk", "server gave HTTP response to HTTPS client",
+	} {
+		if strings.Contains(msg, s) {
+			return errMalformed
+		}
+	}
+	for _, code := range http2ProtocolErrors {
+		if strings.Contains(msg, code) {
+			return errProtocol
+		}
+	}
+	return ""
+}
+
+// Request phases a timeout can be attributed to, in the order a request
+// passes through them.
+const (
+	phaseDial    = "dial"
+	phaseDNS     = "dns"
+	phaseConnect = "connect"
+	phaseTunnel  = "tunnel" // CONNECT request to a proxy
+	phaseTLS     = "tls"
+	phaseRequest = "request"
+	phaseServer  = "server"
+	phaseHeaders = "headers"
+	phaseBody    = "body"
+)
+
+// phaseTracker follows a request through its phases using httptrace hooks,
+// which may be called from several goroutines when dialing, and adds up the
+// time spent in each phase.
+type phaseTracker struct {
+	mu         sync.Mutex
+	phase      string
+	phaseStart time.Time
+	spent      map[string]time.Duration
+	firstByte  time.Time
+
+	// tunnelAfter is the dial step after which a CONNECT tunnel is opened.
+	tunnelAfter string
+}
+
+// phaseTrackerKey is the context key of the phaseTracker of a request.
+type phaseTrackerKey struct{}
+
+func newPhaseTracker() *phaseTracker {
+	return &phaseTracker{phase: phaseDial, phaseStart: time.Now(), spent: make(map[string]time.Duration)}
+}
+
+func (p *phaseTracker) set(phase string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	p.spent[p.phase] += now.Sub(p.phaseStart)
+	p.phase, p.phaseStart = phase, now
+	if phase == phaseHeaders && p.firstByte.IsZero() {
+		p.firstByte = now
+	}
+}
+
+// tunnel tells the tracker that the connection goes through a CONNECT
+// tunnel, opened after the dial step, the connect or the TLS handshake
+// with the proxy.
+func (p *phaseTracker) tunnel(after string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.tunnelAfter = after
+}
+
+// dialed notes the end of a dial step, which starts the tunnel phase if the
+// CONNECT request comes next.
+func (p *phaseTracker) dialed(step string) {
+	p.mu.Lock()
+	tunnel := step == p.tunnelAfter
+	if tunnel {
+		p.tunnelAfter = ""
+	}
+	p.mu.Unlock()
+	if tunnel {
+		p.set(phaseTunnel)
+	}
+}
+
+func (p *phaseTracker) get() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.phase
+}
+
+// record copies the phase timings into rec, including the phase still in
+// progress, which is the last one of probes that end with a TLS handshake.
+// TTFB is measured from the probe's StartTime.
+func (p *phaseTracker) record(rec *Record) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	spent := func(phase string) time.Duration {
+		if phase == p.phase {
+			return p.spent[phase] + time.Since(p.phaseStart)
+		}
+		return p.spent[phase]
+	}
+	rec.DNS = spent(phaseDNS)
+	rec.Connect = spent(phaseConnect)
+	rec.TLS = spent(phaseTLS)
+	rec.Tunnel = spent(phaseTunnel)
+	if !p.firstByte.IsZero() {
+		rec.TTFB = p.firstByte.Sub(rec.StartTime)
+	}
+}
+
+// errorLabel describes a failed probe for the log, including the phase a
+// timeout happened in.
+func errorLabel(rec Record) string {
+	class := classifyError(rec.Err)
+	if rec.TimeoutPhase != "" {
+		return class + " during " + rec.TimeoutPhase
+	}
+	return class
+}
+
+// isDNSFailure reports whether class means the name could not be resolved
+// because of the DNS answer itself rather than a network problem.
+func isDNSFailure(class string) bool {
+	return class == errDNSNXDomain || class == errDNSServFail
+}
+
+// formatErrorCounts renders an error breakdown as "class=n" pairs sorted by
+// class name.
+func formatErrorCounts(counts map[string]int) string {
+	classes := make([]string, 0, len(counts))
+	for class := range counts {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = class + "=" + strconv.Itoa(counts[class])
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{&net.DNSError{Err: "no such host", Name: "nx.example.com", IsNotFound: true}, errDNSNXDomain},
+		{&net.DNSError{Err: "server misbehaving", Name: "example.com"}, errDNSServFail},
+		{&net.DNSError{Err: "i/o timeout", Name: "example.com", IsTimeout: true}, errDNSTimeout},
+		{&net.DNSError{Err: "connection refused", Name: "example.com"}, errDNSOther},
+		{fmt.Errorf("dial tcp: %w", &net.DNSError{Err: "no such host", IsNotFound: true}), errDNSNXDomain},
+		{context.DeadlineExceeded, errTimeout},
+		{fmt.Errorf("Get \"https://example.com/\": %w", context.DeadlineExceeded), errTimeout},
+		{&net.OpError{Op: "read", Err: timeoutError{}}, errTimeout},
+		{context.Canceled, errCanceled},
+		{errors.New("connection refused"), errOther},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err); got != tt.want {
+			t.Errorf("classifyError(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+// timeoutError is a net.Error that timed out.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestFormatErrorCounts(t *testing.T) {
+	counts := map[string]int{errTimeout: 2, errDNSNXDomain: 1, errOther: 3}
+	if got, want := formatErrorCounts(counts), "dns_nxdomain=1 error=3 timeout=2"; got != want {
+		t.Errorf("formatErrorCounts = %q, want %q", got, want)
+	}
+	if got := formatErrorCounts(nil); got != "" {
+		t.Errorf("formatErrorCounts(nil) = %q, want empty", got)
+	}
+}
+
+func TestSummarizeTimeoutRate(t *testing.T) {
+	start := time.Now()
+	run := Run{Start: start, End: start.Add(time.Minute), Records: []Record{
+		{Responded: true, StatusCode: 200},
+		{Err: context.DeadlineExceeded},
+		{Err: &net.DNSError{Err: "no such host", IsNotFound: true}},
+		{Err: errors.New("connection refused")},
+	}}
+	s := summarize(run)
+	if s.TimeoutRate != 25 {
+		t.Errorf("timeout rate %v%%, want 25%%", s.TimeoutRate)
+	}
+	if s.Errors[errDNSNXDomain] != 1 || s.Errors[errTimeout] != 1 || s.Errors[errOther] != 1 {
+		t.Errorf("errors %v, want one of each class", s.Errors)
+	}
+}
+
+func TestErrorLabel(t *testing.T) {
+	tests := []struct {
+		rec  Record
+		want string
+	}{
+		{Record{Err: context.DeadlineExceeded, TimeoutPhase: phaseTLS}, "timeout during tls"},
+		{Record{Err: context.DeadlineExceeded}, "timeout"},
+		{Record{Err: errors.New("connection refused")}, "error"},
+	}
+	for _, tt := range tests {
+		if got := errorLabel(tt.rec); got != tt.want {
+			t.Errorf("errorLabel(%v in %q) = %q, want %q", tt.rec.Err, tt.rec.TimeoutPhase, got, tt.want)
+		}
+	}
+}
+
+func TestPhaseTracker(t *testing.T) {
+	p := newPhaseTracker()
+	rec := Record{StartTime: time.Now()}
+	for _, phase := range []string{phaseDNS, phaseConnect, phaseTLS, phaseRequest, phaseServer, phaseHeaders, phaseBody} {
+		time.Sleep(time.Millisecond)
+		p.set(phase)
+	}
+	if got := p.get(); got != phaseBody {
+		t.Errorf("phase %q, want %q", got, phaseBody)
+	}
+	p.record(&rec)
+	if rec.DNS <= 0 || rec.Connect <= 0 || rec.TLS <= 0 || rec.Tunnel != 0 {
+		t.Errorf("dns %v, connect %v, tls %v, tunnel %v, want all but the tunnel set", rec.DNS, rec.Connect, rec.TLS, rec.Tunnel)
+	}
+	if rec.TTFB < rec.DNS+rec.Connect+rec.TLS {
+		t.Errorf("ttfb %v shorter than the phases before it", rec.TTFB)
+	}
+}
+
+func TestTimeoutPhase(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer srv.Close()
+
+	target := Target{URL: srv.URL, Mode: modeHTTP, Method: http.MethodGet, Timeout: Duration(5 * time.Second)}
+	prober, _ := newProber(target, log.New(ioutil.Discard, "", 0))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	rec := prober.Probe(ctx)
+	if classifyError(rec.Err) != errTimeout || rec.TimeoutPhase != phaseServer {
+		t.Errorf("error %v in phase %q, want a timeout in the %s phase", rec.Err, rec.TimeoutPhase, phaseServer)
+	}
+}
+
+func TestPhaseTrackerInProgress(t *testing.T) {
+	// A TLS probe ends during the handshake phase.
+	p := newPhaseTracker()
+	p.set(phaseConnect)
+	time.Sleep(time.Millisecond)
+	p.set(phaseTLS)
+	time.Sleep(2 * time.Millisecond)
+	var rec Record
+	p.record(&rec)
+	if rec.Connect <= 0 || rec.TLS < 2*time.Millisecond {
+		t.Errorf("connect %v, tls %v, want the handshake in progress counted", rec.Connect, rec.TLS)
+	}
+}
+
+func TestProtocolErrorClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{fmt.Errorf("reading body: %w", io.ErrUnexpectedEOF), errTruncated},
+		{&http.ProtocolError{ErrorString: "missing form body"}, errProtocol},
+		{errors.New(`net/http: HTTP/1.x transport connection broken: malformed HTTP status code "abc"`), errMalformed},
+		{errors.New("http: server gave HTTP response to HTTPS client"), errMalformed},
+		{errors.New("stream error: stream ID 1; PROTOCOL_ERROR"), errProtocol},
+		{errors.New("connection refused"), ""},
+	}
+	for _, tt := range tests {
+		if got := protocolErrorClass(tt.err); got != tt.want {
+			t.Errorf("protocolErrorClass(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+// TestProtocolErrorProbe checks the class of errors of probes against a
+// server sending broken responses.
+func TestProtocolErrorProbe(t *testing.T) {
+	tests := []struct {
+		response string
+		want     string
+	}{
+		{"HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\nshort", errTruncated},
+		{"HTTP/1.1 abc OK\r\n\r\n", errMalformed},
+		{"HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n", errMalformed},
+	}
+	for _, tt := range tests {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		go func(response string) {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 4096)
+			conn.Read(buf)
+			conn.Write([]byte(response))
+			conn.Close()
+		}(tt.response)
+
+		target := Target{URL: "http://" + ln.Addr().String() + "/", Mode: modeHTTP, Method: http.MethodGet,
+			Timeout: Duration(5 * time.Second)}
+		prober, _ := newProber(target, log.New(ioutil.Discard, "", 0))
+		rec := prober.Probe(context.Background())
+		ln.Close()
+		if got := classifyError(rec.Err); rec.Err == nil || got != tt.want {
+			t.Errorf("%q: error %v of class %q, want %q", tt.response, rec.Err, got, tt.want)
+		}
+	}
+}
+
+func TestPhaseTrackerTunnel(t *testing.T) {
+	p := newPhaseTracker()
+	p.tunnel(phaseTLS)
+	p.set(phaseConnect)
+	p.dialed(phaseConnect)
+	if got := p.get(); got != phaseConnect {
+		t.Errorf("phase %q after connecting to an https proxy, want %q", got, phaseConnect)
+	}
+	p.set(phaseTLS)
+	p.dialed(phaseTLS)
+	if got := p.get(); got != phaseTunnel {
+		t.Errorf("phase %q after the handshake with the proxy, want %q", got, phaseTunnel)
+	}
+	time.Sleep(time.Millisecond)
+	// The handshake with the target does not start another tunnel.
+	p.set(phaseTLS)
+	p.dialed(phaseTLS)
+	if got := p.get(); got != phaseTLS {
+		t.Errorf("phase %q after the handshake with the target, want %q", got, phaseTLS)
+	}
+	var rec Record
+	p.record(&rec)
+	if rec.Tunnel < time.Millisecond {
+		t.Errorf("tunnel %v, want the time between the handshakes", rec.Tunnel)
+	}
+}
+
+func TestTunnelTimeout(t *testing.T) {
+	// The proxy accepts the CONNECT request but never answers it.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		ioutil.ReadAll(conn)
+	}()
+
+	target := Target{URL: "https://api.example.com/", Mode: modeHTTP, Method: http.MethodGet,
+		Proxy: ln.Addr().String(), Timeout: Duration(5 * time.Second)}
+	prober, _ := newProber(target, log.New(ioutil.Discard, "", 0))
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	rec := prober.Probe(ctx)
+	if classifyError(rec.Err) != errTimeout || rec.TimeoutPhase != phaseTunnel || rec.Tunnel <= 0 {
+		t.Errorf("error %v in phase %q after a tunnel of %v, want a timeout in the %s phase",
+			rec.Err, rec.TimeoutPhase, rec.Tunnel, phaseTunnel)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fleetReporter POSTs the health of every target to -report-url every
+// interval, so that the monitors of a fleet of hosts can be collected by a
+// central service. Each report covers the probes since the previous one and
+// carries the instance name and labels to tell the senders apart.
+type fleetReporter struct {
+	url      string
+	instance string
+	labels   map[string]string
+	interval time.Duration
+
+	mu      sync.Mutex
+	order   []string
+	targets map[string]*fleetTarget
+	last    time.Time
+	running int
+	done    chan struct{}
+}
+
+type fleetReport struct {
+	Instance string            `json:"instance"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Version  string            `json:"version"`
+	Start    time.Time         `json:"start"`
+	End      time.Time         `json:"end"`
+	Final    bool              `json:"final,omitempty"`
+	Targets  []fleetTargetData `json:"targets"`
+}
+
+type fleetTargetData struct {
+	Name          string         `json:"name"`
+	URL           string         `json:"url"`
+	Healthy       bool           `json:"healthy"`
+	LastStatus    int            `json:"last_status,omitempty"`
+	LastError     string         `json:"last_error,omitempty"`
+	Probes        int            `json:"probes"`
+	Failures      int            `json:"failures"`
+	Errors        map[string]int `json:"errors,omitempty"`
+	Availability  *float64       `json:"availability_pct,omitempty"`
+	MeanLatencyMS *float64       `json:"mean_latency_ms,omitempty"`
+	P50LatencyMS  *float64       `json:"p50_latency_ms,omitempty"`
+	P99LatencyMS  *float64       `json:"p99_latency_ms,omitempty"`
+}
+
+type fleetTarget struct {
+	data      fleetTargetData
+	latencies []time.Duration
+}
+
+func newFleetReporter(url, instance string, labels map[string]string, interval time.Duration) *fleetReporter {
+	return &fleetReporter{
+		url:      url,
+		instance: instance,
+		labels:   labels,
+		interval: interval,
+		targets:  make(map[string]*fleetTarget),
+		last:     time.Now(),
+	}
+}
+
+// parseLabels parses -instance-label arguments of the form key=value.
+func parseLabels(args []string) (map[string]string, error) {
+	labels := make(map[string]string, len(args))
+	for _, arg := range args {
+		i := strings.Index(arg, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", arg)
+		}
+		labels[arg[:i]] = arg[i+1:]
+	}
+	return labels, nil
+}
+
+func (f *fleetReporter) Start(t Target) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := targetName(t)
+	if _, ok := f.targets[name]; !ok {
+		f.targets[name] = &fleetTarget{data: fleetTargetData{Name: name, URL: t.URL}}
+		f.order = append(f.order, name)
+	}
+	f.running++
+	if f.running == 1 {
+		f.done = make(chan struct{})
+		go f.loop(f.done)
+	}
+	return nil
+}
+
+func (f *fleetReporter) loop(done chan struct{}) {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := f.send(false); err != nil {
+				log.Printf("ERROR: %v", err)
+			}
+		}
+	}
+}
+
+func (f *fleetReporter) Write(t Target, rec Record) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ft, ok := f.targets[targetName(t)]
+	if !ok {
+		return nil
+	}
+	d := &ft.data
+	d.Probes++
+	d.Healthy = probeOK(rec)
+	d.LastStatus, d.LastError = rec.StatusCode, ""
+	switch {
+	case rec.Err != nil:
+		d.LastError = rec.Err.Error()
+		if d.Errors == nil {
+			d.Errors = make(map[string]int)
+		}
+		d.Errors[classifyError(rec.Err)]++
+	case len(rec.Failures) > 0:
+		d.LastError = rec.Failures[0]
+	}
+	if d.Healthy {
+		ft.latencies = append(ft.latencies, rec.Total)
+	} else {
+		d.Failures++
+	}
+	return nil
+}
+
+// send posts the probes since the last report and starts a new one.
+func (f *fleetReporter) send(final bool) error {
+	f.mu.Lock()
+	now := time.Now()
+	report := fleetReport{
+		Instance: f.instance,
+		Labels:   f.labels,
+		Version:  toolVersion(),
+		Start:    f.last,
+		End:      now,
+		Final:    final,
+		Targets:  make([]fleetTargetData, 0, len(f.order)),
+	}
+	for _, name := range f.order {
+		ft := f.targets[name]
+		d := ft.data
+		if d.Probes > 0 {
+			availability := float64(d.Probes-d.Failures) / float64(d.Probes) * 100
+			d.Availability = &availability
+		}
+		if l := ft.latencies; len(l) > 0 {
+			sort.Slice(l, func(i, j int) bool { return l[i] < l[j] })
+			var sum time.Duration
+			for _, v := range l {
+				sum += v
+			}
+			d.MeanLatencyMS = durationMS(sum / time.Duration(len(l)))
+			d.P50LatencyMS, d.P99LatencyMS = durationMS(percentile(l, 50)), durationMS(percentile(l, 99))
+		}
+		report.Targets = append(report.Targets, d)
+
+		// The health and last outcome carry over to the next report.
+		ft.data = fleetTargetData{Name: d.Name, URL: d.URL, Healthy: d.Healthy,
+			LastStatus: d.LastStatus, LastError: d.LastError}
+		ft.latencies = nil
+	}
+	f.last = now
+	f.mu.Unlock()
+
+	if err := postJSON(f.url, nil, report); err != nil {
+		return fmt.Errorf("fleet report: %w", err)
+	}
+	return nil
+}
+
+// Flush sends the final report once every target has finished.
+func (f *fleetReporter) Flush(t Target, s Summary) error {
+	f.mu.Lock()
+	f.running--
+	last := f.running == 0
+	if last {
+		close(f.done)
+	}
+	f.mu.Unlock()
+	if !last {
+		return nil
+	}
+	return f.send(true)
+}
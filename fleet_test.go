@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := parseLabels([]string{"region=eu-west-1", "role=edge", "note=a=b", "empty="})
+	if err != nil || len(labels) != 4 || labels["region"] != "eu-west-1" || labels["note"] != "a=b" || labels["empty"] != "" {
+		t.Errorf("parseLabels = %v, %v", labels, err)
+	}
+	for _, arg := range []string{"region", "=eu-west-1"} {
+		if _, err := parseLabels([]string{arg}); err == nil {
+			t.Errorf("parseLabels(%q) succeeded, want an error", arg)
+		}
+	}
+}
+
+func TestFleetReporter(t *testing.T) {
+	reports := make(chan fleetReport, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var report fleetReport
+		if err := json.NewDecoder(r.Body).Decode(&report); err != nil {
+			t.Error(err)
+		}
+		reports <- report
+	}))
+	defer srv.Close()
+
+	f := newFleetReporter(srv.URL, "probe-1", map[string]string{"region": "eu"}, time.Hour)
+	api, web := Target{Name: "api", URL: "https://api.example.com/"}, Target{Name: "web", URL: "https://www.example.com/"}
+	f.Start(api)
+	f.Start(web)
+	ms := time.Millisecond
+	for _, total := range []time.Duration{10 * ms, 30 * ms, 20 * ms} {
+		f.Write(api, Record{Responded: true, StatusCode: 200, Total: total})
+	}
+	f.Write(api, Record{Err: errors.New("connection refused")})
+	f.Write(web, Record{Responded: true, StatusCode: 200, Total: 5 * ms})
+
+	if err := f.send(false); err != nil {
+		t.Fatal(err)
+	}
+	report := <-reports
+	if report.Instance != "probe-1" || report.Labels["region"] != "eu" || report.Final || len(report.Targets) != 2 {
+		t.Fatalf("report %+v", report)
+	}
+	a := report.Targets[0]
+	if a.Name != "api" || a.Healthy || a.Probes != 4 || a.Failures != 1 || a.Errors[errOther] != 1 ||
+		a.LastError != "connection refused" || *a.Availability != 75 || *a.MeanLatencyMS != 20 ||
+		*a.P50LatencyMS != 20 || *a.P99LatencyMS != 30 {
+		t.Errorf("api %+v", a)
+	}
+
+	// The next report covers the later probes only; the final one is sent
+	// once both targets are flushed.
+	f.Write(web, Record{Responded: true, StatusCode: 503})
+	f.Flush(api, Summary{})
+	select {
+	case report := <-reports:
+		t.Fatalf("report %+v sent before every target finished", report)
+	default:
+	}
+	f.Flush(web, Summary{})
+	report = <-reports
+	if !report.Final || len(report.Targets) != 2 {
+		t.Fatalf("final report %+v", report)
+	}
+	a, w := report.Targets[0], report.Targets[1]
+	if a.Probes != 0 || a.Healthy || a.LastError != "connection refused" || a.Availability != nil || a.MeanLatencyMS != nil {
+		t.Errorf("api without probes %+v", a)
+	}
+	if w.Probes != 1 || w.Healthy || w.LastStatus != 503 || *w.Availability != 0 {
+		t.Errorf("web %+v", w)
+	}
+}
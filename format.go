@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Display units for -units.
+const (
+	unitsAuto = "auto"
+	unitsMS   = "ms"
+	unitsS    = "s"
+)
+
+// displayUnits selects how durations are printed. It is set once from the
+// command line before probing starts.
+var displayUnits = unitsAuto
+
+// formatDuration renders d in the configured display units. In auto mode
+// the unit follows the magnitude, so both sub-millisecond and multi-second
+// responses stay readable.
+func formatDuration(d time.Duration) string {
+	switch displayUnits {
+	case unitsMS:
+		return fmt.Sprintf("%.2f ms", float64(d)/float64(time.Millisecond))
+	case unitsS:
+		return fmt.Sprintf("%.3f s", d.Seconds())
+	}
+
+	switch {
+	case d < time.Millisecond:
+		return fmt.Sprintf("%d µs", d.Microseconds())
+	case d < time.Second:
+		return fmt.Sprintf("%.1f ms", float64(d)/float64(time.Millisecond))
+	}
+	return fmt.Sprintf("%.2f s", d.Seconds())
+}
+
+// formatBytes renders a byte count with a binary KB, MB or GB suffix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit && exp < 2; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMG"[exp])
+}
+
+func validUnits(units string) bool {
+	return units == unitsAuto || units == unitsMS || units == unitsS
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatDuration(t *testing.T) {
+	defer func(u string) { displayUnits = u }(displayUnits)
+	tests := []struct {
+		units string
+		d     time.Duration
+		want  string
+	}{
+		{unitsAuto, 750 * time.Microsecond, "750 µs"},
+		{unitsAuto, 12345 * time.Microsecond, "12.3 ms"},
+		{unitsAuto, 2500 * time.Millisecond, "2.50 s"},
+		{unitsMS, 2500 * time.Millisecond, "2500.00 ms"},
+		{unitsMS, 750 * time.Microsecond, "0.75 ms"},
+		{unitsS, 12345 * time.Microsecond, "0.012 s"},
+	}
+	for _, tt := range tests {
+		displayUnits = tt.units
+		if got := formatDuration(tt.d); got != tt.want {
+			t.Errorf("formatDuration(%v) in %s = %q, want %q", tt.d, tt.units, got, tt.want)
+		}
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KB"},
+		{5 << 20, "5.0 MB"},
+		{3 << 30, "3.0 GB"},
+		{2048 << 30, "2048.0 GB"},
+	}
+	for _, tt := range tests {
+		if got := formatBytes(tt.n); got != tt.want {
+			t.Errorf("formatBytes(%d) = %q, want %q", tt.n, got, tt.want)
+		}
+	}
+}
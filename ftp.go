@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ftpProber logs in to an FTP server and fetches the file named by the URL
+// path, or lists the directory when the path is empty or ends with a slash.
+// Without user information in the URL it logs in anonymously.
+type ftpProber struct {
+	t      Target
+	logger *log.Logger
+	dial   dialFunc
+}
+
+func (p *ftpProber) Probe(ctx context.Context) Record {
+	s := startProbe()
+	ctx = s.trace(ctx, p.logger, p.t)
+
+	u, err := url.Parse(p.t.URL)
+	if err != nil {
+		return s.fail(err)
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "21")
+	}
+
+	conn, err := p.dial(ctx, "tcp", addr)
+	if err != nil {
+		return s.fail(err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c := &ftpConn{Conn: textproto.NewConn(conn), s: s}
+
+	s.phase.set(phaseServer)
+	if _, err := c.reply(2); err != nil {
+		return s.fail(err)
+	}
+	s.phase.set(phaseRequest)
+	if err := c.login(u); err != nil {
+		return s.fail(err)
+	}
+	if _, err := c.cmd(2, "TYPE I"); err != nil {
+		return s.fail(err)
+	}
+
+	dataAddr, err := c.passive(conn.RemoteAddr())
+	if err != nil {
+		return s.fail(err)
+	}
+	data, err := p.dial(ctx, "tcp", dataAddr)
+	if err != nil {
+		return s.fail(err)
+	}
+	defer data.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = data.SetDeadline(deadline)
+	}
+
+	command := "RETR " + u.Path
+	if u.Path == "" || strings.HasSuffix(u.Path, "/") {
+		command = "NLST " + u.Path
+	}
+	if _, err := c.cmd(1, "%s", strings.TrimSpace(command)); err != nil {
+		return s.fail(err)
+	}
+	s.phase.set(phaseBody)
+	n, err := io.Copy(ioutil.Discard, data)
+	s.rec.BytesRead = n
+	data.Close()
+	if err != nil {
+		return s.fail(err)
+	}
+	if _, err := c.reply(2); err != nil {
+		return s.fail(err)
+	}
+	rec := s.done(p.t)
+	_ = c.PrintfLine("QUIT")
+	return rec
+}
+
+// ftpConn is the control connection. Replies are recorded as the status of
+// the probe, so the last one is reported.
+type ftpConn struct {
+	*textproto.Conn
+	s *probeState
+}
+
+// reply reads a reply and fails unless its first digit is class, if
+// non-zero.
+func (c *ftpConn) reply(class int) (string, error) {
+	code, msg, err := c.ReadResponse(0)
+	if err != nil {
+		return "", err
+	}
+	if c.s.rec.TTFB == 0 {
+		c.s.rec.TTFB = time.Since(c.s.rec.StartTime)
+	}
+	c.s.rec.StatusCode, c.s.rec.Status = code, fmt.Sprintf("%d %s", code, msg)
+	c.s.rec.Responded = true
+	if class != 0 && code/100 != class {
+		return msg, fmt.Errorf("ftp: %d %s", code, msg)
+	}
+	return msg, nil
+}
+
+func (c *ftpConn) cmd(class int, format string, args ...interface{}) (string, error) {
+	if err := c.PrintfLine(format, args...); err != nil {
+		return "", err
+	}
+	return c.reply(class)
+}
+
+func (c *ftpConn) login(u *url.URL) error {
+	user, pass := "anonymous", "hilicurl@"
+	if u.User != nil {
+		user = u.User.Username()
+		pass, _ = u.User.Password()
+	}
+	msg, err := c.cmd(0, "USER %s", user)
+	if err != nil {
+		return err
+	}
+	switch c.s.rec.StatusCode {
+	case 230:
+		return nil
+	case 331:
+		_, err := c.cmd(2, "PASS %s", pass)
+		return err
+	}
+	return fmt.Errorf("ftp: %d %s", c.s.rec.StatusCode, msg)
+}
+
+// passive asks for a data connection address, trying EPSV before PASV.
+// The host of the control connection is used for both.
+func (c *ftpConn) passive(control net.Addr) (string, error) {
+	host, _, err := net.SplitHostPort(control.String())
+	if err != nil {
+		return "", err
+	}
+
+	if msg, err := c.cmd(2, "EPSV"); err == nil {
+		// 229 Entering Extended Passive Mode (|||port|)
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start >= 0 && end > start+4 {
+			if port, err := strconv.Atoi(msg[start+4 : end]); err == nil {
+				return net.JoinHostPort(host, strconv.Itoa(port)), nil
+			}
+		}
+	}
+
+	msg, err := c.cmd(2, "PASV")
+	if err != nil {
+		return "", err
+	}
+	// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("ftp: invalid PASV reply %q", msg)
+	}
+	parts := strings.Split(msg[start+1:end], ",")
+	if len(parts) != 6 {
+		return "", fmt.Errorf("ftp: invalid PASV reply %q", msg)
+	}
+	p1, err1 := strconv.Atoi(strings.TrimSpace(parts[4]))
+	p2, err2 := strconv.Atoi(strings.TrimSpace(parts[5]))
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("ftp: invalid PASV reply %q", msg)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p1<<8|p2)), nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ftpServer serves files from a map to one control connection at a time,
+// with the password "secret" for every user but anonymous. Without epsv it
+// only offers PASV data connections.
+func ftpServer(t *testing.T, files map[string]string, epsv bool) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			serveFTP(textproto.NewConn(conn), files, epsv)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveFTP(c *textproto.Conn, files map[string]string, epsv bool) {
+	defer c.Close()
+	var user string
+	var data net.Listener
+	c.PrintfLine("220 test server ready")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg := line, ""
+		if i := strings.Index(line, " "); i >= 0 {
+			verb, arg = line[:i], line[i+1:]
+		}
+		switch verb {
+		case "USER":
+			user = arg
+			c.PrintfLine("331 password required")
+		case "PASS":
+			if user != "anonymous" && arg != "secret" {
+				c.PrintfLine("530 login incorrect")
+				continue
+			}
+			c.PrintfLine("230 logged in")
+		case "TYPE":
+			c.PrintfLine("200 type set")
+		case "EPSV", "PASV":
+			if verb == "EPSV" && !epsv {
+				c.PrintfLine("502 not implemented")
+				continue
+			}
+			data, _ = net.Listen("tcp", "127.0.0.1:0")
+			port := data.Addr().(*net.TCPAddr).Port
+			if verb == "EPSV" {
+				c.PrintfLine("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				c.PrintfLine("227 Entering Passive Mode (127,0,0,1,%d,%d)", port>>8, port&0xff)
+			}
+		case "RETR", "NLST":
+			body, ok := files[arg]
+			if verb == "NLST" {
+				body, ok = strings.Join(fileNames(files), "\r\n"), true
+			}
+			if !ok {
+				data.Close()
+				c.PrintfLine("550 %s: no such file", arg)
+				continue
+			}
+			c.PrintfLine("150 opening data connection")
+			conn, err := data.Accept()
+			data.Close()
+			if err != nil {
+				return
+			}
+			fmt.Fprint(conn, body)
+			conn.Close()
+			c.PrintfLine("226 transfer complete")
+		case "QUIT":
+			c.PrintfLine("221 bye")
+			return
+		default:
+			c.PrintfLine("502 %s not implemented", verb)
+		}
+	}
+}
+
+func fileNames(files map[string]string) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestFTPProbe(t *testing.T) {
+	files := map[string]string{"/pub/file.txt": "hello, world\n"}
+	tests := []struct {
+		url    string
+		epsv   bool
+		bytes  int64
+		status string
+		err    string
+	}{
+		{"ftp://%s/pub/file.txt", true, 13, "226 transfer complete", ""},
+		{"ftp://%s/pub/file.txt", false, 13, "226 transfer complete", ""},
+		{"ftp://alice:secret@%s/pub/file.txt", true, 13, "226 transfer complete", ""},
+		{"ftp://%s/", true, 13, "226 transfer complete", ""},
+		{"ftp://alice:guess@%s/pub/file.txt", true, 0, "530 login incorrect", "ftp: 530 login incorrect"},
+		{"ftp://%s/missing.txt", true, 0, "550 /missing.txt: no such file", "ftp: 550 /missing.txt: no such file"},
+	}
+	for _, tt := range tests {
+		addr := ftpServer(t, files, tt.epsv)
+		target := Target{URL: fmt.Sprintf(tt.url, addr), Mode: modeFTP, Timeout: Duration(5 * time.Second)}
+		prober, _ := newProber(target, log.New(ioutil.Discard, "", 0))
+		rec := prober.Probe(context.Background())
+		if rec.BytesRead != tt.bytes || rec.Status != tt.status || (rec.Err == nil) != (tt.err == "") ||
+			(rec.Err != nil && rec.Err.Error() != tt.err) {
+			t.Errorf("%s (epsv %v): %d bytes, status %q, error %v, want %d, %q, %q",
+				target.URL, tt.epsv, rec.BytesRead, rec.Status, rec.Err, tt.bytes, tt.status, tt.err)
+		}
+	}
+}
+
+func TestValidateModeFTP(t *testing.T) {
+	tests := []struct {
+		target Target
+		err    string
+	}{
+		{Target{Mode: modeFTP, URL: "ftp://ftp.example.com/pub/"}, ""},
+		{Target{Mode: modeFTP, URL: "https://ftp.example.com/pub/"}, "ftp mode needs an ftp:// URL"},
+		{Target{Mode: modeHTTP, URL: "sftp://ftp.example.com/pub/"}, "sftp:// URLs are not supported"},
+	}
+	for _, tt := range tests {
+		err := tt.target.validateMode()
+		if (err == nil) != (tt.err == "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("%s %s: error %v, want %q", tt.target.Mode, tt.target.URL, err, tt.err)
+		}
+	}
+}
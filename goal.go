@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// goalFailedExitCode is the exit status when a target misses the -goal.
+const goalFailedExitCode = 1
+
+// goal is a -goal expression: comparisons of the final statistics of a
+// target joined with &&, like "p99<250ms && errors==0".
+type goal struct {
+	expr    string
+	clauses []goalClause
+}
+
+type goalClause struct {
+	metric string
+	op     string
+	value  float64
+	// text is the clause as written, to report it.
+	text string
+}
+
+// goalMetrics lists the statistics a goal may compare besides percentiles
+// like p99 or p99.9. Latencies are in milliseconds, rates in percent.
+var goalMetrics = map[string]bool{
+	"mean": true, "max": true,
+	"requests": true, "responses": true, "errors": true, "timeouts": true, "failures": true,
+	"error_rate": true, "availability": true,
+}
+
+var goalOps = []string{"<=", ">=", "==", "!=", "<", ">"}
+
+func parseGoal(expr string) (*goal, error) {
+	g := &goal{expr: expr}
+	for _, text := range strings.Split(expr, "&&") {
+		text = strings.TrimSpace(text)
+		c := goalClause{text: text}
+		for _, op := range goalOps {
+			if i := strings.Index(text, op); i > 0 {
+				c.metric, c.op = strings.TrimSpace(text[:i]), op
+				text = strings.TrimSpace(text[i+len(op):])
+				break
+			}
+		}
+		if c.op == "" {
+			return nil, fmt.Errorf("invalid goal %q, expected metric, operator and value like p99<250ms", c.text)
+		}
+
+		if isLatencyMetric(c.metric) {
+			if _, err := percentileOf(c.metric); c.metric[0] == 'p' && err != nil {
+				return nil, err
+			}
+			d, err := time.ParseDuration(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid goal %q: %w", c.text, err)
+			}
+			c.value = float64(d) / float64(time.Millisecond)
+		} else if goalMetrics[c.metric] {
+			v, err := strconv.ParseFloat(strings.TrimSuffix(text, "%"), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid goal %q: %w", c.text, err)
+			}
+			c.value = v
+		} else {
+			return nil, fmt.Errorf("invalid goal %q: unknown metric %q", c.text, c.metric)
+		}
+		g.clauses = append(g.clauses, c)
+	}
+	return g, nil
+}
+
+func isLatencyMetric(metric string) bool {
+	return metric == "mean" || metric == "max" || strings.HasPrefix(metric, "p")
+}
+
+// percentileOf parses a percentile metric like p99 or p99.9.
+func percentileOf(metric string) (float64, error) {
+	p, err := strconv.ParseFloat(strings.TrimPrefix(metric, "p"), 64)
+	if err != nil || p <= 0 || p > 100 {
+		return 0, fmt.Errorf("invalid percentile %q", metric)
+	}
+	return p, nil
+}
+
+// percentile returns the nearest-rank percentile p of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// goalSink evaluates the goal for every target when its statistics are
+// flushed.
+type goalSink struct {
+	goal *goal
+
+	mu     sync.Mutex
+	failed bool
+}
+
+func newGoalSink(g *goal) *goalSink {
+	return &goalSink{goal: g}
+}
+
+func (s *goalSink) Write(t Target, rec Record) error {
+	return nil
+}
+
+func (s *goalSink) Flush(t Target, summary Summary) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pass := true
+	values := make([]string, 0, len(s.goal.clauses))
+	for _, c := range s.goal.clauses {
+		v, ok := goalValue(c.metric, summary)
+		if !ok {
+			pass = false
+			values = append(values, c.metric+"=n/a")
+			continue
+		}
+		if !compareGoal(v, c.op, c.value) {
+			pass = false
+		}
+		if isLatencyMetric(c.metric) {
+			values = append(values, c.metric+"="+formatDuration(time.Duration(v*float64(time.Millisecond))))
+		} else {
+			values = append(values, c.metric+"="+strconv.FormatFloat(v, 'f', -1, 64))
+		}
+	}
+
+	verdict := "PASS"
+	if !pass {
+		verdict = "FAIL"
+		s.failed = true
+	}
+	fmt.Printf("%s %s: %s (%s)\n", verdict, targetName(t), s.goal.expr, strings.Join(values, ", "))
+	return nil
+}
+
+// passed reports whether every target flushed so far met the goal.
+func (s *goalSink) passed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.failed
+}
+
+// goalValue returns a metric of a run. Latencies are those of the
+// successful probes, and errors the probes that did not succeed, whether
+// for lack of a response, an error status or a failed assertion. Timeouts
+// are those of the errors that ran out of time. Latency metrics are
+// unavailable without a successful probe.
+func goalValue(metric string, s Summary) (float64, bool) {
+	ms := func(d time.Duration) float64 { return float64(d) / float64(time.Millisecond) }
+	latencies := s.Latencies
+	errors := s.Requests - len(latencies)
+	switch metric {
+	case "requests":
+		return float64(s.Requests), true
+	case "responses":
+		return float64(s.Responses), true
+	case "errors":
+		return float64(errors), true
+	case "timeouts":
+		return float64(s.Errors[errTimeout]), true
+	case "failures":
+		return float64(s.AssertionFailures), true
+	case "error_rate", "availability":
+		if s.Requests == 0 {
+			return 0, false
+		}
+		rate := float64(errors) / float64(s.Requests) * 100
+		if metric == "availability" {
+			return 100 - rate, true
+		}
+		return rate, true
+	}
+
+	if len(latencies) == 0 {
+		return 0, false
+	}
+	switch metric {
+	case "mean":
+		var sum time.Duration
+		for _, d := range latencies {
+			sum += d
+		}
+		return ms(sum / time.Duration(len(latencies))), true
+	case "max":
+		return ms(latencies[len(latencies)-1]), true
+	}
+	p, _ := percentileOf(metric)
+	return ms(percentile(latencies, p)), true
+}
+
+func compareGoal(v float64, op string, want float64) bool {
+	switch op {
+	case "<":
+		return v < want
+	case "<=":
+		return v <= want
+	case ">":
+		return v > want
+	case ">=":
+		return v >= want
+	case "==":
+		return v == want
+	}
+	return v != want
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseGoal(t *testing.T) {
+	tests := []struct {
+		expr string
+		want []goalClause
+	}{
+		{"p99<250ms", []goalClause{{metric: "p99", op: "<", value: 250}}},
+		{"p99.9 <= 1s && errors==0", []goalClause{{metric: "p99.9", op: "<=", value: 1000}, {metric: "errors", op: "==", value: 0}}},
+		{"availability>=99.5%", []goalClause{{metric: "availability", op: ">=", value: 99.5}}},
+		{"mean!=0s&&timeouts<3", []goalClause{{metric: "mean", op: "!=", value: 0}, {metric: "timeouts", op: "<", value: 3}}},
+	}
+	for _, tt := range tests {
+		g, err := parseGoal(tt.expr)
+		if err != nil {
+			t.Errorf("parseGoal(%q): %v", tt.expr, err)
+			continue
+		}
+		if len(g.clauses) != len(tt.want) {
+			t.Errorf("parseGoal(%q) has %d clauses, want %d", tt.expr, len(g.clauses), len(tt.want))
+			continue
+		}
+		for i, c := range g.clauses {
+			w := tt.want[i]
+			if c.metric != w.metric || c.op != w.op || c.value != w.value {
+				t.Errorf("parseGoal(%q) clause %d = %s %s %v, want %s %s %v", tt.expr, i, c.metric, c.op, c.value, w.metric, w.op, w.value)
+			}
+		}
+	}
+}
+
+func TestParseGoalErrors(t *testing.T) {
+	for _, expr := range []string{"", "p99", "<250ms", "p99<fast", "p0<1s", "p101<1s", "errors<none", "latency<1s", "p99<1s && "} {
+		if _, err := parseGoal(expr); err == nil {
+			t.Errorf("parseGoal(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var sorted []time.Duration
+	for i := 1; i <= 10; i++ {
+		sorted = append(sorted, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{1, time.Millisecond},
+		{50, 5 * time.Millisecond},
+		{90, 9 * time.Millisecond},
+		{91, 10 * time.Millisecond},
+		{100, 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(sorted, tt.p); got != tt.want {
+			t.Errorf("p%v = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 99); got != 0 {
+		t.Errorf("p99 of no latencies = %v, want 0", got)
+	}
+}
+
+func TestGoalValue(t *testing.T) {
+	s := Summary{
+		Requests:          10,
+		Responses:         8,
+		Errors:            map[string]int{errTimeout: 1, errOther: 1},
+		AssertionFailures: 2,
+		Latencies:         []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 60 * time.Millisecond},
+	}
+	tests := []struct {
+		metric string
+		want   float64
+	}{
+		{"requests", 10},
+		{"responses", 8},
+		{"errors", 4},
+		{"timeouts", 1},
+		{"failures", 2},
+		{"error_rate", 40},
+		{"availability", 60},
+		{"mean", 35},
+		{"max", 60},
+		{"p50", 30},
+		{"p99", 60},
+	}
+	for _, tt := range tests {
+		got, ok := goalValue(tt.metric, s)
+		if !ok || got != tt.want {
+			t.Errorf("%s = %v, %v, want %v", tt.metric, got, ok, tt.want)
+		}
+	}
+
+	for _, metric := range []string{"mean", "p99", "error_rate"} {
+		if _, ok := goalValue(metric, Summary{}); ok {
+			t.Errorf("%s of an empty run is available", metric)
+		}
+	}
+}
+
+func TestGoalSink(t *testing.T) {
+	g, err := parseGoal("p99<100ms && errors==0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout, _ = os.Open(os.DevNull)
+	defer func() { os.Stdout = stdout }()
+
+	s := newGoalSink(g)
+	fast, broken := Run{Target: Target{Name: "fast"}}, Run{Target: Target{Name: "broken"}}
+	for i := 0; i < 5; i++ {
+		ok := Record{Responded: true, StatusCode: 200, Total: 10 * time.Millisecond}
+		fast.Records = append(fast.Records, ok)
+		broken.Records = append(broken.Records, ok)
+	}
+	broken.Records = append(broken.Records, Record{Err: errors.New("connection refused")})
+
+	s.Flush(fast.Target, summarize(fast))
+	if !s.passed() {
+		t.Error("fast target failed the goal")
+	}
+	s.Flush(broken.Target, summarize(broken))
+	if s.passed() {
+		t.Error("target with an error passed the goal")
+	}
+}
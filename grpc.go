@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// grpcHealthPath is the method of the standard gRPC health checking
+// protocol, grpc.health.v1.Health/Check.
+const grpcHealthPath = "/grpc.health.v1.Health/Check"
+
+// Serving states of a grpc.health.v1.HealthCheckResponse.
+var grpcServingStatus = [...]string{"UNKNOWN", "SERVING", "NOT_SERVING", "SERVICE_UNKNOWN"}
+
+// grpcProber calls the gRPC health check of the server. The path of the
+// target URL, if any, names the service to check; the empty name asks for
+// the server as a whole. The messages are small enough to encode by hand.
+type grpcProber struct {
+	t      Target
+	logger *log.Logger
+	client *http.Client
+}
+
+func (p *grpcProber) Probe(ctx context.Context) Record {
+	s := startProbe()
+
+	u, err := url.Parse(p.t.URL)
+	if err != nil {
+		return s.fail(err)
+	}
+	service := strings.Trim(u.Path, "/")
+	u.Path, u.RawQuery = grpcHealthPath, ""
+
+	req, err := s.newRequest(ctx, p.logger, p.t, http.MethodPost, u.String(), bytes.NewReader(grpcHealthRequest(service)))
+	if err != nil {
+		return s.fail(err)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		s.rec.GoAway = isGoAway(err)
+		return s.fail(err)
+	}
+	defer res.Body.Close()
+	s.response(res)
+	// A call that did not complete is not a response, although the server
+	// answered at the HTTP level.
+	callFailed := func(err error) Record {
+		s.rec.Responded = false
+		return s.fail(err)
+	}
+	if res.ProtoMajor != 2 {
+		return callFailed(fmt.Errorf("grpc: server answered with %s instead of HTTP/2", res.Proto))
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	s.rec.BytesRead = int64(len(body))
+	if err != nil {
+		return callFailed(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return callFailed(fmt.Errorf("grpc: %s", res.Status))
+	}
+
+	// A call that fails immediately sends its status in the headers only.
+	code, msg := res.Trailer.Get("Grpc-Status"), res.Trailer.Get("Grpc-Message")
+	if code == "" {
+		code, msg = res.Header.Get("Grpc-Status"), res.Header.Get("Grpc-Message")
+	}
+	if code != "0" {
+		return callFailed(fmt.Errorf("grpc: status %s: %s", code, msg))
+	}
+
+	status, err := grpcHealthStatus(body)
+	if err != nil {
+		return callFailed(err)
+	}
+	s.rec.Status = status
+	rec := s.done(p.t)
+	if status != "SERVING" {
+		rec.Failures = append(rec.Failures, "health status "+status)
+	}
+	return rec
+}
+
+// grpcHealthRequest encodes a HealthCheckRequest with its gRPC message
+// prefix: an uncompressed flag and the big-endian message length.
+func grpcHealthRequest(service string) []byte {
+	var msg []byte
+	if service != "" {
+		msg = append(msg, 0x0a) // field 1, length-delimited
+		msg = appendUvarint(msg, uint64(len(service)))
+		msg = append(msg, service...)
+	}
+	frame := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(msg)))
+	return append(frame, msg...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// grpcHealthStatus decodes the serving status of a HealthCheckResponse. A
+// missing status field is the zero value, UNKNOWN.
+func grpcHealthStatus(body []byte) (string, error) {
+	if len(body) < 5 {
+		return "", fmt.Errorf("grpc: short response")
+	}
+	if body[0] != 0 {
+		return "", fmt.Errorf("grpc: compressed responses are not supported")
+	}
+	msg := body[5:]
+	if n := binary.BigEndian.Uint32(body[1:5]); int(n) != len(msg) {
+		return "", fmt.Errorf("grpc: response length %d does not match %d bytes", n, len(msg))
+	}
+
+	status := uint64(0)
+	for len(msg) > 0 {
+		tag, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return "", fmt.Errorf("grpc: invalid response")
+		}
+		msg = msg[n:]
+		if tag&7 != 0 {
+			return "", fmt.Errorf("grpc: unexpected field %d in response", tag>>3)
+		}
+		v, n := binary.Uvarint(msg)
+		if n <= 0 {
+			return "", fmt.Errorf("grpc: invalid response")
+		}
+		msg = msg[n:]
+		if tag>>3 == 1 {
+			status = v
+		}
+	}
+	if status < uint64(len(grpcServingStatus)) {
+		return grpcServingStatus[status], nil
+	}
+	return fmt.Sprintf("status %d", status), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGRPCHealthRequest(t *testing.T) {
+	tests := []struct {
+		service string
+		want    []byte
+	}{
+		{"", []byte{0, 0, 0, 0, 0}},
+		{"api", []byte{0, 0, 0, 0, 5, 0x0a, 3, 'a', 'p', 'i'}},
+		{string(bytes.Repeat([]byte("s"), 200)), append([]byte{0, 0, 0, 0, 203, 0x0a, 0xc8, 0x01}, bytes.Repeat([]byte("s"), 200)...)},
+	}
+	for _, tt := range tests {
+		if got := grpcHealthRequest(tt.service); !bytes.Equal(got, tt.want) {
+			t.Errorf("grpcHealthRequest(%.10q) = %x, want %x", tt.service, got, tt.want)
+		}
+	}
+}
+
+func TestGRPCHealthStatus(t *testing.T) {
+	tests := []struct {
+		body   []byte
+		status string
+		err    string
+	}{
+		{[]byte{0, 0, 0, 0, 2, 0x08, 1}, "SERVING", ""},
+		{[]byte{0, 0, 0, 0, 2, 0x08, 2}, "NOT_SERVING", ""},
+		{[]byte{0, 0, 0, 0, 0}, "UNKNOWN", ""},
+		{[]byte{0, 0, 0, 0, 2, 0x08, 9}, "status 9", ""},
+		// Unknown varint fields are skipped.
+		{[]byte{0, 0, 0, 0, 4, 0x10, 7, 0x08, 1}, "SERVING", ""},
+		{[]byte{0, 0, 0}, "", "grpc: short response"},
+		{[]byte{1, 0, 0, 0, 2, 0x08, 1}, "", "grpc: compressed responses are not supported"},
+		{[]byte{0, 0, 0, 0, 3, 0x08, 1}, "", "grpc: response length 3 does not match 2 bytes"},
+		{[]byte{0, 0, 0, 0, 2, 0x0a, 1}, "", "grpc: unexpected field 1 in response"},
+		{[]byte{0, 0, 0, 0, 1, 0x08}, "", "grpc: invalid response"},
+	}
+	for _, tt := range tests {
+		status, err := grpcHealthStatus(tt.body)
+		if status != tt.status || (err == nil) != (tt.err == "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("grpcHealthStatus(%x) = %q, %v, want %q, %q", tt.body, status, err, tt.status, tt.err)
+		}
+	}
+}
+
+func TestGRPCProbe(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    byte
+		grpcCode  string
+		responded bool
+		failures  int
+	}{
+		{"serving", 1, "0", true, 0},
+		{"not serving", 2, "0", true, 1},
+		{"unimplemented", 0, "12", false, 0},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			if r.URL.Path != grpcHealthPath || !bytes.Equal(body, grpcHealthRequest("api")) {
+				t.Errorf("%s: request %s %x", tt.name, r.URL.Path, body)
+			}
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Trailer", "Grpc-Status")
+			if tt.grpcCode == "0" {
+				w.Write([]byte{0, 0, 0, 0, 2, 0x08, tt.status})
+			}
+			w.Header().Set("Grpc-Status", tt.grpcCode)
+		}))
+		srv.EnableHTTP2 = true
+		srv.StartTLS()
+
+		target := Target{URL: srv.URL + "/api", Mode: modeGRPC, Timeout: Duration(5 * time.Second)}
+		p := &grpcProber{t: target, logger: log.New(ioutil.Discard, "", 0), client: srv.Client()}
+		rec := p.Probe(context.Background())
+		srv.Close()
+
+		if rec.Responded != tt.responded || (rec.Err == nil) != tt.responded || len(rec.Failures) != tt.failures {
+			t.Errorf("%s: responded %v, error %v, failures %q", tt.name, rec.Responded, rec.Err, rec.Failures)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+)
+
+// acceptGzip asks for a gzip encoded response when the transport would,
+// that is unless the request sets Accept-Encoding or asks for a range. The
+// transport's own decompression is disabled in http mode, so that the body
+// can be counted before and after decoding. It reports whether it did.
+func acceptGzip(req *http.Request) bool {
+	if req.Method == http.MethodHead || req.Header.Get("Accept-Encoding") != "" || req.Header.Get("Range") != "" {
+		return false
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	return true
+}
+
+// gunzip decodes a gzip encoded body.
+func gunzip(b []byte) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+	defer zr.Close()
+	decoded, err := ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("gzip: %w", err)
+	}
+	return decoded, nil
+}
+
+// sizeNote annotates a log line with the size on the wire of a body that
+// was decoded.
+func sizeNote(rec Record) string {
+	if rec.WireBytes == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%s gzip)", formatBytes(rec.WireBytes))
+}
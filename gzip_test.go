@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAcceptGzip(t *testing.T) {
+	tests := []struct {
+		method string
+		header http.Header
+		want   bool
+	}{
+		{http.MethodGet, http.Header{}, true},
+		{http.MethodHead, http.Header{}, false},
+		{http.MethodGet, http.Header{"Accept-Encoding": {"br"}}, false},
+		{http.MethodGet, http.Header{"Range": {"bytes=0-99"}}, false},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, "https://example.com/", nil)
+		req.Header = tt.header
+		encoding := tt.header.Get("Accept-Encoding")
+		if got := acceptGzip(req); got != tt.want {
+			t.Errorf("%s %v: acceptGzip = %v, want %v", tt.method, tt.header, got, tt.want)
+		}
+		if tt.want {
+			encoding = "gzip"
+		}
+		if got := req.Header.Get("Accept-Encoding"); got != encoding {
+			t.Errorf("%s %v: Accept-Encoding %q, want %q", tt.method, tt.header, got, encoding)
+		}
+	}
+}
+
+func TestGunzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("hello, world"))
+	zw.Close()
+	if got, err := gunzip(buf.Bytes()); err != nil || string(got) != "hello, world" {
+		t.Errorf("gunzip = %q, %v", got, err)
+	}
+	if _, err := gunzip(buf.Bytes()[:buf.Len()-4]); err == nil {
+		t.Error("truncated gzip body decoded")
+	}
+	if _, err := gunzip([]byte("plain")); err == nil {
+		t.Error("plain body decoded")
+	}
+}
+
+func TestGzipProbe(t *testing.T) {
+	body := strings.Repeat("hilicurl ", 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(body))
+		zw.Close()
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		headers []string
+		gzipped bool
+	}{
+		{nil, true},
+		{[]string{"Accept-Encoding: identity"}, false},
+	}
+	for _, tt := range tests {
+		target := Target{URL: srv.URL, Mode: modeHTTP, Method: http.MethodGet, Headers: tt.headers,
+			Timeout: Duration(5 * time.Second)}
+		prober, _ := newProber(target, log.New(ioutil.Discard, "", 0))
+		rec := prober.Probe(context.Background())
+		if rec.Err != nil || rec.BytesRead != int64(len(body)) || (rec.WireBytes > 0) != tt.gzipped ||
+			rec.WireBytes >= rec.BytesRead {
+			t.Errorf("%q: error %v, %d bytes decoded from %d", tt.headers, rec.Err, rec.BytesRead, rec.WireBytes)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// HTTP/2 frame types and flags used by h2Pinger (RFC 7540 section 6).
+const (
+	h2FrameSettings = 0x4
+	h2FramePing     = 0x6
+	h2FrameGoAway   = 0x7
+	h2FlagAck       = 0x1
+
+	h2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+)
+
+// h2Pinger measures the round trip time of HTTP/2 PING frames between the
+// probes of a target. The server answers them without running a request,
+// which gives the transport RTT apart from the request latency. The
+// bundled HTTP/2 transport cannot send PINGs on the connections of the
+// probes, so the pinger keeps an idle connection of its own, which carries
+// no requests.
+type h2Pinger struct {
+	t      Target
+	logger *log.Logger
+	dial   dialFunc
+
+	// pingMu is held while pinging, so that probes taking the last RTT
+	// do not wait for the next ping.
+	pingMu sync.Mutex
+	conn   net.Conn
+	br     *bufio.Reader
+	seq    uint64
+
+	mu sync.Mutex
+	// rtt is the result of the last ping, until a probe takes it.
+	rtt time.Duration
+}
+
+func newH2Pinger(t Target, logger *log.Logger) *h2Pinger {
+	// Ping bytes are not counted in the transfer statistics.
+	return &h2Pinger{t: t, logger: logger, dial: newDial(t, &byteCounter{})}
+}
+
+func (t Target) validateH2Ping() error {
+	if t.Mode != modeHTTP && t.Mode != modeGRPC {
+		return fmt.Errorf("-h2-ping needs http or grpc mode")
+	}
+	if u, err := url.Parse(t.URL); err != nil || u.Scheme != "https" {
+		return fmt.Errorf("-h2-ping needs an https:// URL")
+	}
+	if t.Proxy != "" || t.PAC != "" {
+		return fmt.Errorf("-h2-ping does not go through proxies")
+	}
+	return nil
+}
+
+// h2PingNote annotates a log line with the RTT of the last -h2-ping.
+func h2PingNote(rec Record) string {
+	if rec.H2Ping == 0 {
+		return ""
+	}
+	return " h2ping=" + formatDuration(rec.H2Ping)
+}
+
+// pingAfter sends a ping once delay has passed, normally half an interval
+// after a probe. Failures are logged and leave no RTT for the next probe.
+func (p *h2Pinger) pingAfter(ctx context.Context, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+	}
+
+	pCtx, cancel := context.WithTimeout(ctx, time.Duration(p.t.Timeout))
+	defer cancel()
+	p.pingMu.Lock()
+	rtt, err := p.ping(pCtx)
+	p.pingMu.Unlock()
+	if err != nil {
+		if ctx.Err() == nil {
+			p.logger.Printf("ERROR: h2 ping: %v", err)
+		}
+		return
+	}
+	p.mu.Lock()
+	p.rtt = rtt
+	p.mu.Unlock()
+}
+
+// take returns the RTT of the ping since the previous probe, if any.
+func (p *h2Pinger) take() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rtt := p.rtt
+	p.rtt = 0
+	return rtt
+}
+
+// ping sends a PING frame and waits for its acknowledgement. A connection
+// the server closed while idle is replaced once. It must be called with
+// p.pingMu held.
+func (p *h2Pinger) ping(ctx context.Context) (time.Duration, error) {
+	reused := p.conn != nil
+	rtt, err := p.pingOnce(ctx)
+	if err != nil && reused && ctx.Err() == nil {
+		rtt, err = p.pingOnce(ctx)
+	}
+	return rtt, err
+}
+
+func (p *h2Pinger) pingOnce(ctx context.Context) (time.Duration, error) {
+	if p.conn == nil {
+		if err := p.connect(ctx); err != nil {
+			return 0, err
+		}
+	}
+	deadline, _ := ctx.Deadline()
+	p.conn.SetDeadline(deadline)
+
+	p.seq++
+	var payload [8]byte
+	binary.BigEndian.PutUint64(payload[:], p.seq)
+	start := time.Now()
+	if err := p.writeFrame(h2FramePing, 0, payload[:]); err != nil {
+		p.close()
+		return 0, err
+	}
+	for {
+		typ, flags, data, err := p.readFrame()
+		if err != nil {
+			p.close()
+			return 0, err
+		}
+		switch typ {
+		case h2FramePing:
+			if flags&h2FlagAck != 0 {
+				if bytes.Equal(data, payload[:]) {
+					return time.Since(start), nil
+				}
+				continue
+			}
+			err = p.writeFrame(h2FramePing, h2FlagAck, data)
+		case h2FrameSettings:
+			if flags&h2FlagAck == 0 {
+				err = p.writeFrame(h2FrameSettings, h2FlagAck, nil)
+			}
+		case h2FrameGoAway:
+			err = fmt.Errorf("server sent GOAWAY")
+		}
+		if err != nil {
+			p.close()
+			return 0, err
+		}
+	}
+}
+
+// connect opens a TLS connection negotiating HTTP/2 and sends the client
+// preface with empty settings.
+func (p *h2Pinger) connect(ctx context.Context) error {
+	// The URL was checked by validate.
+	u, _ := url.Parse(p.t.URL)
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "443")
+	}
+	raw, err := p.dial(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	cfg := tlsConfig(p.t).Clone()
+	cfg.NextProtos = []string{"h2"}
+	if cfg.ServerName == "" {
+		cfg.ServerName = u.Hostname()
+	}
+	conn := tls.Client(raw, cfg)
+	if err := conn.HandshakeContext(ctx); err != nil {
+		raw.Close()
+		return err
+	}
+	if proto := conn.ConnectionState().NegotiatedProtocol; proto != "h2" {
+		conn.Close()
+		return fmt.Errorf("server does not support HTTP/2")
+	}
+	return p.start(conn)
+}
+
+// start sends the client preface with empty settings on conn.
+func (p *h2Pinger) start(conn net.Conn) error {
+	p.conn, p.br = conn, bufio.NewReader(conn)
+	if _, err := io.WriteString(conn, h2Preface); err != nil {
+		p.close()
+		return err
+	}
+	if err := p.writeFrame(h2FrameSettings, 0, nil); err != nil {
+		p.close()
+		return err
+	}
+	return nil
+}
+
+func (p *h2Pinger) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn, p.br = nil, nil
+	}
+}
+
+// writeFrame writes a frame on stream 0, where PING and SETTINGS belong.
+func (p *h2Pinger) writeFrame(typ, flags byte, payload []byte) error {
+	frame := make([]byte, 9+len(payload))
+	frame[0], frame[1], frame[2] = byte(len(payload)>>16), byte(len(payload)>>8), byte(len(payload))
+	frame[3], frame[4] = typ, flags
+	copy(frame[9:], payload)
+	_, err := p.conn.Write(frame)
+	return err
+}
+
+// readFrame reads the next frame of any stream.
+func (p *h2Pinger) readFrame() (typ, flags byte, payload []byte, err error) {
+	var header [9]byte
+	if _, err := io.ReadFull(p.br, header[:]); err != nil {
+		return 0, 0, nil, err
+	}
+	length := int(header[0])<<16 | int(header[1])<<8 | int(header[2])
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(p.br, payload); err != nil {
+		return 0, 0, nil, err
+	}
+	return header[3], header[4], payload, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// pipePingers returns a pinger and the server end of its connection.
+func pipePingers() (client, server *h2Pinger) {
+	c, s := net.Pipe()
+	client = &h2Pinger{t: Target{Timeout: Duration(5 * time.Second)}}
+	server = &h2Pinger{conn: s, br: bufio.NewReader(s)}
+	client.conn, client.br = c, bufio.NewReader(c)
+	return client, server
+}
+
+func TestH2Frames(t *testing.T) {
+	tests := []struct {
+		typ, flags byte
+		payload    []byte
+	}{
+		{h2FramePing, 0, []byte{0, 0, 0, 0, 0, 0, 0, 7}},
+		{h2FramePing, h2FlagAck, []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		{h2FrameSettings, 0, nil},
+		{h2FrameGoAway, 0, make([]byte, 0x10203)},
+	}
+	client, server := pipePingers()
+	for _, tt := range tests {
+		go client.writeFrame(tt.typ, tt.flags, tt.payload)
+		typ, flags, payload, err := server.readFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != tt.typ || flags != tt.flags || !bytes.Equal(payload, tt.payload) {
+			t.Errorf("read frame %d/%d with %d bytes, want %d/%d with %d bytes",
+				typ, flags, len(payload), tt.typ, tt.flags, len(tt.payload))
+		}
+	}
+}
+
+// TestH2PingerPing runs a ping against a server that first sends its
+// settings, a PING of its own and a stale acknowledgement.
+func TestH2PingerPing(t *testing.T) {
+	client, server := pipePingers()
+	errc := make(chan error, 1)
+	go func() {
+		errc <- func() error {
+			preface := make([]byte, len(h2Preface))
+			if _, err := io.ReadFull(server.br, preface); err != nil || string(preface) != h2Preface {
+				return errFrame("preface", err)
+			}
+			if typ, _, _, err := server.readFrame(); err != nil || typ != h2FrameSettings {
+				return errFrame("settings", err)
+			}
+			// The pipe does not buffer, so the client must be done writing
+			// its PING before the server writes.
+			typ, _, ping, err := server.readFrame()
+			if err != nil || typ != h2FramePing {
+				return errFrame("ping", err)
+			}
+			server.writeFrame(h2FrameSettings, 0, nil)
+			if typ, flags, _, err := server.readFrame(); err != nil || typ != h2FrameSettings || flags != h2FlagAck {
+				return errFrame("settings ack", err)
+			}
+			server.writeFrame(h2FramePing, 0, []byte("serverpg"))
+			if typ, flags, data, err := server.readFrame(); err != nil || typ != h2FramePing || flags != h2FlagAck || string(data) != "serverpg" {
+				return errFrame("ping ack", err)
+			}
+			server.writeFrame(h2FramePing, h2FlagAck, []byte("stalepng"))
+			server.writeFrame(h2FramePing, h2FlagAck, ping)
+
+			if typ, _, _, err := server.readFrame(); err != nil || typ != h2FramePing {
+				return errFrame("second ping", err)
+			}
+			return server.writeFrame(h2FrameGoAway, 0, make([]byte, 8))
+		}()
+	}()
+
+	if err := client.start(client.conn); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if rtt, err := client.pingOnce(ctx); err != nil || rtt <= 0 {
+		t.Fatalf("ping: rtt %v, error %v", rtt, err)
+	}
+	if _, err := client.pingOnce(ctx); err == nil {
+		t.Error("ping answered with GOAWAY succeeded, want an error")
+	}
+	if client.conn != nil {
+		t.Error("connection kept after GOAWAY")
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// errFrame reports what the fake server did not receive as expected.
+func errFrame(what string, err error) error {
+	return fmt.Errorf("server: expected %s (%v)", what, err)
+}
+
+func TestValidateH2Ping(t *testing.T) {
+	tests := []struct {
+		t  Target
+		ok bool
+	}{
+		{Target{Mode: modeHTTP, URL: "https://example.com/"}, true},
+		{Target{Mode: modeGRPC, URL: "https://example.com/svc/Method"}, true},
+		{Target{Mode: modeHTTP, URL: "http://example.com/"}, false},
+		{Target{Mode: modeTCP, URL: "example.com:443"}, false},
+		{Target{Mode: modeHTTP, URL: "https://example.com/", Proxy: "http://proxy:3128"}, false},
+		{Target{Mode: modeHTTP, URL: "https://example.com/", PAC: "proxy.pac"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.t.validateH2Ping(); (err == nil) != tt.ok {
+			t.Errorf("%s %s proxy %q pac %q: error %v, want ok %v", tt.t.Mode, tt.t.URL, tt.t.Proxy, tt.t.PAC, err, tt.ok)
+		}
+	}
+}
@@ -2,38 +2,63 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/http/httptrace"
 	"os"
 	"os/signal"
+	"sort"
 	"time"
+
+	// Embedded so that -tz works on hosts without a time zone database.
+	_ "time/tzdata"
 )
 
 const (
 	defaultInterval = 2 * time.Second
 	defaultTimeout  = 60 * time.Second
+
+	// maxDNSBackoffShift caps the DNS backoff at 16 times -dns-backoff.
+	maxDNSBackoffShift = 4
 )
 
-func main() {
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Println("Error:", r)
-			flag.Usage()
-			os.Exit(1)
-		}
-	}()
+// usageExitCode is the exit status for invalid arguments. Nagios mode
+// reports them as UNKNOWN.
+var usageExitCode = 1
+
+// exitInvalid reports an invalid argument, or a setup step failing because
+// of one, and exits. The usage is left out, as it does not help with a
+// value that parsed.
+func exitInvalid(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(usageExitCode)
+}
 
+func main() {
 	ctx := context.Background()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	setupCloseHandler(ctx, cancel)
+	setupPauseHandler(ctx)
+
+	// "hilicurl selftest [flags] [PATH...]" probes a built-in server, and
+	// "hilicurl burst [flags] URL..." sends a fixed number of probes at once.
+	selftest := len(os.Args) > 1 && os.Args[1] == "selftest"
+	burst := len(os.Args) > 1 && os.Args[1] == "burst"
+	if selftest || burst {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
 
 	flag.Usage = func() {
-		fmt.Printf("Usage: %s URL\n", os.Args[0])
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s URL...\n", os.Args[0])
+		fmt.Fprintf(out, "       %s -config FILE\n", os.Args[0])
+		fmt.Fprintf(out, "       command | %s -\n", os.Args[0])
+		fmt.Fprintf(out, "       %s selftest [PATH...]\n", os.Args[0])
+		fmt.Fprintf(out, "       %s burst -n COUNT -parallel N URL...\n", os.Args[0])
 		flag.PrintDefaults()
 	}
 
@@ -42,24 +67,411 @@ func main() {
 	flag.BoolVar(&help, "h", false, "Shorthand for -help")
 
 	interval := flag.Duration("interval", defaultInterval, "Interval between each request")
+	strategy := flag.String("strategy", strategyAll,
+		"Probe every target on its own schedule (all), or one target per interval chosen by random, weighted or roundrobin `strategy`")
+	mode := flag.String("mode", modeHTTP, "Probe with `protocol`: http, tcp, tls, dns, grpc, websocket, hls, ftp, smtp or imap")
 	timeout := flag.Duration("timeout", defaultTimeout, "Request timeout")
-	flag.Parse()
+	nearTimeout := flag.Float64("near-timeout", 80, "Flag responses taking at least this `percent` of the timeout, 0 to disable")
+	tz := flag.String("tz", "", "Show and export timestamps in time `zone`, e.g. Europe/Berlin or UTC (default: local time)")
+	units := flag.String("units", unitsAuto, "Display durations in `unit` auto, ms or s")
+	selfMetrics := flag.Bool("self-metrics", false, "Add the memory, CPU, GC pauses and goroutines of hilicurl itself to the statistics")
+	flag.DurationVar(&trendWindow, "trend-window", 10*time.Minute,
+		"Print the p50 and p99 latency of every `window` of runs lasting at least two, 0 to disable")
+	flag.Float64Var(&costPerGB, "cost-per-gb", 0, "Estimate the cost of the transferred bytes at `price` per GB")
+	configPath := flag.String("config", "", "Read targets from a JSON config `file`")
+	statusFile := flag.String("status-file", "", "Keep a JSON snapshot of target health in `file`, rewritten after every probe")
+	resultWebhookURL := flag.String("result-webhook", "", "POST every probe result as JSON to `url`")
+	resultWebhookBatch := flag.Int("result-webhook-batch", 1, "Send -result-webhook results in JSON arrays of up to `n` probes")
+	reportURL := flag.String("report-url", "", "POST the health of every target to `url` each -report-interval, to aggregate a fleet of monitors")
+	reportInterval := flag.Duration("report-interval", time.Minute, "Time between -report-url reports")
+	instance := flag.String("instance", "", "Instance `name` in -report-url reports (default: the host name)")
+	var instanceLabels stringList
+	flag.Var(&instanceLabels, "instance-label", "Add a `key=value` label to -report-url reports (repeatable)")
+	progressFD := flag.Int("progress-fd", -1, "Write JSON progress events to file descriptor `fd`")
+	var sinkSpecs stringList
+	flag.Var(&sinkSpecs, "sink", "Send results to `sink`: console, csv=FILE, json=FILE, prometheus=ADDR, statsd=HOST:PORT or binlog=FILE "+
+		"(repeatable, default console)")
+	flag.DurationVar(&binlogKeep, "binlog-keep", 24*time.Hour,
+		"Keep single probes in the binlog sink for `duration`, then compact them into per-minute aggregates (0 keeps them all)")
+	flag.StringVar(&rollover, "rollover", "", "Start new csv and json sink files every `period`: daily, named after the day")
+	flag.BoolVar(&rolloverGzip, "rollover-gzip", false, "Compress the sink files closed by -rollover with gzip")
+	dumpBinlogPath := flag.String("dump-binlog", "", "Print the entries of a binlog sink `file` as JSON lines and exit")
+	var headers stringList
+	flag.Var(&headers, "H", "Add a request `header` like \"Name: value\" (repeatable)")
+	noEnv := flag.Bool("no-env", false, "Do not expand ${VAR} in URLs, headers and the config file")
+	hostHeader := flag.String("host-header", "", "Send `host` in the Host header instead of the URL host")
+	dnsBackoff := flag.Duration("dns-backoff", 0, "Pause probing for this long after an NXDOMAIN or SERVFAIL answer, doubling on repeated failures")
+	autoBackoff := flag.Duration("auto-backoff", 0,
+		"Double the interval after every failed probe, up to `max`, and restore it once the target recovers")
+	dnsTimeout := flag.Duration("dns-timeout", 0, "Timeout of a single DNS lookup attempt (default: system resolver)")
+	dnsAttempts := flag.Int("dns-attempts", 0, "Number of DNS lookup attempts (default: system resolver)")
+	var expectJSON stringList
+	flag.Var(&expectJSON, "expect-json", "Assert the JSON body matches `expr`, e.g. status=ok or replicas>=3 (repeatable)")
+	var expectXPath, expectCSS stringList
+	flag.Var(&expectXPath, "expect-xpath", "Assert the XML or HTML body has a node matching XPath `expr` (repeatable)")
+	flag.Var(&expectCSS, "expect-css", "Assert the HTML body has an element matching CSS `selector` (repeatable)")
+	expectSize := flag.String("expect-size", "", "Assert the body size in bytes is within `range`, e.g. 1024-2048, 100- or -4096")
+	verifyNoCache := flag.Bool("verify-no-cache", false,
+		"Assert responses forbid caching and were not served by a cache, and that the ETag changes between probes")
+	expectSizeChange := flag.Float64("expect-size-change", 0, "Flag responses whose size differs from the previous one by more than `percent`")
+	verifyName := flag.String("verify-name", verifyNameAuto,
+		"Check the TLS certificate against the Host header (host), the URL (url) or neither (none); "+
+			"auto uses the Host header only for IP literal URLs")
+	proxy := flag.String("proxy", "", "Send HTTP requests through the proxy at `url`, an http://, https:// or socks5:// URL "+
+		"(default: $HTTPS_PROXY, $HTTP_PROXY and $NO_PROXY)")
+	proxyUser := flag.String("proxy-user", "", "Authenticate to the proxy as `user:password`")
+	pac := flag.String("pac", "", "Choose the proxy of every request with the proxy auto-config file at `url` or path")
+	plain := flag.Bool("plain", false, "Default URLs without a scheme to http:// (ws:// in websocket mode) instead of https://")
+	h2Ping := flag.Bool("h2-ping", false, "Send an HTTP/2 PING halfway between probes and log its round trip time with the next probe")
+	raw := flag.Bool("raw", false, "Do not ask for and decode gzip encoded responses, so body sizes are those on the wire")
+	requestFile := flag.String("request-file", "", "Replay the raw HTTP request in `file`, keeping its method, path, headers and body")
+	hmacKey := flag.String("hmac", "", "Sign every request with an HMAC using `key`")
+	hmacHeader := flag.String("hmac-header", "X-Signature", "Send the -hmac signature in `header`")
+	hmacFields := flag.String("hmac-fields", "method,path,date",
+		"Sign the request `fields` method, path, host, date, body and header:Name, joined by newlines")
+	hmacHash := flag.String("hmac-hash", "sha256", "HMAC `hash` function: sha1, sha256 or sha512")
+	hmacEncoding := flag.String("hmac-encoding", hmacHex, "Encode the -hmac signature in hex or base64 `encoding`")
+	startTLS := flag.Bool("starttls", false, "Upgrade smtp and imap probes to TLS with STARTTLS after the banner")
+	zabbix := flag.String("zabbix", "", "Send each probe to the Zabbix server or proxy at `host:port`")
+	zabbixHost := flag.String("zabbix-host", "", "Zabbix `host` name the items belong to")
+	zabbixKey := flag.String("zabbix-key", "", "Trapper item `key` receiving the response time in seconds")
+	zabbixStatusKey := flag.String("zabbix-status-key", "", "Trapper item `key` receiving 1 for a successful probe and 0 otherwise")
+	alertAfter := flag.Int("alert-after", 3, "Consider a target down after `n` consecutive failed probes, or n failures within -alert-window")
+	alertWindow := flag.Duration("alert-window", 0, "Decide alerts on the error rate over this sliding `window` instead of consecutive failures")
+	alertErrorRate := flag.Float64("alert-error-rate", 20, "Error `percent` within -alert-window above which a target is down")
+	slo := flag.Float64("slo", 0, "Alert on the error budget burn rate of an availability objective of `percent` good probes, e.g. 99.9")
+	sloLatency := flag.Duration("slo-latency", 0, "Count probes slower than `duration` as bad for -slo")
+	sloWindows := flag.String("slo-windows", "5m,1h", "Short and long burn rate `windows` for -slo")
+	burnRate := flag.Float64("burn-rate", 14.4, "Consider a target down when the error budget burns this many `times` too fast in both -slo-windows")
+	smtpServer := flag.String("smtp", "", "Mail down and up alerts through the SMTP server at `host:port`")
+	smtpUser := flag.String("smtp-user", "", "SMTP `user` name, if the server needs authentication")
+	smtpPassword := flag.String("smtp-password", "", "SMTP `password` (default $HILICURL_SMTP_PASSWORD)")
+	mailFrom := flag.String("mail-from", "", "Sender `address` of alert mails")
+	var mailTo stringList
+	flag.Var(&mailTo, "mail-to", "Recipient `address` of alert mails (repeatable)")
+	mailSubject := flag.String("mail-subject", defaultMailSubject, "Alert mail subject `template`")
+	mailBody := flag.String("mail-body", "", "Alert mail body `template` (default: a summary of the event)")
+	mailInterval := flag.Duration("mail-min-interval", 5*time.Minute, "Send at most one alert mail per `interval`")
+	pagerDutyKey := flag.String("pagerduty-key", "", "Open and resolve PagerDuty incidents with this Events API v2 routing `key`")
+	pagerDutyURL := flag.String("pagerduty-url", defaultPagerDutyURL, "PagerDuty Events API `url`")
+	opsgenieKey := flag.String("opsgenie-key", "", "Open and close Opsgenie alerts with this API `key`")
+	opsgenieURL := flag.String("opsgenie-url", defaultOpsgenieURL, "Opsgenie API base `url`")
+	goalExpr := flag.String("goal", "", "Print PASS or FAIL for `expr` on the final statistics of each target, "+
+		"e.g. 'p99<250ms && errors==0', and exit with status 1 on FAIL")
+	burstCount := flag.Int("n", 100, "Number of probes the burst command sends to each target")
+	burstParallel := flag.Int("parallel", 10, "Number of probes the burst command keeps in flight")
+	nagios := flag.Bool("nagios", false, "Probe once and report like a Nagios plugin, with its exit codes")
+	nagiosWarn := flag.Duration("nagios-warning", 0, "Response time that is a WARNING in -nagios mode")
+	nagiosCrit := flag.Duration("nagios-critical", 0, "Response time that is CRITICAL in -nagios mode")
+	if burst {
+		// Flags may follow the URL, as in "burst URL -n 100".
+		os.Args = append(os.Args[:1], flagsFirst(flag.CommandLine, os.Args[1:])...)
+	}
+	// Parse errors exit with usageExitCode, which the flag package cannot
+	// know before -nagios is parsed.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		if nagiosRequested(os.Args[1:]) {
+			usageExitCode = nagiosUnknown
+		}
+		os.Exit(usageExitCode)
+	}
+
+	if *nagios {
+		usageExitCode = nagiosUnknown
+	}
 
 	if help {
 		flag.Usage()
 		return
 	}
 
-	if flag.NArg() != 1 {
-		log.Panic("url argument is required")
+	if *dumpBinlogPath != "" {
+		if err := dumpBinlog(*dumpBinlogPath, os.Stdout); err != nil {
+			exitInvalid(err)
+		}
+		return
+	}
+
+	if *tz != "" {
+		loc, err := time.LoadLocation(*tz)
+		if err != nil {
+			exitInvalid(err)
+		}
+		// Log lines, exports and alerts all format times in local time.
+		time.Local = loc
+	}
+
+	if !validUnits(*units) {
+		exitInvalid(fmt.Errorf("invalid units %q", *units))
+	}
+	displayUnits = *units
+	if rollover != "" && rollover != rolloverDaily {
+		exitInvalid(fmt.Errorf("invalid rollover period %q, expected daily", rollover))
+	}
+
+	defaults := Target{
+		Mode:     *mode,
+		Plain:    *plain,
+		Method:   http.MethodGet,
+		Interval: Duration(*interval),
+		Timeout:  Duration(*timeout),
+
+		NearTimeout: *nearTimeout,
+
+		Headers:    headers,
+		HostHeader: *hostHeader,
+		VerifyName: *verifyName,
+		StartTLS:   *startTLS,
+
+		Proxy:     *proxy,
+		ProxyUser: *proxyUser,
+		PAC:       *pac,
+
+		RequestFile: *requestFile,
+		H2Ping:      *h2Ping,
+		Raw:         *raw,
+
+		HMACKey:      *hmacKey,
+		HMACHeader:   *hmacHeader,
+		HMACFields:   *hmacFields,
+		HMACHash:     *hmacHash,
+		HMACEncoding: *hmacEncoding,
+
+		DNSBackoff:  Duration(*dnsBackoff),
+		AutoBackoff: Duration(*autoBackoff),
+
+		DNSTimeout:  Duration(*dnsTimeout),
+		DNSAttempts: *dnsAttempts,
+
+		ExpectJSON: expectJSON,
+
+		ExpectXPath: expectXPath,
+		ExpectCSS:   expectCSS,
+
+		ExpectSize:       *expectSize,
+		ExpectSizeChange: *expectSizeChange,
+		VerifyNoCache:    *verifyNoCache,
+	}
+
+	var targets []Target
+	if *configPath != "" {
+		cfg, err := loadConfig(*configPath, !*noEnv)
+		if err != nil {
+			exitInvalid(err)
+		}
+		targets = cfg.Targets
+	}
+	nConfig := len(targets)
+	args := flag.Args()
+	if burst {
+		if *burstCount < 1 || *burstParallel < 1 {
+			exitInvalid(errors.New("-n and -parallel must be at least 1"))
+		}
+	}
+	if selftest {
+		base, err := startSelftestServer()
+		if err != nil {
+			exitInvalid(err)
+		}
+		if args, err = selftestURLs(base, args); err != nil {
+			exitInvalid(err)
+		}
+	}
+	for _, arg := range args {
+		if arg != "-" {
+			targets = append(targets, Target{URL: arg})
+			continue
+		}
+		urls, err := readURLs(os.Stdin)
+		if err != nil {
+			exitInvalid(err)
+		}
+		for _, u := range urls {
+			targets = append(targets, Target{URL: u})
+		}
+	}
+
+	if len(targets) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: url argument is required")
+		flag.Usage()
+		os.Exit(usageExitCode)
+	}
+
+	if !*noEnv {
+		// Config file targets were expanded when the file was read.
+		for i := nConfig; i < len(targets); i++ {
+			var err error
+			if targets[i].URL, err = expandEnv(targets[i].URL); err != nil {
+				exitInvalid(err)
+			}
+		}
+		for i, h := range defaults.Headers {
+			var err error
+			if defaults.Headers[i], err = expandEnv(h); err != nil {
+				exitInvalid(err)
+			}
+		}
+		var err error
+		if defaults.ProxyUser, err = expandEnv(defaults.ProxyUser); err != nil {
+			exitInvalid(err)
+		}
+		if defaults.HMACKey, err = expandEnv(defaults.HMACKey); err != nil {
+			exitInvalid(err)
+		}
+	}
+
+	for i := range targets {
+		targets[i] = targets[i].withDefaults(defaults)
+		if len(targets) > 1 && targets[i].Name == "" {
+			targets[i].Name = targets[i].URL
+		}
+		if err := targets[i].validate(); err != nil {
+			exitInvalid(err)
+		}
+	}
+
+	if err := validateStrategy(*strategy, targets); err != nil {
+		exitInvalid(err)
+	}
+
+	if *nagios {
+		if len(targets) != 1 {
+			exitInvalid(errors.New("-nagios checks exactly one target"))
+		}
+		if targets[0].Mode != modeHTTP {
+			exitInvalid(errors.New("-nagios supports only http mode"))
+		}
+		os.Exit(runNagios(ctx, targets[0], *nagiosWarn, *nagiosCrit))
+	}
+
+	if len(sinkSpecs) == 0 && !burst {
+		// A burst prints its own report rather than a line per probe.
+		sinkSpecs = stringList{sinkConsole}
+	}
+	var sinks sinkList
+	for _, spec := range sinkSpecs {
+		s, err := newSink(spec)
+		if err != nil {
+			exitInvalid(err)
+		}
+		sinks = append(sinks, s)
+	}
+	if *progressFD >= 0 {
+		f, err := openProgressFD(*progressFD)
+		if err != nil {
+			exitInvalid(err)
+		}
+		sinks = append(sinks, newProgressWriter(f))
+	}
+	if *statusFile != "" {
+		sinks = append(sinks, newStatusWriter(*statusFile))
+	}
+	if *resultWebhookURL != "" {
+		sinks = append(sinks, &resultWebhook{url: *resultWebhookURL, batch: *resultWebhookBatch})
+	}
+	if *reportURL != "" {
+		labels, err := parseLabels(instanceLabels)
+		if err != nil {
+			exitInvalid(err)
+		}
+		if *instance == "" {
+			*instance, _ = os.Hostname()
+		}
+		if *reportInterval <= 0 {
+			exitInvalid(errors.New("-report-interval must be positive"))
+		}
+		sinks = append(sinks, newFleetReporter(*reportURL, *instance, labels, *reportInterval))
+	}
+	if *zabbix != "" {
+		if *zabbixHost == "" || *zabbixKey == "" {
+			exitInvalid(errors.New("-zabbix requires -zabbix-host and -zabbix-key"))
+		}
+		sinks = append(sinks, &zabbixSender{
+			addr:      *zabbix,
+			host:      *zabbixHost,
+			key:       *zabbixKey,
+			statusKey: *zabbixStatusKey,
+			perTarget: len(targets) > 1,
+		})
+	}
+
+	var notifiers []notifier
+	if *smtpServer != "" {
+		if *smtpPassword == "" {
+			*smtpPassword = os.Getenv("HILICURL_SMTP_PASSWORD")
+		}
+		if *mailBody == "" {
+			*mailBody = defaultMailBody
+		}
+		n, err := newEmailNotifier(*smtpServer, *smtpUser, *smtpPassword, *mailFrom, mailTo,
+			*mailSubject, *mailBody, *mailInterval)
+		if err != nil {
+			exitInvalid(err)
+		}
+		notifiers = append(notifiers, n)
+	}
+	if *pagerDutyKey != "" {
+		notifiers = append(notifiers, &pagerDutyNotifier{url: *pagerDutyURL, routingKey: *pagerDutyKey})
+	}
+	if *opsgenieKey != "" {
+		notifiers = append(notifiers, &opsgenieNotifier{url: *opsgenieURL, apiKey: *opsgenieKey})
+	}
+	if len(notifiers) > 0 {
+		policy := alertPolicy{
+			after:      *alertAfter,
+			window:     *alertWindow,
+			errorRate:  *alertErrorRate,
+			slo:        *slo,
+			sloLatency: *sloLatency,
+			burnRate:   *burnRate,
+		}
+		if *slo != 0 {
+			var err error
+			if policy.shortWindow, policy.longWindow, err = parseBurnWindows(*sloWindows); err != nil {
+				exitInvalid(err)
+			}
+			if *slo <= 0 || *slo >= 100 {
+				exitInvalid(fmt.Errorf("-slo must be between 0 and 100, got %v", *slo))
+			}
+		}
+		sinks = append(sinks, newAlerter(policy, notifiers))
+	}
+
+	var goal *goalSink
+	if *goalExpr != "" {
+		g, err := parseGoal(*goalExpr)
+		if err != nil {
+			exitInvalid(err)
+		}
+		goal = newGoalSink(g)
+		sinks = append(sinks, goal)
 	}
 
-	url := flag.Arg(0)
-	runRequests(ctx, url, interval, timeout)
+	var monitor *selfMonitor
+	if *selfMetrics {
+		monitor = startSelfMonitor(ctx)
+	}
+	if burst {
+		for _, t := range targets {
+			runBurst(ctx, t, *burstCount, *burstParallel, sinks)
+		}
+	} else {
+		runTargets(ctx, targets, *strategy, *interval, sinks)
+	}
+	// Both return once the probes in flight are done, so no sink is written
+	// to after it is closed.
+	sinks.close()
+	if monitor != nil {
+		monitor.print()
+	}
+	if goal != nil && !goal.passed() {
+		os.Exit(goalFailedExitCode)
+	}
 }
 
 func setupCloseHandler(ctx context.Context, cancel func()) {
-	c := make(chan os.Signal)
+	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
 	go func() {
 		select {
@@ -72,83 +484,451 @@ func setupCloseHandler(ctx context.Context, cancel func()) {
 	}()
 }
 
-func runRequests(ctx context.Context, url string, interval *time.Duration, timeout *time.Duration) {
-	log.Printf("GET %s\n", url)
-	records := make([]Record, 0, 10)
-	for {
-		select {
-		case <-ctx.Done():
-			fmt.Printf("--- GET %s statistics ---\n", url)
-			printStatistics(records)
-			return
-		default:
-			go func() {
-				tCtx, cancel := context.WithTimeout(ctx, *timeout)
-				defer cancel()
-				res := request(tCtx, url)
+// Run is the outcome of probing one target until cancellation.
+type Run struct {
+	Target  Target
+	Start   time.Time
+	End     time.Time
+	Records []Record
 
-				records = append(records, res)
-			}()
-			time.Sleep(*interval)
-		}
-	}
-}
+	// Pauses requested with SIGUSR1 while the target was probed.
+	Pauses []pauseWindow
 
-func request(ctx context.Context, url string) Record {
-	var t3 time.Time
-	rec := Record{}
+	// Wire bytes over all connections, including headers and TLS.
+	BytesSent     int64
+	BytesReceived int64
+}
 
-	trace := &httptrace.ClientTrace{
-		GotConn: func(_ httptrace.GotConnInfo) { t3 = time.Now() },
+// targetLogger prefixes log lines with the target name when several targets
+// are probed.
+func targetLogger(t Target) *log.Logger {
+	if t.Name == "" {
+		return log.Default()
 	}
+	return log.New(log.Writer(), "["+t.Name+"] ", log.Flags()|log.Lmsgprefix)
+}
 
-	ctx = httptrace.WithClientTrace(ctx, trace)
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	rec.Request = req
+func request(ctx context.Context, logger *log.Logger, client *http.Client, t Target) Record {
+	if t.RequestFile != "" {
+		// Read the file before the clock starts, so that it does not count
+		// towards the response time.
+		raw, err := readRequestFile(t.RequestFile)
+		s := startProbe()
+		if err != nil {
+			return s.fail(err)
+		}
+		return replay(ctx, s, logger, client, t, raw)
+	}
+	s := startProbe()
+	req, err := s.newRequest(ctx, logger, t, t.Method, t.URL, nil)
+	if err != nil {
+		return s.fail(err)
+	}
+	return send(s, client, req, t)
+}
 
-	rec.Timestamp = time.Now()
-	res, err := http.DefaultClient.Do(req)
-	rec.Response = res
+// send completes a probe with the response to req.
+func send(s *probeState, client *http.Client, req *http.Request, t Target) Record {
+	if t.HMACKey != "" {
+		if err := signRequest(req, t); err != nil {
+			return s.fail(err)
+		}
+	}
+	gzipped := !t.Raw && acceptGzip(req)
+	res, err := client.Do(req)
 	if err != nil {
-		log.Printf("ERROR: %v", err)
-		return rec
+		s.rec.GoAway = isGoAway(err)
+		return s.fail(err)
 	}
+	defer res.Body.Close()
+	s.response(res)
 
 	bytes, err := ioutil.ReadAll(res.Body)
+	s.rec.BytesRead = int64(len(bytes))
 	if err != nil {
-		log.Printf("ERROR: %v", err)
-		return rec
+		return s.fail(err)
 	}
 
-	t7 := time.Now()
-	elapsed := t7.Sub(t3)
+	// Decoding is not part of the response time, which stays comparable
+	// with that of -raw probes.
+	rec := s.done(t)
+	if gzipped && res.Header.Get("Content-Encoding") == "gzip" {
+		rec.WireBytes = rec.BytesRead
+		if bytes, err = gunzip(bytes); err != nil {
+			rec.Err = err
+			return rec
+		}
+		rec.BytesRead = int64(len(bytes))
+	}
+	rec.Failures = checkExpectations(t, res.Header.Get("Content-Type"), bytes)
+	if t.VerifyNoCache {
+		rec.Failures = append(rec.Failures, checkNoCache(res.Header)...)
+		rec.ETag = res.Header.Get("ETag")
+	}
+	return rec
+}
 
-	log.Printf("%s: length=%d bytes time=%d ms\n", res.Status, len(bytes), elapsed.Milliseconds())
+// Summary holds the statistics of a finished run against one target.
+type Summary struct {
+	Requests    int
+	Responses   int
+	TimeoutRate float64
 
-	rec.Timestamp = t3
-	rec.ElapsedTime = elapsed
+	NewConns  int
+	ConnClose int
+	GoAway    int
 
-	return rec
+	// TLS handshakes, and the time spent on TCP connects and handshakes
+	// over the run.
+	TLSHandshakes int
+	ConnectTime   time.Duration
+	TLSTime       time.Duration
+
+	Errors map[string]int
+
+	// Response times of the probes that succeeded, sorted.
+	Latencies []time.Duration
+
+	// DNS lookup time over the probes that resolved the host.
+	DNSLookups int
+	MeanDNS    time.Duration
+	MaxDNS     time.Duration
+
+	// CONNECT tunnels opened through a proxy, and the time they took.
+	Tunnels    int
+	MeanTunnel time.Duration
+	MaxTunnel  time.Duration
+
+	// Round trip times of the -h2-ping PINGs.
+	H2Pings    int
+	MinH2Ping  time.Duration
+	MeanH2Ping time.Duration
+	MaxH2Ping  time.Duration
+
+	// Timeouts by the phase they happened in.
+	TimeoutPhases map[string]int
+
+	// Responses that failed at least one assertion.
+	AssertionFailures int
+
+	// HLS playlist and segment fetch times, and the segment download rate
+	// in bytes per second.
+	Segments     int
+	MeanManifest time.Duration
+	MeanSegment  time.Duration
+	SegmentRate  float64
+
+	// Responses that took longer than the -near-timeout share of the
+	// timeout.
+	NearTimeout int
+
+	BytesSent     int64
+	BytesReceived int64
+
+	// Bodies received gzip encoded, and their size before and after
+	// decoding.
+	Gzipped       int
+	GzipWireBytes int64
+	GzipBodyBytes int64
+
+	// Relation of body size and response time, when sizes vary.
+	SizeCorrelation float64
+	SizeBuckets     []SizeBucket
+
+	// Latency percentiles per -trend-window, for long runs.
+	Trend []TrendWindow
+
+	// Probes per second, as configured and as actually launched.
+	RequestedRate float64
+	AchievedRate  float64
+
+	// Time between consecutive probe starts.
+	MeanGap time.Duration
+	MaxGap  time.Duration
+
+	// Number and total time of pauses, which the rates and gaps leave out.
+	Pauses int
+	Paused time.Duration
 }
 
-func printStatistics(records []Record) {
-	nReq, nRes := len(records), 0
+func summarize(run Run) Summary {
+	s := Summary{
+		Requests:      len(run.Records),
+		Errors:        make(map[string]int),
+		TimeoutPhases: make(map[string]int),
+	}
 
-	for _, rec := range records {
-		if rec.Response != nil {
-			nRes++
+	var segmentBytes int64
+	for _, rec := range run.Records {
+		if rec.Responded {
+			s.Responses++
+		}
+		if rec.Responded && !rec.ConnReused && run.Target.Mode != modeDNS {
+			s.NewConns++
+		}
+		if rec.TLS > 0 {
+			s.TLSHandshakes++
+		}
+		s.ConnectTime += rec.Connect
+		s.TLSTime += rec.TLS
+		if rec.ConnClose {
+			s.ConnClose++
+		}
+		if rec.GoAway {
+			s.GoAway++
+		}
+		if rec.Err != nil {
+			s.Errors[classifyError(rec.Err)]++
+		}
+		if rec.TimeoutPhase != "" {
+			s.TimeoutPhases[rec.TimeoutPhase]++
+		}
+		if rec.DNS > 0 {
+			s.DNSLookups++
+			s.MeanDNS += rec.DNS
+			if rec.DNS > s.MaxDNS {
+				s.MaxDNS = rec.DNS
+			}
+		}
+		if rec.Tunnel > 0 {
+			s.Tunnels++
+			s.MeanTunnel += rec.Tunnel
+			if rec.Tunnel > s.MaxTunnel {
+				s.MaxTunnel = rec.Tunnel
+			}
+		}
+		if rec.H2Ping > 0 {
+			if s.H2Pings == 0 || rec.H2Ping < s.MinH2Ping {
+				s.MinH2Ping = rec.H2Ping
+			}
+			s.H2Pings++
+			s.MeanH2Ping += rec.H2Ping
+			if rec.H2Ping > s.MaxH2Ping {
+				s.MaxH2Ping = rec.H2Ping
+			}
+		}
+		if len(rec.Failures) > 0 {
+			s.AssertionFailures++
+		}
+		if probeOK(rec) {
+			s.Latencies = append(s.Latencies, rec.Total)
+		}
+		if rec.Segment > 0 && rec.Err == nil {
+			s.Segments++
+			s.MeanManifest += rec.Manifest
+			s.MeanSegment += rec.Segment
+			segmentBytes += rec.SegmentBytes
+		}
+		if rec.NearTimeout {
+			s.NearTimeout++
+		}
+		if rec.WireBytes > 0 {
+			s.Gzipped++
+			s.GzipWireBytes += rec.WireBytes
+			s.GzipBodyBytes += rec.BytesRead
 		}
 	}
 
-	nTimeout := nReq - nRes
-	timeoutRate := float64(nTimeout) / float64(nReq) * 100
-	fmt.Printf("%d requests transmitted, %d responses received, %.2f%% timeout",
-		nReq, nRes, timeoutRate)
+	sort.Slice(s.Latencies, func(i, j int) bool { return s.Latencies[i] < s.Latencies[j] })
+	if s.DNSLookups > 0 {
+		s.MeanDNS /= time.Duration(s.DNSLookups)
+	}
+	if s.Tunnels > 0 {
+		s.MeanTunnel /= time.Duration(s.Tunnels)
+	}
+	if s.H2Pings > 0 {
+		s.MeanH2Ping /= time.Duration(s.H2Pings)
+	}
+	if s.Segments > 0 {
+		s.SegmentRate = throughput(segmentBytes, s.MeanSegment)
+		s.MeanManifest /= time.Duration(s.Segments)
+		s.MeanSegment /= time.Duration(s.Segments)
+	}
+	if s.Requests > 0 {
+		s.TimeoutRate = float64(s.Errors[errTimeout]) / float64(s.Requests) * 100
+	}
+	if run.Target.Interval > 0 {
+		s.RequestedRate = float64(time.Second) / float64(run.Target.Interval)
+	}
+	s.Pauses, s.Paused = len(run.Pauses), pausedBetween(run.Pauses, run.Start, run.End)
+	if elapsed := run.End.Sub(run.Start) - s.Paused; elapsed > 0 {
+		s.AchievedRate = float64(s.Requests) / elapsed.Seconds()
+	}
+	s.MeanGap, s.MaxGap = probeGaps(run.Records, run.Pauses)
+	s.BytesSent, s.BytesReceived = run.BytesSent, run.BytesReceived
+	s.SizeCorrelation, s.SizeBuckets = sizeLatency(run.Records)
+	s.Trend = latencyTrend(run, trendWindow)
+	return s
+}
+
+// costPerGB is the -cost-per-gb price used to estimate what the transferred
+// bytes cost; zero disables the estimate.
+var costPerGB float64
+
+func printStatistics(s Summary) {
+	fmt.Printf("%d requests transmitted, %d responses received, %.2f%% timeout\n",
+		s.Requests, s.Responses, s.TimeoutRate)
+	if len(s.Errors) > 0 {
+		fmt.Printf("errors: %s\n", formatErrorCounts(s.Errors))
+	}
+	if len(s.TimeoutPhases) > 0 {
+		fmt.Printf("timeouts by phase: %s\n", formatErrorCounts(s.TimeoutPhases))
+	}
+	if s.AssertionFailures > 0 {
+		fmt.Printf("%d responses failed assertions\n", s.AssertionFailures)
+	}
+	if s.NearTimeout > 0 {
+		fmt.Printf("%d responses came close to the timeout\n", s.NearTimeout)
+	}
+	if s.DNSLookups > 0 {
+		fmt.Printf("%d dns lookups, mean %v max %v\n", s.DNSLookups,
+			formatDuration(s.MeanDNS), formatDuration(s.MaxDNS))
+	}
+	if s.Tunnels > 0 {
+		fmt.Printf("%d proxy tunnels, CONNECT mean %v max %v\n", s.Tunnels,
+			formatDuration(s.MeanTunnel), formatDuration(s.MaxTunnel))
+	}
+	if s.H2Pings > 0 {
+		fmt.Printf("%d http/2 pings, rtt min %v mean %v max %v\n", s.H2Pings,
+			formatDuration(s.MinH2Ping), formatDuration(s.MeanH2Ping), formatDuration(s.MaxH2Ping))
+	}
+	if s.Segments > 0 {
+		fmt.Printf("%d segments, manifest mean %v, segment mean %v, %s/s\n", s.Segments,
+			formatDuration(s.MeanManifest), formatDuration(s.MeanSegment), formatBytes(int64(s.SegmentRate)))
+	}
+	fmt.Printf("%d connections opened, %d closed by server with Connection: close, %d requests failed by GOAWAY\n",
+		s.NewConns, s.ConnClose, s.GoAway)
+	if s.NewConns > 0 {
+		fmt.Printf("%.1f requests per connection, connecting took %v", float64(s.Requests)/float64(s.NewConns),
+			formatDuration(s.ConnectTime))
+		if s.TLSHandshakes > 0 {
+			fmt.Printf(", %d TLS handshakes took %v", s.TLSHandshakes, formatDuration(s.TLSTime))
+		}
+		fmt.Println()
+	}
+	total := s.BytesSent + s.BytesReceived
+	fmt.Printf("%s transferred (%s sent, %s received)", formatBytes(total),
+		formatBytes(s.BytesSent), formatBytes(s.BytesReceived))
+	if costPerGB > 0 {
+		fmt.Printf(", estimated cost $%.6f", float64(total)/(1<<30)*costPerGB)
+	}
+	fmt.Println()
+	if s.Gzipped > 0 {
+		fmt.Printf("%d responses gzip encoded, %s on the wire decoded to %s\n", s.Gzipped,
+			formatBytes(s.GzipWireBytes), formatBytes(s.GzipBodyBytes))
+	}
+	if len(s.SizeBuckets) > 0 {
+		printSizeLatency(s.SizeCorrelation, s.SizeBuckets)
+	}
+	if len(s.Trend) > 0 {
+		printLatencyTrend(s.Trend)
+	}
+	fmt.Printf("rate %.2f/s achieved, %.2f/s requested, gap mean %v max %v\n",
+		s.AchievedRate, s.RequestedRate, formatDuration(s.MeanGap), formatDuration(s.MaxGap))
+	if s.Pauses > 0 {
+		fmt.Printf("paused for %v (%d pauses), not counted in the rate and gaps\n", formatDuration(s.Paused), s.Pauses)
+	}
 }
 
+// probeGaps returns the mean and maximum time between the starts of
+// consecutive probes, less the time probing was paused.
+func probeGaps(records []Record, pauses []pauseWindow) (mean, longest time.Duration) {
+	if len(records) < 2 {
+		return 0, 0
+	}
+
+	starts := make([]time.Time, len(records))
+	for i, rec := range records {
+		starts[i] = rec.StartTime
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i].Before(starts[j]) })
+
+	for i := 1; i < len(starts); i++ {
+		gap := starts[i].Sub(starts[i-1]) - pausedBetween(pauses, starts[i-1], starts[i])
+		if gap > longest {
+			longest = gap
+		}
+	}
+	first, last := starts[0], starts[len(starts)-1]
+	mean = (last.Sub(first) - pausedBetween(pauses, first, last)) / time.Duration(len(starts)-1)
+	return mean, longest
+}
+
+// Record is the outcome of a single probe. It keeps no reference to the
+// request or response, so records can be held for a whole run and written
+// to any output.
 type Record struct {
-	Timestamp   time.Time
-	Request     *http.Request
-	Response    *http.Response
+	// Attempt is the 1-based sequence number of the probe for its target.
+	Attempt   int
+	StartTime time.Time
+
+	// Time spent resolving the host name, connecting and in the TLS
+	// handshake, all zero when a kept-alive connection was reused.
+	DNS     time.Duration
+	Connect time.Duration
+	TLS     time.Duration
+
+	// Tunnel is the time the CONNECT request to a proxy took, for https
+	// URLs probed through an HTTP proxy. Connect and TLS then include the
+	// connection to the proxy.
+	Tunnel time.Duration
+
+	// H2Ping is the round trip time of the -h2-ping PING sent since the
+	// previous probe.
+	H2Ping time.Duration
+
+	// TTFB and Total are measured from StartTime to the first response byte
+	// and to the end of the body.
+	TTFB  time.Duration
+	Total time.Duration
+
+	// HLS probes: time to fetch the playlists, and time and size of the
+	// first media segment.
+	Manifest     time.Duration
+	Segment      time.Duration
+	SegmentBytes int64
+
+	// ElapsedTime is the time from getting a connection until the body was
+	// read, or the whole probe for protocols without a request.
 	ElapsedTime time.Duration
+
+	// Responded is set when the target answered. StatusCode is the HTTP
+	// status, zero for protocols without one; Status describes the answer.
+	Responded  bool
+	StatusCode int
+	Status     string
+	BytesRead  int64
+	Err        error
+
+	// WireBytes is the size of a gzip encoded body as received, when
+	// BytesRead is its decoded size.
+	WireBytes int64
+
+	// NearTimeout is set when Total came within the -near-timeout share of
+	// the request timeout.
+	NearTimeout bool
+
+	// TimeoutPhase is the request phase that was in progress when the probe
+	// timed out.
+	TimeoutPhase string
+
+	// Failures lists the response assertions that did not hold.
+	Failures []string
+
+	// ETag of the response, kept with -verify-no-cache to compare it with
+	// the next one.
+	ETag string
+
+	// Connection reuse, Connection: close from the server and requests
+	// failed by an HTTP/2 GOAWAY.
+	ConnReused bool
+	ConnClose  bool
+	GoAway     bool
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
 }
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// recordsAt returns records started at the given offsets from t0.
+func recordsAt(t0 time.Time, offsets ...time.Duration) []Record {
+	records := make([]Record, len(offsets))
+	for i, d := range offsets {
+		records[i].StartTime = t0.Add(d)
+	}
+	return records
+}
+
+func TestProbeGaps(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := time.Second
+	tests := []struct {
+		offsets       []time.Duration
+		mean, longest time.Duration
+	}{
+		{nil, 0, 0},
+		{[]time.Duration{0}, 0, 0},
+		{[]time.Duration{0, s, 2 * s, 3 * s}, s, s},
+		{[]time.Duration{0, s, 5 * s}, 2500 * time.Millisecond, 4 * s},
+		// Probes finishing out of order are sorted by start.
+		{[]time.Duration{2 * s, 0, s}, s, s},
+	}
+	for _, tt := range tests {
+		mean, longest := probeGaps(recordsAt(t0, tt.offsets...), nil)
+		if mean != tt.mean || longest != tt.longest {
+			t.Errorf("probeGaps(%v) = %v, %v, want %v, %v", tt.offsets, mean, longest, tt.mean, tt.longest)
+		}
+	}
+}
+
+func TestSummarizeConnections(t *testing.T) {
+	ms := time.Millisecond
+	start := time.Now()
+	run := Run{Target: Target{Mode: modeHTTP}, Start: start, End: start.Add(time.Minute), Records: []Record{
+		{Responded: true, StatusCode: 200, Connect: 2 * ms, TLS: 5 * ms},
+		{Responded: true, StatusCode: 200, ConnReused: true},
+		{Responded: true, StatusCode: 200, ConnReused: true, ConnClose: true},
+		{Responded: true, StatusCode: 200, Connect: 3 * ms, TLS: 6 * ms},
+		{Err: context.DeadlineExceeded, Connect: ms},
+	}}
+	s := summarize(run)
+	if s.NewConns != 2 || s.ConnClose != 1 || s.TLSHandshakes != 2 || s.ConnectTime != 6*ms || s.TLSTime != 11*ms {
+		t.Errorf("%d connections, %d closed, %d handshakes, connect %v, tls %v, want 2, 1, 2, 6ms, 11ms",
+			s.NewConns, s.ConnClose, s.TLSHandshakes, s.ConnectTime, s.TLSTime)
+	}
+}
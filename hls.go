@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// hlsProber fetches an HLS playlist and its first media segment. For a
+// master playlist the first variant playlist is fetched in between.
+type hlsProber struct {
+	t      Target
+	logger *log.Logger
+	client *http.Client
+}
+
+func (p *hlsProber) Probe(ctx context.Context) Record {
+	s := startProbe()
+
+	playlistURL := p.t.URL
+	playlist, err := p.fetchPlaylist(ctx, s, playlistURL)
+	if err != nil {
+		return s.fail(err)
+	}
+
+	variant, segment := parsePlaylist(playlist)
+	if variant != "" {
+		if playlistURL, err = resolveURL(playlistURL, variant); err != nil {
+			return s.fail(err)
+		}
+		if playlist, err = p.fetchPlaylist(ctx, s, playlistURL); err != nil {
+			return s.fail(err)
+		}
+		_, segment = parsePlaylist(playlist)
+	}
+	if segment == "" {
+		return s.fail(fmt.Errorf("hls: %s lists no segments", playlistURL))
+	}
+	segmentURL, err := resolveURL(playlistURL, segment)
+	if err != nil {
+		return s.fail(err)
+	}
+	s.rec.Manifest = time.Since(s.rec.StartTime)
+
+	start := time.Now()
+	s.rec.SegmentBytes, err = p.fetchSegment(ctx, s, segmentURL)
+	s.rec.Segment = time.Since(start)
+	if err != nil {
+		return s.fail(err)
+	}
+
+	rec := s.done(p.t)
+	rec.ElapsedTime = rec.Total
+	return rec
+}
+
+// get sends a GET request for rawURL. Responses other than 2xx are errors,
+// since probing cannot go on without the document.
+func (p *hlsProber) get(ctx context.Context, s *probeState, rawURL string) (*http.Response, error) {
+	req, err := s.newRequest(ctx, p.logger, p.t, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.client.Do(req)
+	if err != nil {
+		s.rec.GoAway = isGoAway(err)
+		return nil, err
+	}
+	s.response(res)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, fmt.Errorf("hls: GET %s: %s", rawURL, res.Status)
+	}
+	return res, nil
+}
+
+func (p *hlsProber) fetchPlaylist(ctx context.Context, s *probeState, rawURL string) ([]byte, error) {
+	res, err := p.get(ctx, s, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	s.rec.BytesRead += int64(len(body))
+	if err == nil && !bytes.HasPrefix(body, []byte("#EXTM3U")) {
+		err = fmt.Errorf("hls: %s is not an HLS playlist", rawURL)
+	}
+	return body, err
+}
+
+// fetchSegment downloads a segment without keeping it and returns its size.
+func (p *hlsProber) fetchSegment(ctx context.Context, s *probeState, rawURL string) (int64, error) {
+	res, err := p.get(ctx, s, rawURL)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	n, err := io.Copy(ioutil.Discard, res.Body)
+	s.rec.BytesRead += n
+	return n, err
+}
+
+// parsePlaylist returns the first variant stream of a master playlist, or
+// the first media segment of a media playlist.
+func parsePlaylist(b []byte) (variant, segment string) {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	streamInf := false
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-STREAM-INF"):
+			streamInf = true
+		case line == "" || strings.HasPrefix(line, "#"):
+		case streamInf:
+			return line, ""
+		default:
+			return "", line
+		}
+	}
+	return "", ""
+}
+
+func resolveURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("hls: invalid uri %q: %w", ref, err)
+	}
+	return b.ResolveReference(r).String(), nil
+}
+
+// hlsNote annotates a log line with the playlist and segment timings.
+func hlsNote(rec Record) string {
+	if rec.Segment == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" manifest=%s segment=%s rate=%s/s", formatDuration(rec.Manifest),
+		formatDuration(rec.Segment), formatBytes(int64(throughput(rec.SegmentBytes, rec.Segment))))
+}
+
+// throughput returns n bytes over d in bytes per second.
+func throughput(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / d.Seconds()
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePlaylist(t *testing.T) {
+	tests := []struct {
+		playlist         string
+		variant, segment string
+	}{
+		{"#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1280000\nlow/index.m3u8\n#EXT-X-STREAM-INF:BANDWIDTH=2560000\nhigh/index.m3u8\n", "low/index.m3u8", ""},
+		{"#EXTM3U\n#EXT-X-TARGETDURATION:10\n\n#EXTINF:9.009,\r\nsegment0.ts\r\n#EXTINF:9.009,\nsegment1.ts\n", "", "segment0.ts"},
+		{"#EXTM3U\n#EXT-X-ENDLIST\n", "", ""},
+	}
+	for _, tt := range tests {
+		variant, segment := parsePlaylist([]byte(tt.playlist))
+		if variant != tt.variant || segment != tt.segment {
+			t.Errorf("parsePlaylist(%q) = %q, %q, want %q, %q", tt.playlist, variant, segment, tt.variant, tt.segment)
+		}
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	tests := []struct {
+		base, ref, want string
+	}{
+		{"https://cdn.example.com/live/master.m3u8", "low/index.m3u8", "https://cdn.example.com/live/low/index.m3u8"},
+		{"https://cdn.example.com/live/low/index.m3u8", "/seg/0.ts", "https://cdn.example.com/seg/0.ts"},
+		{"https://cdn.example.com/live/index.m3u8", "https://edge.example.com/0.ts?token=x", "https://edge.example.com/0.ts?token=x"},
+	}
+	for _, tt := range tests {
+		if got, err := resolveURL(tt.base, tt.ref); err != nil || got != tt.want {
+			t.Errorf("resolveURL(%q, %q) = %q, %v, want %q", tt.base, tt.ref, got, err, tt.want)
+		}
+	}
+}
+
+func TestHLSProbe(t *testing.T) {
+	files := map[string]string{
+		"/master.m3u8":     "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=1280000\nlow/index.m3u8\n",
+		"/low/index.m3u8":  "#EXTM3U\n#EXTINF:9.009,\nsegment0.ts\n",
+		"/low/segment0.ts": strings.Repeat("x", 4096),
+		"/empty.m3u8":      "#EXTM3U\n#EXT-X-ENDLIST\n",
+		"/page.html":       "<html></html>",
+		"/gone.m3u8":       "#EXTM3U\n#EXTINF:9.009,\nmissing.ts\n",
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		path string
+		err  string
+	}{
+		{"/master.m3u8", ""},
+		{"/low/index.m3u8", ""},
+		{"/empty.m3u8", "lists no segments"},
+		{"/page.html", "is not an HLS playlist"},
+		{"/gone.m3u8", "404 Not Found"},
+	}
+	for _, tt := range tests {
+		target := Target{URL: srv.URL + tt.path, Mode: modeHLS, Timeout: Duration(5 * time.Second)}
+		prober, _ := newProber(target, log.New(ioutil.Discard, "", 0))
+		rec := prober.Probe(context.Background())
+		if tt.err != "" {
+			if rec.Err == nil || !strings.Contains(rec.Err.Error(), tt.err) {
+				t.Errorf("%s: error %v, want %q", tt.path, rec.Err, tt.err)
+			}
+			continue
+		}
+		if rec.Err != nil || rec.SegmentBytes != 4096 || rec.Segment <= 0 || rec.Manifest <= 0 {
+			t.Errorf("%s: error %v, segment %d bytes in %v, manifest %v", tt.path, rec.Err, rec.SegmentBytes, rec.Segment, rec.Manifest)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Request components that -hmac-fields can sign, besides "header:Name" for
+// the value of any request header.
+const (
+	hmacMethod = "method"
+	hmacPath   = "path" // with the query string, as sent
+	hmacHost   = "host"
+	hmacDate   = "date" // the Date header, added to the request if missing
+	hmacBody   = "body"
+)
+
+var hmacHashes = map[string]func() hash.Hash{
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// Signature encodings for -hmac-encoding.
+const (
+	hmacHex    = "hex"
+	hmacBase64 = "base64"
+)
+
+// parseHMACFields splits a comma separated -hmac-fields list.
+func parseHMACFields(s string) ([]string, error) {
+	var fields []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		switch {
+		case f == hmacMethod, f == hmacPath, f == hmacHost, f == hmacDate, f == hmacBody:
+		case strings.HasPrefix(f, "header:") && len(f) > len("header:"):
+		default:
+			return nil, fmt.Errorf("invalid hmac field %q, expected method, path, host, date, body or header:Name", f)
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func (t Target) validateHMAC() error {
+	if t.Mode != modeHTTP {
+		return fmt.Errorf("-hmac needs http mode")
+	}
+	if t.HMACHeader == "" {
+		return fmt.Errorf("-hmac needs a signature header")
+	}
+	if _, ok := hmacHashes[t.HMACHash]; !ok {
+		return fmt.Errorf("invalid hmac hash %q, expected sha1, sha256 or sha512", t.HMACHash)
+	}
+	if t.HMACEncoding != hmacHex && t.HMACEncoding != hmacBase64 {
+		return fmt.Errorf("invalid hmac encoding %q, expected hex or base64", t.HMACEncoding)
+	}
+	_, err := parseHMACFields(t.HMACFields)
+	return err
+}
+
+// signRequest sets the HMAC header of req to the signature of the request
+// components in t.HMACFields, one per line.
+func signRequest(req *http.Request, t Target) error {
+	fields, err := parseHMACFields(t.HMACFields)
+	if err != nil {
+		return err
+	}
+	values := make([]string, len(fields))
+	for i, f := range fields {
+		switch f {
+		case hmacMethod:
+			values[i] = req.Method
+		case hmacPath:
+			values[i] = req.URL.RequestURI()
+		case hmacHost:
+			values[i] = req.Host
+			if values[i] == "" {
+				values[i] = req.URL.Host
+			}
+		case hmacDate:
+			if req.Header.Get("Date") == "" {
+				req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+			}
+			values[i] = req.Header.Get("Date")
+		case hmacBody:
+			if req.GetBody == nil {
+				break
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return fmt.Errorf("hmac: %w", err)
+			}
+			b, err := ioutil.ReadAll(body)
+			if err != nil {
+				return fmt.Errorf("hmac: %w", err)
+			}
+			values[i] = string(b)
+		default:
+			values[i] = req.Header.Get(strings.TrimPrefix(f, "header:"))
+		}
+	}
+
+	mac := hmac.New(hmacHashes[t.HMACHash], []byte(t.HMACKey))
+	mac.Write([]byte(strings.Join(values, "\n")))
+	sum := mac.Sum(nil)
+	if t.HMACEncoding == hmacBase64 {
+		req.Header.Set(t.HMACHeader, base64.StdEncoding.EncodeToString(sum))
+	} else {
+		req.Header.Set(t.HMACHeader, hex.EncodeToString(sum))
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParseHMACFields(t *testing.T) {
+	fields, err := parseHMACFields("method, path,host,date,body,header:X-Request-Id")
+	want := []string{"method", "path", "host", "date", "body", "header:X-Request-Id"}
+	if err != nil || !equalStrings(fields, want) {
+		t.Errorf("parseHMACFields = %q, %v, want %q", fields, err, want)
+	}
+	for _, s := range []string{"", "method,,path", "query", "header:"} {
+		if _, err := parseHMACFields(s); err == nil {
+			t.Errorf("parseHMACFields(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestValidateHMAC(t *testing.T) {
+	valid := Target{Mode: modeHTTP, HMACHeader: "X-Signature", HMACHash: "sha256", HMACEncoding: hmacHex, HMACFields: "method,path"}
+	tests := []struct {
+		change func(*Target)
+		ok     bool
+	}{
+		{func(*Target) {}, true},
+		{func(t *Target) { t.Mode = modeGRPC }, false},
+		{func(t *Target) { t.HMACHeader = "" }, false},
+		{func(t *Target) { t.HMACHash = "md5" }, false},
+		{func(t *Target) { t.HMACEncoding = "base32" }, false},
+		{func(t *Target) { t.HMACFields = "method,query" }, false},
+	}
+	for i, tt := range tests {
+		target := valid
+		tt.change(&target)
+		if err := target.validateHMAC(); (err == nil) != tt.ok {
+			t.Errorf("%d: error %v, want ok %v", i, err, tt.ok)
+		}
+	}
+}
+
+func TestSignRequest(t *testing.T) {
+	sign := func(hash string, key, msg string) []byte {
+		h := sha256.New
+		if hash == "sha512" {
+			h = sha512.New
+		}
+		mac := hmac.New(h, []byte(key))
+		mac.Write([]byte(msg))
+		return mac.Sum(nil)
+	}
+
+	tests := []struct {
+		target Target
+		signed string
+		encode func([]byte) string
+	}{
+		{
+			Target{HMACFields: "method,path,host", HMACHash: "sha256", HMACEncoding: hmacHex},
+			"POST\n/v1/items?id=7\napi.example.com",
+			hex.EncodeToString,
+		},
+		{
+			Target{HMACFields: "date,body,header:X-Request-Id", HMACHash: "sha512", HMACEncoding: hmacBase64},
+			"Mon, 01 Jan 2024 12:00:00 GMT\n{\"a\":1}\nreq-42",
+			base64.StdEncoding.EncodeToString,
+		},
+	}
+	for _, tt := range tests {
+		tt.target.HMACKey, tt.target.HMACHeader = "s3cret", "X-Signature"
+		req, _ := http.NewRequest(http.MethodPost, "https://api.example.com/v1/items?id=7", strings.NewReader(`{"a":1}`))
+		req.Header.Set("Date", "Mon, 01 Jan 2024 12:00:00 GMT")
+		req.Header.Set("X-Request-Id", "req-42")
+		if err := signRequest(req, tt.target); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := req.Header.Get("X-Signature"), tt.encode(sign(tt.target.HMACHash, "s3cret", tt.signed)); got != want {
+			t.Errorf("%s: signature %s, want %s", tt.target.HMACFields, got, want)
+		}
+	}
+
+	// A missing Date header is added so the server can check it.
+	req, _ := http.NewRequest(http.MethodGet, "https://api.example.com/", nil)
+	target := Target{HMACFields: "date", HMACHash: "sha256", HMACEncoding: hmacHex, HMACHeader: "X-Signature"}
+	if err := signRequest(req, target); err != nil || req.Header.Get("Date") == "" {
+		t.Errorf("date header %q, error %v", req.Header.Get("Date"), err)
+	}
+}
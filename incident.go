@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	defaultOpsgenieURL  = "https://api.opsgenie.com"
+
+	notifyTimeout = 10 * time.Second
+)
+
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// incidentKey identifies the incident of a target so that the recovery
+// resolves the incident opened when it went down.
+func incidentKey(ev alertEvent) string {
+	return "hilicurl:" + ev.Target
+}
+
+func incidentSummary(ev alertEvent) string {
+	if ev.State == stateUp {
+		return fmt.Sprintf("%s is up after %v", ev.Target, ev.Duration)
+	}
+	reason := ev.Error
+	if reason == "" {
+		reason = ev.Status
+	}
+	return fmt.Sprintf("%s is down: %s", ev.Target, reason)
+}
+
+// pagerDutyNotifier triggers and resolves PagerDuty incidents through the
+// Events API v2.
+type pagerDutyNotifier struct {
+	url        string
+	routingKey string
+}
+
+func (n *pagerDutyNotifier) notify(ev alertEvent) error {
+	action := "trigger"
+	if ev.State == stateUp {
+		action = "resolve"
+	}
+	source, _ := os.Hostname()
+
+	return postJSON(n.url, nil, map[string]interface{}{
+		"routing_key":  n.routingKey,
+		"event_action": action,
+		"dedup_key":    incidentKey(ev),
+		"payload": map[string]interface{}{
+			"summary":   incidentSummary(ev),
+			"source":    source,
+			"severity":  "critical",
+			"timestamp": ev.Time.Format(time.RFC3339),
+			"custom_details": map[string]interface{}{
+				"url":       ev.URL,
+				"since":     ev.Since,
+				"failures":  ev.Failures,
+				"status":    ev.Status,
+				"error":     ev.Error,
+				"burn_rate": ev.BurnRate,
+			},
+		},
+	})
+}
+
+// opsgenieNotifier creates and closes Opsgenie alerts, using the alias to
+// match the two.
+type opsgenieNotifier struct {
+	url    string
+	apiKey string
+}
+
+func (n *opsgenieNotifier) notify(ev alertEvent) error {
+	header := http.Header{"Authorization": {"GenieKey " + n.apiKey}}
+	alias := incidentKey(ev)
+
+	if ev.State == stateUp {
+		u := fmt.Sprintf("%s/v2/alerts/%s/close?identifierType=alias", n.url, url.PathEscape(alias))
+		return postJSON(u, header, map[string]interface{}{
+			"source": "hilicurl",
+			"note":   incidentSummary(ev),
+		})
+	}
+
+	return postJSON(n.url+"/v2/alerts", header, map[string]interface{}{
+		"message":     incidentSummary(ev),
+		"alias":       alias,
+		"description": fmt.Sprintf("%s has failed %d probes since %s.", ev.URL, ev.Failures, ev.Since.Format(time.RFC3339)),
+		"source":      "hilicurl",
+		"priority":    "P1",
+		"details": map[string]string{
+			"url":    ev.URL,
+			"status": ev.Status,
+			"error":  ev.Error,
+		},
+	})
+}
+
+// postJSON sends v as a JSON POST request and fails on non-2xx responses.
+func postJSON(u string, header http.Header, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, u, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := notifyClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("POST %s: %s: %s", u, res.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// incidentRequest is a request received by incidentServer.
+type incidentRequest struct {
+	path, auth string
+	body       map[string]interface{}
+}
+
+func incidentServer(t *testing.T, status int) (string, <-chan incidentRequest) {
+	t.Helper()
+	reqs := make(chan incidentRequest, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := incidentRequest{path: r.URL.RequestURI(), auth: r.Header.Get("Authorization")}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("content type %q", r.Header.Get("Content-Type"))
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req.body); err != nil {
+			t.Error(err)
+		}
+		reqs <- req
+		w.WriteHeader(status)
+		w.Write([]byte(`{"message": "bad key"}` + "\n"))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, reqs
+}
+
+func incidentEvents() (down, up alertEvent) {
+	since := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	down = alertEvent{Target: "api", URL: "https://example.com/", State: stateDown, Time: since.Add(time.Minute),
+		Since: since, Failures: 3, Status: "503 Service Unavailable"}
+	up = alertEvent{Target: "api", URL: "https://example.com/", State: stateUp, Time: since.Add(5 * time.Minute),
+		Since: since, Duration: 5 * time.Minute}
+	return down, up
+}
+
+func TestPagerDutyNotifier(t *testing.T) {
+	u, reqs := incidentServer(t, http.StatusAccepted)
+	n := &pagerDutyNotifier{url: u + "/v2/enqueue", routingKey: "R0UT1NG"}
+	down, up := incidentEvents()
+
+	tests := []struct {
+		ev              alertEvent
+		action, summary string
+	}{
+		{down, "trigger", "api is down: 503 Service Unavailable"},
+		{up, "resolve", "api is up after 5m0s"},
+	}
+	for _, tt := range tests {
+		if err := n.notify(tt.ev); err != nil {
+			t.Fatal(err)
+		}
+		req := <-reqs
+		payload, _ := req.body["payload"].(map[string]interface{})
+		if req.path != "/v2/enqueue" || req.body["routing_key"] != "R0UT1NG" || req.body["dedup_key"] != "hilicurl:api" ||
+			req.body["event_action"] != tt.action || payload["summary"] != tt.summary {
+			t.Errorf("%s event: request %s %v, want action %s and summary %q", tt.ev.State, req.path, req.body, tt.action, tt.summary)
+		}
+	}
+}
+
+func TestOpsgenieNotifier(t *testing.T) {
+	u, reqs := incidentServer(t, http.StatusAccepted)
+	n := &opsgenieNotifier{url: u, apiKey: "k3y"}
+	down, up := incidentEvents()
+	down.Error = "connection refused"
+
+	if err := n.notify(down); err != nil {
+		t.Fatal(err)
+	}
+	req := <-reqs
+	if req.path != "/v2/alerts" || req.auth != "GenieKey k3y" || req.body["alias"] != "hilicurl:api" ||
+		req.body["message"] != "api is down: connection refused" ||
+		req.body["description"] != "https://example.com/ has failed 3 probes since 2024-01-01T12:00:00Z." {
+		t.Errorf("create request %s %q %v", req.path, req.auth, req.body)
+	}
+
+	if err := n.notify(up); err != nil {
+		t.Fatal(err)
+	}
+	req = <-reqs
+	if req.path != "/v2/alerts/hilicurl:api/close?identifierType=alias" || req.auth != "GenieKey k3y" || req.body["note"] != "api is up after 5m0s" {
+		t.Errorf("close request %s %q %v", req.path, req.auth, req.body)
+	}
+}
+
+func TestPostJSONError(t *testing.T) {
+	u, reqs := incidentServer(t, http.StatusUnauthorized)
+	err := postJSON(u, nil, map[string]string{"a": "b"})
+	<-reqs
+	if err == nil || !strings.HasSuffix(err.Error(), `401 Unauthorized: {"message": "bad key"}`) {
+		t.Errorf("error %v, want the status and response body", err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bufio"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// version is the release of hilicurl, set at build time with
+// -ldflags "-X main.version=v1.2.3". Otherwise the module version is used.
+var version = ""
+
+func toolVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		return info.Main.Version
+	}
+	return "unknown"
+}
+
+// runMetadata describes the tool, host and effective configuration behind
+// the results of a target, so that results shared between people can be
+// reproduced and compared.
+type runMetadata struct {
+	Version   string       `json:"version"`
+	GoVersion string       `json:"go_version"`
+	OS        string       `json:"os"`
+	Arch      string       `json:"arch"`
+	Hostname  string       `json:"hostname,omitempty"`
+	Resolver  resolverInfo `json:"resolver"`
+	Config    Target       `json:"config"`
+}
+
+type resolverInfo struct {
+	// Kind is system, or builtin with -dns-timeout or -dns-attempts.
+	Kind        string   `json:"kind"`
+	Timeout     Duration `json:"timeout,omitempty"`
+	Attempts    int      `json:"attempts,omitempty"`
+	Nameservers []string `json:"nameservers,omitempty"`
+}
+
+func newRunMetadata(t Target) *runMetadata {
+	hostname, _ := os.Hostname()
+	m := &runMetadata{
+		Version:   toolVersion(),
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+		Hostname:  hostname,
+		Resolver:  resolverInfo{Kind: "system", Nameservers: nameservers()},
+		Config:    redactTarget(t),
+	}
+	if r := newResolver(t); r != nil {
+		m.Resolver.Kind, m.Resolver.Timeout, m.Resolver.Attempts = "builtin", Duration(r.timeout), r.attempts
+	}
+	return m
+}
+
+// nameservers returns the name servers of /etc/resolv.conf, if any.
+func nameservers() []string {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var servers []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if fields := strings.Fields(sc.Text()); len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// redactTarget hides the credentials of a target: the URL password and the
+// values of headers carrying credentials.
+func redactTarget(t Target) Target {
+	if u, err := url.Parse(t.URL); err == nil && u.User != nil {
+		t.URL = u.Redacted()
+	}
+	if u, err := url.Parse(t.Proxy); err == nil && u.User != nil {
+		t.Proxy = u.Redacted()
+	}
+	if i := strings.Index(t.ProxyUser, ":"); i >= 0 {
+		t.ProxyUser = t.ProxyUser[:i] + ":xxxxx"
+	}
+	if t.HMACKey != "" {
+		t.HMACKey = "xxxxx"
+	}
+	headers := make([]string, len(t.Headers))
+	for i, h := range t.Headers {
+		name, _, err := parseHeader(h)
+		if err == nil && sensitiveHeader(name) {
+			h = name + ": xxxxx"
+		}
+		headers[i] = h
+	}
+	if t.Headers != nil {
+		t.Headers = headers
+	}
+	return t
+}
+
+func sensitiveHeader(name string) bool {
+	name = strings.ToLower(name)
+	switch name {
+	case "authorization", "proxy-authorization", "cookie":
+		return true
+	}
+	for _, s := range []string{"token", "key", "secret", "password"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Nagios plugin exit codes.
+const (
+	nagiosOK       = 0
+	nagiosWarning  = 1
+	nagiosCritical = 2
+	nagiosUnknown  = 3
+)
+
+var nagiosStates = [...]string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// runNagios probes t once and prints the result as a Nagios plugin would:
+// a single status line with performance data. It returns the exit code.
+//
+// Like check_http, 4xx responses are a warning and 5xx responses, errors and
+// failed assertions are critical. Slow responses are a warning or critical
+// according to the warn and crit thresholds, if set.
+func runNagios(ctx context.Context, t Target, warn, crit time.Duration) int {
+	logger := log.New(ioutil.Discard, "", 0)
+	client, _ := newClient(t)
+
+	rCtx, cancel := context.WithTimeout(ctx, time.Duration(t.Timeout))
+	defer cancel()
+	rec := request(rCtx, logger, client, t)
+
+	code, msg := nagiosResult(rec, warn, crit)
+	perf := fmt.Sprintf("time=%.6fs;%s;%s;0; size=%dB;;;0",
+		rec.ElapsedTime.Seconds(), nagiosThreshold(warn), nagiosThreshold(crit), rec.BytesRead)
+	fmt.Printf("HTTP %s - %s | %s\n", nagiosStates[code], msg, perf)
+	return code
+}
+
+func nagiosResult(rec Record, warn, crit time.Duration) (int, string) {
+	if rec.Err != nil {
+		return nagiosCritical, fmt.Sprintf("%s: %v", errorLabel(rec), rec.Err)
+	}
+
+	msg := fmt.Sprintf("%s - %s in %s", rec.Status, formatBytes(rec.BytesRead), formatDuration(rec.ElapsedTime))
+	switch {
+	case len(rec.Failures) > 0:
+		return nagiosCritical, fmt.Sprintf("%s, assertion failed: %s", msg, rec.Failures[0])
+	case rec.StatusCode >= 500:
+		return nagiosCritical, msg
+	case crit > 0 && rec.ElapsedTime >= crit:
+		return nagiosCritical, msg + " exceeds " + formatDuration(crit)
+	case rec.StatusCode >= 400:
+		return nagiosWarning, msg
+	case warn > 0 && rec.ElapsedTime >= warn:
+		return nagiosWarning, msg + " exceeds " + formatDuration(warn)
+	}
+	return nagiosOK, msg
+}
+
+func nagiosThreshold(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("%.6f", d.Seconds())
+}
+
+// nagiosRequested reports whether args turn on -nagios. It is used when
+// they fail to parse, so that the error is reported as UNKNOWN too.
+func nagiosRequested(args []string) bool {
+	on := false
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		value := "true"
+		if i := strings.Index(name, "="); i >= 0 {
+			name, value = name[:i], name[i+1:]
+		}
+		if name == "nagios" {
+			on, _ = strconv.ParseBool(value)
+		}
+	}
+	return on
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNagiosResult(t *testing.T) {
+	ms := time.Millisecond
+	ok := func(code int, elapsed time.Duration) Record {
+		return Record{Responded: true, StatusCode: code, Status: fmt.Sprintf("%d %s", code, http.StatusText(code)), BytesRead: 512, ElapsedTime: elapsed}
+	}
+	failed := ok(200, 10*ms)
+	failed.Failures = []string{"status=ok: got degraded"}
+	tests := []struct {
+		rec  Record
+		code int
+		msg  string
+	}{
+		{ok(200, 10*ms), nagiosOK, "200 OK - 512 B in 10.0 ms"},
+		{ok(200, 300*ms), nagiosWarning, "200 OK - 512 B in 300.0 ms exceeds 200.0 ms"},
+		{ok(200, 600*ms), nagiosCritical, "200 OK - 512 B in 600.0 ms exceeds 500.0 ms"},
+		{ok(404, 10*ms), nagiosWarning, "404 Not Found - 512 B in 10.0 ms"},
+		{ok(404, 600*ms), nagiosCritical, "404 Not Found - 512 B in 600.0 ms exceeds 500.0 ms"},
+		{ok(503, 10*ms), nagiosCritical, "503 Service Unavailable - 512 B in 10.0 ms"},
+		{failed, nagiosCritical, "200 OK - 512 B in 10.0 ms, assertion failed: status=ok: got degraded"},
+		{Record{Err: errors.New("connection refused")}, nagiosCritical, "error: connection refused"},
+	}
+	for _, tt := range tests {
+		code, msg := nagiosResult(tt.rec, 200*ms, 500*ms)
+		if code != tt.code || msg != tt.msg {
+			t.Errorf("nagiosResult = %s %q, want %s %q", nagiosStates[code], msg, nagiosStates[tt.code], tt.msg)
+		}
+	}
+	if code, _ := nagiosResult(ok(200, time.Minute), 0, 0); code != nagiosOK {
+		t.Errorf("slow response without thresholds is %s, want OK", nagiosStates[code])
+	}
+}
+
+func TestNagiosRequested(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{nil, false},
+		{[]string{"-nagios", "https://example.com/"}, true},
+		{[]string{"--nagios", "-timeout", "bogus"}, true},
+		{[]string{"-nagios=true"}, true},
+		{[]string{"-nagios=false"}, false},
+		{[]string{"-nagios", "-nagios=0"}, false},
+		{[]string{"-nagios-warn", "1s"}, false},
+		{[]string{"--", "-nagios"}, false},
+		{[]string{"nagios"}, false},
+	}
+	for _, tt := range tests {
+		if got := nagiosRequested(tt.args); got != tt.want {
+			t.Errorf("nagiosRequested(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
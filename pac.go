@@ -0,0 +1,568 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// pacScript is a proxy auto-config file. There is no JavaScript engine in
+// the standard library, so only the subset PAC files are usually written in
+// is understood: a FindProxyForURL function made of if/else statements,
+// var declarations and returns, whose conditions combine the PAC helper
+// functions, string literals and == or != comparisons with !, && and ||.
+type pacScript struct {
+	urlParam, hostParam string
+	body                []pacStmt
+}
+
+// pacEnv holds the variables of one FindProxyForURL call.
+type pacEnv struct {
+	ctx  context.Context
+	vars map[string]interface{}
+}
+
+// A pacStmt runs a statement, returning the result of FindProxyForURL if
+// the statement returned.
+type pacStmt func(env *pacEnv) (string, bool, error)
+
+// A pacExpr evaluates to a string or a bool.
+type pacExpr func(env *pacEnv) (interface{}, error)
+
+// pacLookupTimeout bounds the DNS lookups of dnsResolve, isInNet and
+// isResolvable.
+const pacLookupTimeout = 5 * time.Second
+
+// findProxy calls FindProxyForURL of the script and returns its result,
+// like "PROXY proxy:8080; DIRECT".
+func (p *pacScript) findProxy(ctx context.Context, rawURL, host string) (string, error) {
+	env := &pacEnv{ctx: ctx, vars: map[string]interface{}{p.urlParam: rawURL, p.hostParam: host}}
+	result, returned, err := runPAC(p.body, env)
+	if err == nil && !returned {
+		err = fmt.Errorf("pac: FindProxyForURL returned nothing")
+	}
+	return result, err
+}
+
+// parsePAC parses the FindProxyForURL function of a PAC file.
+func parsePAC(src string) (*pacScript, error) {
+	toks, err := pacTokens(src)
+	if err != nil {
+		return nil, fmt.Errorf("pac: %w", err)
+	}
+	p := &pacParser{toks: toks}
+	script, err := p.script()
+	if err != nil {
+		return nil, fmt.Errorf("pac: %w", err)
+	}
+	return script, nil
+}
+
+type pacToken struct {
+	kind byte // 'i' identifier, 's' string or the punctuation itself
+	text string
+	line int
+}
+
+func pacTokens(src string) ([]pacToken, error) {
+	var toks []pacToken
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case c == '"' || c == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+				}
+				if src[j] == '\n' {
+					return nil, fmt.Errorf("line %d: unterminated string", line)
+				}
+				sb.WriteByte(src[j])
+			}
+			if j == len(src) {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			toks = append(toks, pacToken{'s', sb.String(), line})
+			i = j + 1
+		case c == '_' || c == '$' || unicode.IsLetter(rune(c)):
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] == '$' || unicode.IsLetter(rune(src[j])) || unicode.IsDigit(rune(src[j]))) {
+				j++
+			}
+			toks = append(toks, pacToken{'i', src[i:j], line})
+			i = j
+		default:
+			op := string(c)
+			for _, long := range []string{"===", "!==", "&&", "||", "==", "!="} {
+				if strings.HasPrefix(src[i:], long) {
+					op = long
+					break
+				}
+			}
+			if len(op) == 1 && !strings.Contains("(){},;!=", op) {
+				return nil, fmt.Errorf("line %d: unsupported syntax %q", line, op)
+			}
+			i += len(op)
+			// === and !== compare strings the same way as == and != here.
+			if len(op) == 3 {
+				op = op[:2]
+			}
+			toks = append(toks, pacToken{op[0], op, line})
+		}
+	}
+	return toks, nil
+}
+
+type pacParser struct {
+	toks []pacToken
+	pos  int
+}
+
+func (p *pacParser) peek() pacToken {
+	if p.pos < len(p.toks) {
+		return p.toks[p.pos]
+	}
+	return pacToken{line: -1}
+}
+
+func (p *pacParser) next() pacToken {
+	t := p.peek()
+	p.pos++
+	return t
+}
+
+func (p *pacParser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	if t.line < 0 {
+		return fmt.Errorf("unexpected end of file: "+format, args...)
+	}
+	return fmt.Errorf("line %d: "+format, append([]interface{}{t.line}, args...)...)
+}
+
+func (p *pacParser) expect(text string) error {
+	if p.peek().text != text || p.peek().kind == 's' {
+		return p.errorf("expected %q, found %q", text, p.peek().text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *pacParser) ident() (string, error) {
+	if p.peek().kind != 'i' {
+		return "", p.errorf("expected a name, found %q", p.peek().text)
+	}
+	return p.next().text, nil
+}
+
+func (p *pacParser) script() (*pacScript, error) {
+	// Helper functions besides FindProxyForURL are not supported.
+	for p.pos < len(p.toks) {
+		if p.peek().text == "function" && p.pos+1 < len(p.toks) && p.toks[p.pos+1].text == "FindProxyForURL" {
+			break
+		}
+		p.pos++
+	}
+	if err := p.expect("function"); err != nil {
+		return nil, fmt.Errorf("no FindProxyForURL function")
+	}
+	p.pos++
+
+	s := &pacScript{}
+	var err error
+	if err = p.expect("("); err != nil {
+		return nil, err
+	}
+	if s.urlParam, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err = p.expect(","); err != nil {
+		return nil, err
+	}
+	if s.hostParam, err = p.ident(); err != nil {
+		return nil, err
+	}
+	if err = p.expect(")"); err != nil {
+		return nil, err
+	}
+	if s.body, err = p.block(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (p *pacParser) block() ([]pacStmt, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var stmts []pacStmt
+	for p.peek().text != "}" || p.peek().kind == 's' {
+		if p.peek().line < 0 {
+			return nil, p.errorf("missing }")
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		if stmt != nil {
+			stmts = append(stmts, stmt)
+		}
+	}
+	p.pos++
+	return stmts, nil
+}
+
+func runPAC(stmts []pacStmt, env *pacEnv) (string, bool, error) {
+	for _, stmt := range stmts {
+		if result, returned, err := stmt(env); err != nil || returned {
+			return result, returned, err
+		}
+	}
+	return "", false, nil
+}
+
+func (p *pacParser) statement() (pacStmt, error) {
+	tok := p.peek()
+	if tok.kind == 's' {
+		return nil, p.errorf("unexpected string %q", tok.text)
+	}
+	switch tok.text {
+	case ";":
+		p.pos++
+		return nil, nil
+	case "{":
+		stmts, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *pacEnv) (string, bool, error) { return runPAC(stmts, env) }, nil
+	case "return":
+		p.pos++
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek().text == ";" {
+			p.pos++
+		}
+		return func(env *pacEnv) (string, bool, error) {
+			v, err := expr(env)
+			if err != nil {
+				return "", false, err
+			}
+			s, ok := v.(string)
+			if !ok {
+				return "", false, fmt.Errorf("pac: FindProxyForURL returned %v instead of a string", v)
+			}
+			return s, true, nil
+		}, nil
+	case "var", "let", "const":
+		p.pos++
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		return p.assignment(name)
+	case "if":
+		p.pos++
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		then, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		var otherwise pacStmt
+		if p.peek().text == "else" {
+			p.pos++
+			if otherwise, err = p.statement(); err != nil {
+				return nil, err
+			}
+		}
+		return func(env *pacEnv) (string, bool, error) {
+			v, err := cond(env)
+			if err != nil {
+				return "", false, err
+			}
+			branch := otherwise
+			if truthy(v) {
+				branch = then
+			}
+			if branch == nil {
+				return "", false, nil
+			}
+			return branch(env)
+		}, nil
+	}
+
+	if tok.kind == 'i' && p.pos+1 < len(p.toks) && p.toks[p.pos+1].text == "=" {
+		p.pos += 2
+		return p.assignment(tok.text)
+	}
+	return nil, p.errorf("unsupported statement starting with %q", tok.text)
+}
+
+func (p *pacParser) assignment(name string) (pacStmt, error) {
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().text == ";" {
+		p.pos++
+	}
+	return func(env *pacEnv) (string, bool, error) {
+		v, err := expr(env)
+		env.vars[name] = v
+		return "", false, err
+	}, nil
+}
+
+func (p *pacParser) expr() (pacExpr, error) {
+	return p.binary("||", func() (pacExpr, error) {
+		return p.binary("&&", p.comparison)
+	})
+}
+
+// binary parses operands joined with the logical operator op, which
+// short-circuits like in JavaScript.
+func (p *pacParser) binary(op string, operand func() (pacExpr, error)) (pacExpr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().text == op && p.peek().kind != 's' {
+		p.pos++
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(env *pacEnv) (interface{}, error) {
+			v, err := l(env)
+			if err != nil || truthy(v) == (op == "||") {
+				return v, err
+			}
+			return right(env)
+		}
+	}
+	return left, nil
+}
+
+func (p *pacParser) comparison() (pacExpr, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	op := p.peek().text
+	if p.peek().kind == 's' || op != "==" && op != "!=" {
+		return left, nil
+	}
+	p.pos++
+	right, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	return func(env *pacEnv) (interface{}, error) {
+		l, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+		return (l == r) == (op == "=="), nil
+	}, nil
+}
+
+func (p *pacParser) unary() (pacExpr, error) {
+	tok := p.next()
+	switch {
+	case tok.kind == 's':
+		return func(*pacEnv) (interface{}, error) { return tok.text, nil }, nil
+	case tok.text == "!":
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *pacEnv) (interface{}, error) {
+			v, err := operand(env)
+			return !truthy(v), err
+		}, nil
+	case tok.text == "(":
+		expr, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return expr, p.expect(")")
+	case tok.text == "true" || tok.text == "false":
+		return func(*pacEnv) (interface{}, error) { return tok.text == "true", nil }, nil
+	case tok.kind == 'i' && p.peek().text == "(":
+		return p.call(tok.text)
+	case tok.kind == 'i':
+		return func(env *pacEnv) (interface{}, error) {
+			v, ok := env.vars[tok.text]
+			if !ok {
+				return nil, fmt.Errorf("pac: line %d: %s is not defined", tok.line, tok.text)
+			}
+			return v, nil
+		}, nil
+	}
+	p.pos--
+	return nil, p.errorf("unexpected %q", tok.text)
+}
+
+// pacFuncs are the PAC helper functions understood, with their number of
+// arguments.
+var pacFuncs = map[string]int{
+	"isPlainHostName":     1,
+	"dnsDomainIs":         2,
+	"localHostOrDomainIs": 2,
+	"shExpMatch":          2,
+	"isResolvable":        1,
+	"dnsResolve":          1,
+	"isInNet":             3,
+	"myIpAddress":         0,
+}
+
+func (p *pacParser) call(name string) (pacExpr, error) {
+	want, ok := pacFuncs[name]
+	if !ok {
+		p.pos--
+		return nil, p.errorf("unsupported function %s", name)
+	}
+	p.pos++
+	var args []pacExpr
+	for p.peek().text != ")" || p.peek().kind == 's' {
+		if len(args) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.pos++
+	if len(args) != want {
+		return nil, p.errorf("%s takes %d arguments, got %d", name, want, len(args))
+	}
+
+	return func(env *pacEnv) (interface{}, error) {
+		strs := make([]string, len(args))
+		for i, arg := range args {
+			v, err := arg(env)
+			if err != nil {
+				return nil, err
+			}
+			strs[i] = fmt.Sprint(v)
+		}
+		return pacCall(env.ctx, name, strs), nil
+	}, nil
+}
+
+func pacCall(ctx context.Context, name string, args []string) interface{} {
+	switch name {
+	case "isPlainHostName":
+		return !strings.Contains(args[0], ".")
+	case "dnsDomainIs":
+		return strings.HasSuffix(strings.ToLower(args[0]), strings.ToLower(args[1]))
+	case "localHostOrDomainIs":
+		host, fqdn := strings.ToLower(args[0]), strings.ToLower(args[1])
+		return host == fqdn || !strings.Contains(host, ".") && strings.HasPrefix(fqdn, host+".")
+	case "shExpMatch":
+		return shExpMatch(args[0], args[1])
+	case "isResolvable":
+		return pacResolve(ctx, args[0]) != ""
+	case "dnsResolve":
+		return pacResolve(ctx, args[0])
+	case "isInNet":
+		ip, mask := net.ParseIP(pacResolve(ctx, args[0])), net.ParseIP(args[2]).To4()
+		if ip == nil || mask == nil {
+			return false
+		}
+		m := net.IPMask(mask)
+		return ip.Mask(m).Equal(net.ParseIP(args[1]).Mask(m))
+	case "myIpAddress":
+		return myIPAddress()
+	}
+	return nil
+}
+
+func truthy(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	return false
+}
+
+// shExpMatch matches s against a shell expression with * and ?.
+func shExpMatch(s, pattern string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\*`, ".*")
+	re = strings.ReplaceAll(re, `\?`, ".")
+	ok, _ := regexp.MatchString("^"+re+"$", s)
+	return ok
+}
+
+// pacResolve returns an IPv4 address of host, or "" if it does not resolve.
+func pacResolve(ctx context.Context, host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return host
+	}
+	ctx, cancel := context.WithTimeout(ctx, pacLookupTimeout)
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip4", host)
+	if err != nil || len(ips) == 0 {
+		return ""
+	}
+	return ips[0].String()
+}
+
+// myIPAddress returns the address of the interface of the default route.
+// Dialing UDP sends no packet.
+func myIPAddress() string {
+	conn, err := net.Dial("udp", "192.0.2.1:53")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+const testPAC = `// Corporate proxy selection.
+function FindProxyForURL(url, host) {
+	/* Internal hosts go direct. */
+	if (isPlainHostName(host) || dnsDomainIs(host, ".corp.example.com"))
+		return "DIRECT";
+	if (isInNet(host, "10.0.0.0", "255.0.0.0")) {
+		return 'DIRECT';
+	}
+	var secure = shExpMatch(url, "https://*");
+	if (host === "www.example.org" && !secure) {
+		return "PROXY web-proxy:3128";
+	} else if (shExpMatch(host, "*.example.net") || localHostOrDomainIs(host, "mirror.example.org")) {
+		return "HTTPS tls-proxy:443; DIRECT";
+	}
+	let fallback = "PROXY proxy:8080";
+	if (secure != false) fallback = "SOCKS5 socks:1080";
+	return fallback;
+}`
+
+func TestFindProxy(t *testing.T) {
+	script, err := parsePAC(testPAC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		url, host string
+		want      string
+	}{
+		{"http://intranet/", "intranet", "DIRECT"},
+		{"http://wiki.corp.example.com/", "wiki.corp.example.com", "DIRECT"},
+		{"http://10.1.2.3/", "10.1.2.3", "DIRECT"},
+		{"http://11.1.2.3/", "11.1.2.3", "PROXY proxy:8080"},
+		{"http://www.example.org/", "www.example.org", "PROXY web-proxy:3128"},
+		{"https://www.example.org/", "www.example.org", "SOCKS5 socks:1080"},
+		{"https://cdn.example.net/", "cdn.example.net", "HTTPS tls-proxy:443; DIRECT"},
+		{"http://mirror/", "mirror", "DIRECT"},
+		{"http://mirror.example.org/", "mirror.example.org", "HTTPS tls-proxy:443; DIRECT"},
+		{"http://example.com/", "example.com", "PROXY proxy:8080"},
+	}
+	for _, tt := range tests {
+		got, err := script.findProxy(context.Background(), tt.url, tt.host)
+		if err != nil {
+			t.Errorf("findProxy(%s): %v", tt.url, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("findProxy(%s) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+func TestParsePACErrors(t *testing.T) {
+	for _, src := range []string{
+		``,
+		`function Other(url, host) { return "DIRECT"; }`,
+		`function FindProxyForURL(url, host) { return "DIRECT"; `,
+		`function FindProxyForURL(url, host) { return alert("x"); }`,
+		`function FindProxyForURL(url, host) { for (;;) {} }`,
+		`function FindProxyForURL(url, host) { return 1 + 2; }`,
+		`function FindProxyForURL(url, host) { return "DIRECT }`,
+		`function FindProxyForURL(url, host) { return isInNet(host); }`,
+	} {
+		if _, err := parsePAC(src); err == nil {
+			t.Errorf("parsePAC(%q) succeeded, want an error", src)
+		}
+	}
+}
+
+func TestFindProxyWithoutReturn(t *testing.T) {
+	script, err := parsePAC(`function FindProxyForURL(url, host) { if (false) return "DIRECT"; }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := script.findProxy(context.Background(), "http://a/", "a"); err == nil {
+		t.Error("findProxy succeeded without a return, want an error")
+	}
+}
+
+func TestShExpMatch(t *testing.T) {
+	tests := []struct {
+		s, pattern string
+		want       bool
+	}{
+		{"www.example.com", "*.example.com", true},
+		{"example.com", "*.example.com", false},
+		{"http://a/b", "http://*", true},
+		{"host1", "host?", true},
+		{"host12", "host?", false},
+		{"a.b", "a?b", true},
+		{"axb", "a.b", false},
+		{"a+b", "a+b", true},
+		{"", "*", true},
+	}
+	for _, tt := range tests {
+		if got := shExpMatch(tt.s, tt.pattern); got != tt.want {
+			t.Errorf("shExpMatch(%q, %q) = %v, want %v", tt.s, tt.pattern, got, tt.want)
+		}
+	}
+}
+
+func TestIsInNet(t *testing.T) {
+	tests := []struct {
+		host, pattern, mask string
+		want                bool
+	}{
+		{"10.1.2.3", "10.0.0.0", "255.0.0.0", true},
+		{"11.1.2.3", "10.0.0.0", "255.0.0.0", false},
+		{"192.168.1.20", "192.168.1.0", "255.255.255.0", true},
+		{"192.168.2.20", "192.168.1.0", "255.255.255.0", false},
+		{"192.168.2.20", "192.168.1.0", "255.255.0.0", true},
+		{"172.16.5.4", "172.16.5.4", "255.255.255.255", true},
+		{"10.1.2.3", "10.0.0.0", "not a mask", false},
+	}
+	for _, tt := range tests {
+		got := pacCall(context.Background(), "isInNet", []string{tt.host, tt.pattern, tt.mask})
+		if got != tt.want {
+			t.Errorf("isInNet(%q, %q, %q) = %v, want %v", tt.host, tt.pattern, tt.mask, got, tt.want)
+		}
+	}
+}
+
+func TestPACProxy(t *testing.T) {
+	tests := []struct {
+		result string
+		want   string
+	}{
+		{"DIRECT", ""},
+		{"", ""},
+		{"PROXY proxy:8080; DIRECT", "http://proxy:8080"},
+		{"HTTP proxy:8080", "http://proxy:8080"},
+		{"HTTPS proxy:443", "https://proxy:443"},
+		{"SOCKS socks:1080", "socks5://socks:1080"},
+		{"socks5 socks:1080", "socks5://socks:1080"},
+	}
+	for _, tt := range tests {
+		u, err := pacProxy(tt.result)
+		if err != nil {
+			t.Errorf("pacProxy(%q): %v", tt.result, err)
+			continue
+		}
+		got := ""
+		if u != nil {
+			got = u.String()
+		}
+		if got != tt.want {
+			t.Errorf("pacProxy(%q) = %q, want %q", tt.result, got, tt.want)
+		}
+	}
+	for _, result := range []string{"PROXY", "FTP host:21", "PROXY a b"} {
+		if _, err := pacProxy(result); err == nil {
+			t.Errorf("pacProxy(%q) succeeded, want an error", result)
+		}
+	}
+}
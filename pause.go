@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// pauseWindow is a span of time during which probing was paused.
+type pauseWindow struct {
+	start, end time.Time
+}
+
+// pauser tracks whether probing is paused, and the pauses so far so they
+// can be left out of the statistics.
+type pauser struct {
+	mu      sync.Mutex
+	paused  bool
+	since   time.Time
+	windows []pauseWindow
+}
+
+// probing is paused and resumed by pauseSignal and resumeSignal.
+var probing pauser
+
+func (p *pauser) pause() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.paused {
+		return false
+	}
+	p.paused, p.since = true, time.Now()
+	return true
+}
+
+func (p *pauser) resume() (pauseWindow, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.paused {
+		return pauseWindow{}, false
+	}
+	w := pauseWindow{start: p.since, end: time.Now()}
+	p.paused = false
+	p.windows = append(p.windows, w)
+	return w, true
+}
+
+func (p *pauser) isPaused() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+// during returns the pauses overlapping start to end, clipped to it. A
+// pause still going on ends at end.
+func (p *pauser) during(start, end time.Time) []pauseWindow {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	windows := p.windows
+	if p.paused {
+		windows = append(windows[:len(windows):len(windows)], pauseWindow{start: p.since, end: end})
+	}
+	var clipped []pauseWindow
+	for _, w := range windows {
+		if w.start.Before(start) {
+			w.start = start
+		}
+		if w.end.After(end) {
+			w.end = end
+		}
+		if w.end.After(w.start) {
+			clipped = append(clipped, w)
+		}
+	}
+	return clipped
+}
+
+// pausedBetween returns how much of start to end was paused.
+func pausedBetween(windows []pauseWindow, start, end time.Time) time.Duration {
+	var d time.Duration
+	for _, w := range windows {
+		from, to := w.start, w.end
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if to.After(from) {
+			d += to.Sub(from)
+		}
+	}
+	return d
+}
+
+// setupPauseHandler pauses probing on pauseSignal and resumes it on
+// resumeSignal, for maintenance windows. Probes in flight when pausing
+// still complete.
+func setupPauseHandler(ctx context.Context) {
+	if pauseSignal == nil {
+		return
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, pauseSignal, resumeSignal)
+	go func() {
+		for {
+			select {
+			case sig := <-c:
+				if sig == pauseSignal {
+					if probing.pause() {
+						log.Println("Probing paused")
+					}
+				} else if w, ok := probing.resume(); ok {
+					log.Printf("Probing resumed, paused since %s for %v",
+						w.start.Format("15:04:05"), formatDuration(w.end.Sub(w.start)))
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPausedBetween(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(s int) time.Time { return t0.Add(time.Duration(s) * time.Second) }
+	windows := []pauseWindow{{at(10), at(20)}, {at(30), at(35)}}
+	tests := []struct {
+		start, end int
+		want       time.Duration
+	}{
+		{0, 10, 0},
+		{0, 60, 15 * time.Second},
+		{15, 32, 7 * time.Second},
+		{12, 18, 6 * time.Second},
+		{20, 30, 0},
+	}
+	for _, tt := range tests {
+		if got := pausedBetween(windows, at(tt.start), at(tt.end)); got != tt.want {
+			t.Errorf("pausedBetween(%ds, %ds) = %v, want %v", tt.start, tt.end, got, tt.want)
+		}
+	}
+
+	// The pause between the probes at 5s and 25s is not a gap.
+	mean, longest := probeGaps(recordsAt(t0, 5*time.Second, 25*time.Second, 30*time.Second), windows[:1])
+	if mean != 7500*time.Millisecond || longest != 10*time.Second {
+		t.Errorf("probeGaps around a pause = %v, %v, want 7.5s, 10s", mean, longest)
+	}
+}
+
+func TestPauser(t *testing.T) {
+	var p pauser
+	if _, ok := p.resume(); ok {
+		t.Error("resumed without a pause")
+	}
+	start := time.Now()
+	if !p.pause() || p.pause() || !p.isPaused() {
+		t.Fatal("pausing twice is not a single pause")
+	}
+	w, ok := p.resume()
+	if !ok || p.isPaused() || w.start.Before(start) || w.end.Before(w.start) {
+		t.Fatalf("resume = %v, %v", w, ok)
+	}
+
+	p.pause()
+	end := time.Now().Add(time.Minute)
+	windows := p.during(start, end)
+	if len(windows) != 2 || windows[0] != w || windows[1].end != end {
+		t.Errorf("during = %v, want the finished pause and the one going on", windows)
+	}
+	if windows := p.during(end, end.Add(time.Minute)); len(windows) != 1 || windows[0].start != end {
+		t.Errorf("during a later run = %v, want the pause going on clipped to it", windows)
+	}
+	if len(p.windows) != 1 {
+		t.Errorf("during added to the pauses: %v", p.windows)
+	}
+}
@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// Windows has no user signals, so probing cannot be paused there.
+var pauseSignal, resumeSignal os.Signal
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Probe modes selected with -mode.
+const (
+	modeHTTP      = "http"
+	modeTCP       = "tcp"
+	modeTLS       = "tls"
+	modeDNS       = "dns"
+	modeGRPC      = "grpc"
+	modeWebSocket = "websocket"
+	modeHLS       = "hls"
+	modeFTP       = "ftp"
+	modeSMTP      = "smtp"
+	modeIMAP      = "imap"
+)
+
+// Prober performs a single probe of its target. The scheduler, sinks and
+// statistics only see the resulting Record, so they are shared by all
+// protocols.
+type Prober interface {
+	Probe(ctx context.Context) Record
+}
+
+// newProber creates the prober for t's mode. Bytes sent and received on
+// its connections are added to the returned counter.
+func newProber(t Target, logger *log.Logger) (Prober, *byteCounter) {
+	switch t.Mode {
+	case modeTCP, modeTLS:
+		counter := &byteCounter{}
+		return &connProber{t: t, logger: logger, dial: newDial(t, counter)}, counter
+	case modeDNS:
+		return &dnsProber{t: t, resolver: newResolver(t)}, &byteCounter{}
+	case modeSMTP, modeIMAP:
+		counter := &byteCounter{}
+		return &bannerProber{t: t, logger: logger, dial: newDial(t, counter)}, counter
+	case modeFTP:
+		counter := &byteCounter{}
+		return &ftpProber{t: t, logger: logger, dial: newDial(t, counter)}, counter
+	}
+
+	client, counter := newClient(t)
+	switch t.Mode {
+	case modeGRPC:
+		return &grpcProber{t: t, logger: logger, client: client}, counter
+	case modeWebSocket:
+		return &webSocketProber{t: t, logger: logger, client: client}, counter
+	case modeHLS:
+		return &hlsProber{t: t, logger: logger, client: client}, counter
+	}
+	return &httpProber{t: t, logger: logger, client: client}, counter
+}
+
+type httpProber struct {
+	t      Target
+	logger *log.Logger
+	client *http.Client
+}
+
+func (p *httpProber) Probe(ctx context.Context) Record {
+	return request(ctx, p.logger, p.client, p.t)
+}
+
+// probeState tracks the phases of a probe while it is in flight.
+type probeState struct {
+	rec   Record
+	phase *phaseTracker
+	// gotConn is when a connection was obtained for an HTTP request.
+	gotConn time.Time
+}
+
+func startProbe() *probeState {
+	return &probeState{rec: Record{StartTime: time.Now()}, phase: newPhaseTracker()}
+}
+
+// fail completes the record of a probe that ended with err.
+func (p *probeState) fail(err error) Record {
+	p.rec.Err = err
+	p.rec.Total = time.Since(p.rec.StartTime)
+	p.phase.record(&p.rec)
+	if classifyError(err) == errTimeout {
+		p.rec.TimeoutPhase = p.phase.get()
+	}
+	return p.rec
+}
+
+// done completes the record of a successful probe. Without an HTTP
+// exchange, the elapsed time is the whole probe.
+func (p *probeState) done(t Target) Record {
+	end := time.Now()
+	p.rec.Responded = true
+	p.rec.Total = end.Sub(p.rec.StartTime)
+	p.rec.ElapsedTime = p.rec.Total
+	if !p.gotConn.IsZero() {
+		p.rec.ElapsedTime = end.Sub(p.gotConn)
+	}
+	p.rec.NearTimeout = t.nearTimeout(p.rec.Total)
+	p.phase.record(&p.rec)
+	return p.rec
+}
+
+// trace returns ctx with httptrace hooks that follow the request phases.
+// The hooks also fire for plain dials through the net package.
+func (p *probeState) trace(ctx context.Context, logger *log.Logger, t Target) context.Context {
+	ctx = context.WithValue(ctx, phaseTrackerKey{}, p.phase)
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart:     func(_ httptrace.DNSStartInfo) { p.phase.set(phaseDNS) },
+		DNSDone:      func(_ httptrace.DNSDoneInfo) { p.phase.set(phaseConnect) },
+		ConnectStart: func(_, _ string) { p.phase.set(phaseConnect) },
+		ConnectDone: func(_, _ string, err error) {
+			// A failed attempt, like one of two address families, does
+			// not reach the proxy.
+			if err == nil {
+				p.phase.dialed(phaseConnect)
+			}
+		},
+		TLSHandshakeStart: func() { p.phase.set(phaseTLS) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			p.phase.set(phaseRequest)
+			p.gotConn = time.Now()
+			p.rec.ConnReused = info.Reused
+		},
+		WroteRequest:         func(_ httptrace.WroteRequestInfo) { p.phase.set(phaseServer) },
+		GotFirstResponseByte: func() { p.phase.set(phaseHeaders) },
+		TLSHandshakeDone: func(cs tls.ConnectionState, err error) {
+			if err == nil {
+				p.phase.dialed(phaseTLS)
+			}
+			if t.HostHeader != "" && len(cs.PeerCertificates) > 0 {
+				logger.Printf("TLS: %s", describeCertName(t, cs))
+			}
+		},
+	})
+}
+
+// newRequest builds a traced request to rawURL carrying t's headers. A Host
+// header goes to req.Host, as net/http ignores it in req.Header.
+func (p *probeState) newRequest(ctx context.Context, logger *log.Logger, t Target, method, rawURL string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(p.trace(ctx, logger, t), method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range t.Headers {
+		name, value, _ := parseHeader(h)
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Add(name, value)
+	}
+	if t.HostHeader != "" {
+		req.Host = t.HostHeader
+	}
+	return req, nil
+}
+
+// response records the status of res and moves on to reading the body.
+func (p *probeState) response(res *http.Response) {
+	p.phase.set(phaseBody)
+	p.rec.Responded = true
+	p.rec.StatusCode, p.rec.Status = res.StatusCode, res.Status
+	p.rec.ConnClose = res.Close
+}
+
+// connProber opens a TCP connection and, in tls mode, completes the TLS
+// handshake.
+type connProber struct {
+	t      Target
+	logger *log.Logger
+	dial   dialFunc
+}
+
+func (p *connProber) Probe(ctx context.Context) Record {
+	s := startProbe()
+	ctx = s.trace(ctx, p.logger, p.t)
+
+	addr := targetAddress(p.t)
+	conn, err := p.dial(ctx, "tcp", addr)
+	if err != nil {
+		return s.fail(err)
+	}
+	defer conn.Close()
+	s.rec.Status = "connected to " + conn.RemoteAddr().String()
+
+	if p.t.Mode == modeTLS {
+		tc, err := s.handshake(ctx, p.t, conn)
+		if err != nil {
+			return s.fail(err)
+		}
+		s.rec.Status = tlsVersionName(tc.ConnectionState().Version)
+	}
+	return s.done(p.t)
+}
+
+// handshake starts TLS on conn, checking the certificate like HTTPS
+// requests to t do.
+func (p *probeState) handshake(ctx context.Context, t Target, conn net.Conn) (*tls.Conn, error) {
+	p.phase.set(phaseTLS)
+	cfg := tlsConfig(t).Clone()
+	if !cfg.InsecureSkipVerify && cfg.ServerName == "" {
+		cfg.ServerName = urlHostname(t.URL)
+	}
+	tc := tls.Client(conn, cfg)
+	return tc, tc.HandshakeContext(ctx)
+}
+
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS 1.0"
+	case tls.VersionTLS11:
+		return "TLS 1.1"
+	case tls.VersionTLS12:
+		return "TLS 1.2"
+	case tls.VersionTLS13:
+		return "TLS 1.3"
+	}
+	return fmt.Sprintf("TLS 0x%04x", v)
+}
+
+// dnsProber resolves the target host name.
+type dnsProber struct {
+	t        Target
+	resolver *retryingResolver
+}
+
+func (p *dnsProber) Probe(ctx context.Context) Record {
+	s := startProbe()
+	s.phase.set(phaseDNS)
+
+	host := urlHostname(p.t.URL)
+	var addrs []net.IPAddr
+	var err error
+	if p.resolver != nil {
+		addrs, err = p.resolver.lookup(ctx, host)
+	} else {
+		addrs, err = net.DefaultResolver.LookupIPAddr(ctx, host)
+	}
+	s.phase.set(phaseBody)
+	if err != nil {
+		return s.fail(err)
+	}
+	s.rec.Status = fmt.Sprintf("%d addresses", len(addrs))
+	return s.done(p.t)
+}
+
+// defaultPorts are the ports assumed for targets given without one, by mode
+// or URL scheme.
+var defaultPorts = map[string]string{
+	modeTLS:  "443",
+	modeSMTP: "25",
+	"smtps":  "465",
+	modeIMAP: "143",
+	"imaps":  "993",
+}
+
+// targetAddress returns the host:port of a connection-level target, which
+// may be given as host:port or as a URL like tls://host:port.
+func targetAddress(t Target) string {
+	addr, port := t.URL, defaultPorts[t.Mode]
+	if i := strings.Index(addr, "://"); i >= 0 {
+		if p, ok := defaultPorts[addr[:i]]; ok {
+			port = p
+		}
+		if u, err := url.Parse(addr); err == nil {
+			addr = u.Host
+		}
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil && port != "" {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), port)
+	}
+	return addr
+}
+
+// verb names what is done to the target in log lines: the HTTP method, or
+// the mode for other protocols.
+func (t Target) verb() string {
+	if t.Mode == modeHTTP {
+		return t.Method
+	}
+	return strings.ToUpper(t.Mode)
+}
+
+// withScheme prefixes URLs without a scheme, like "example.com/health", with
+// https:// or, if plain, http://. WebSocket URLs get wss:// or ws://. Modes
+// probing a host:port are left alone.
+func withScheme(rawURL, mode string, plain bool) string {
+	if rawURL == "" || strings.Contains(rawURL, "://") {
+		return rawURL
+	}
+	switch mode {
+	case modeHTTP, modeGRPC, modeHLS:
+		if plain {
+			return "http://" + rawURL
+		}
+		return "https://" + rawURL
+	case modeWebSocket:
+		if plain {
+			return "ws://" + rawURL
+		}
+		return "wss://" + rawURL
+	}
+	return rawURL
+}
+
+// checkURL checks that rawURL parses and names a host and a valid port.
+func checkURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("invalid URL: %w", err)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("invalid URL: missing host")
+	}
+	if port := u.Port(); port != "" {
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return fmt.Errorf("invalid URL: invalid port %q", port)
+		}
+	}
+	return nil
+}
+
+// validateMode checks that the target URL suits its mode.
+func (t Target) validateMode() error {
+	switch t.Mode {
+	case modeHTTP:
+		if strings.HasPrefix(t.URL, "sftp://") {
+			// SFTP runs over SSH, which the standard library lacks.
+			return fmt.Errorf("sftp:// URLs are not supported")
+		}
+		if !strings.HasPrefix(t.URL, "http://") && !strings.HasPrefix(t.URL, "https://") {
+			return fmt.Errorf("http mode needs an http:// or https:// URL")
+		}
+		return checkURL(t.URL)
+	case modeHLS:
+		if !strings.HasPrefix(t.URL, "http://") && !strings.HasPrefix(t.URL, "https://") {
+			return fmt.Errorf("hls mode needs an http:// or https:// playlist URL")
+		}
+		return checkURL(t.URL)
+	case modeFTP:
+		if !strings.HasPrefix(t.URL, "ftp://") {
+			return fmt.Errorf("ftp mode needs an ftp:// URL")
+		}
+		return checkURL(t.URL)
+	case modeTCP, modeTLS:
+		if _, _, err := net.SplitHostPort(targetAddress(t)); err != nil {
+			return fmt.Errorf("%s mode needs host:port: %w", t.Mode, err)
+		}
+	case modeSMTP, modeIMAP:
+		if _, _, err := net.SplitHostPort(targetAddress(t)); err != nil {
+			return fmt.Errorf("%s mode needs host:port: %w", t.Mode, err)
+		}
+		if t.StartTLS && implicitTLS(t) {
+			return fmt.Errorf("-starttls does not apply to %s:// URLs, which use TLS from the start", t.Mode+"s")
+		}
+	case modeDNS:
+		if urlHostname(t.URL) == "" {
+			return fmt.Errorf("dns mode needs a host name")
+		}
+	case modeGRPC:
+		// The standard library speaks HTTP/2 only over TLS.
+		if !strings.HasPrefix(t.URL, "https://") {
+			return fmt.Errorf("grpc mode needs an https:// URL")
+		}
+		return checkURL(t.URL)
+	case modeWebSocket:
+		switch scheme := strings.SplitN(t.URL, "://", 2)[0]; scheme {
+		case "ws", "wss", "http", "https":
+		default:
+			return fmt.Errorf("websocket mode needs a ws:// or wss:// URL")
+		}
+		return checkURL(t.URL)
+	default:
+		return fmt.Errorf("invalid mode %q", t.Mode)
+	}
+	return nil
+}
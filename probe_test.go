@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNewRequestHost(t *testing.T) {
+	tests := []struct {
+		headers    []string
+		hostHeader string
+		want       string
+	}{
+		{nil, "", "203.0.113.10:8443"},
+		{[]string{"host: www.example.com"}, "", "www.example.com"},
+		{[]string{"Host: www.example.com"}, "cdn.example.com", "cdn.example.com"},
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	for _, tt := range tests {
+		target := Target{URL: "https://203.0.113.10:8443/", Headers: tt.headers, HostHeader: tt.hostHeader}
+		req, err := startProbe().newRequest(context.Background(), logger, target, "GET", target.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Host != tt.want {
+			t.Errorf("headers %q, -host-header %q: host %q, want %q", tt.headers, tt.hostHeader, req.Host, tt.want)
+		}
+		if _, ok := req.Header["Host"]; ok {
+			t.Errorf("headers %q: Host left in the header map", tt.headers)
+		}
+	}
+}
+
+func TestConnProbe(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := ln.Addr().String()
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	defer ln.Close()
+
+	logger := log.New(ioutil.Discard, "", 0)
+	tests := []struct {
+		addr string
+		ok   bool
+	}{
+		{open, true},
+		{closed.Addr().String(), false},
+	}
+	for _, tt := range tests {
+		target := Target{URL: "tcp://" + tt.addr, Mode: modeTCP, Timeout: Duration(5 * time.Second)}
+		prober, _ := newProber(target, logger)
+		rec := prober.Probe(context.Background())
+		if (rec.Err == nil) != tt.ok || rec.Responded != tt.ok {
+			t.Errorf("%s: error %v, responded %v, want ok %v", tt.addr, rec.Err, rec.Responded, tt.ok)
+		}
+		if tt.ok && (rec.Status != "connected to "+tt.addr || rec.Connect <= 0) {
+			t.Errorf("%s: status %q, connect %v", tt.addr, rec.Status, rec.Connect)
+		}
+	}
+}
+
+func TestWithScheme(t *testing.T) {
+	tests := []struct {
+		url   string
+		mode  string
+		plain bool
+		want  string
+	}{
+		{"example.com/health", modeHTTP, false, "https://example.com/health"},
+		{"example.com/health", modeHTTP, true, "http://example.com/health"},
+		{"http://example.com/", modeHTTP, false, "http://example.com/"},
+		{"example.com:50051", modeGRPC, false, "https://example.com:50051"},
+		{"cdn.example.com/live.m3u8", modeHLS, false, "https://cdn.example.com/live.m3u8"},
+		{"example.com/socket", modeWebSocket, false, "wss://example.com/socket"},
+		{"example.com/socket", modeWebSocket, true, "ws://example.com/socket"},
+		{"example.com:443", modeTLS, false, "example.com:443"},
+		{"", modeHTTP, false, ""},
+	}
+	for _, tt := range tests {
+		if got := withScheme(tt.url, tt.mode, tt.plain); got != tt.want {
+			t.Errorf("withScheme(%q, %s, %v) = %q, want %q", tt.url, tt.mode, tt.plain, got, tt.want)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url, err string
+	}{
+		{"https://example.com/", ""},
+		{"https://example.com:8443/health", ""},
+		{"https:///health", "invalid URL: missing host"},
+		{"https://example.com:0/", `invalid URL: invalid port "0"`},
+		{"https://example.com:70000/", `invalid URL: invalid port "70000"`},
+		{"https://exa mple.com/", "invalid URL: invalid character \" \" in host name"},
+	}
+	for _, tt := range tests {
+		err := checkURL(tt.url)
+		if (err == nil) != (tt.err == "") || (err != nil && err.Error() != tt.err) {
+			t.Errorf("checkURL(%q) = %v, want %q", tt.url, err, tt.err)
+		}
+	}
+}
+
+func TestValidateMode(t *testing.T) {
+	tests := []struct {
+		mode, url string
+		ok        bool
+	}{
+		{modeHTTP, "https://example.com/", true},
+		{modeHTTP, "example.com", false},
+		{modeHLS, "http://cdn.example.com/live.m3u8", true},
+		{modeHLS, "ftp://cdn.example.com/live.m3u8", false},
+		{modeTCP, "example.com:22", true},
+		{modeTCP, "example.com", false},
+		{modeTLS, "example.com", true},
+		{modeDNS, "example.com", true},
+		{modeGRPC, "https://example.com:50051", true},
+		{modeGRPC, "http://example.com:50051", false},
+		{modeWebSocket, "wss://example.com/socket", true},
+		{modeWebSocket, "tcp://example.com/socket", false},
+		{"quic", "https://example.com/", false},
+	}
+	for _, tt := range tests {
+		if err := (Target{Mode: tt.mode, URL: tt.url}).validateMode(); (err == nil) != tt.ok {
+			t.Errorf("%s %s: error %v, want ok %v", tt.mode, tt.url, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressWriter emits newline-delimited JSON events for wrapper programs,
+// on the file descriptor given with -progress-fd or a json sink file.
+type progressWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	out io.Writer
+}
+
+type progressEvent struct {
+	Event  string    `json:"event"`
+	Target string    `json:"target"`
+	Time   time.Time `json:"time"`
+
+	// start
+	Meta *runMetadata `json:"meta,omitempty"`
+
+	// probe
+	Attempt      int      `json:"attempt,omitempty"`
+	Status       int      `json:"status,omitempty"`
+	Bytes        int64    `json:"bytes,omitempty"`
+	WireBytes    int64    `json:"wire_bytes,omitempty"`
+	ElapsedMS    float64  `json:"elapsed_ms,omitempty"`
+	DNSMS        float64  `json:"dns_ms,omitempty"`
+	ConnectMS    float64  `json:"connect_ms,omitempty"`
+	TLSMS        float64  `json:"tls_ms,omitempty"`
+	TunnelMS     float64  `json:"tunnel_ms,omitempty"`
+	H2PingMS     float64  `json:"h2_ping_ms,omitempty"`
+	TTFBMS       float64  `json:"ttfb_ms,omitempty"`
+	ManifestMS   float64  `json:"manifest_ms,omitempty"`
+	SegmentMS    float64  `json:"segment_ms,omitempty"`
+	SegmentBytes int64    `json:"segment_bytes,omitempty"`
+	TotalMS      float64  `json:"total_ms,omitempty"`
+	NearLimit    bool     `json:"near_timeout,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	ErrClass     string   `json:"error_class,omitempty"`
+	Phase        string   `json:"timeout_phase,omitempty"`
+	Failures     []string `json:"failures,omitempty"`
+
+	// summary
+	Requests    *int     `json:"requests,omitempty"`
+	Responses   *int     `json:"responses,omitempty"`
+	TimeoutRate *float64 `json:"timeout_pct,omitempty"`
+	NewConns    *int     `json:"new_conns,omitempty"`
+	ConnClose   *int     `json:"conn_close,omitempty"`
+	GoAway      *int     `json:"goaway,omitempty"`
+
+	TLSHandshakes *int     `json:"tls_handshakes,omitempty"`
+	ConnectTimeMS *float64 `json:"connect_total_ms,omitempty"`
+	TLSTimeMS     *float64 `json:"tls_total_ms,omitempty"`
+
+	RequestedRate *float64 `json:"requested_rate,omitempty"`
+	AchievedRate  *float64 `json:"achieved_rate,omitempty"`
+	MeanGapMS     *float64 `json:"mean_gap_ms,omitempty"`
+	MaxGapMS      *float64 `json:"max_gap_ms,omitempty"`
+	BytesSent     *int64   `json:"bytes_sent,omitempty"`
+	BytesReceived *int64   `json:"bytes_received,omitempty"`
+	SizeCorr      *float64 `json:"size_latency_corr,omitempty"`
+	MeanDNSMS     *float64 `json:"mean_dns_ms,omitempty"`
+	MaxDNSMS      *float64 `json:"max_dns_ms,omitempty"`
+
+	Errors            map[string]int `json:"errors,omitempty"`
+	TimeoutPhases     map[string]int `json:"timeout_phases,omitempty"`
+	AssertionFailures *int           `json:"assertion_failures,omitempty"`
+	NearTimeout       *int           `json:"near_timeout_responses,omitempty"`
+}
+
+func newProgressWriter(w io.Writer) *progressWriter {
+	return &progressWriter{enc: json.NewEncoder(w), out: w}
+}
+
+// openProgressFD opens an inherited file descriptor for progress events.
+func openProgressFD(fd int) (*os.File, error) {
+	f := os.NewFile(uintptr(fd), "progress")
+	if f == nil {
+		return nil, fmt.Errorf("invalid progress fd %d", fd)
+	}
+	if _, err := f.Stat(); err != nil {
+		return nil, fmt.Errorf("progress fd %d: %w", fd, err)
+	}
+	return f, nil
+}
+
+func (p *progressWriter) emit(ev progressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// Progress is best effort, a wrapper that stopped reading must not
+	// interrupt probing.
+	_ = p.enc.Encode(ev)
+}
+
+func (p *progressWriter) Start(t Target) error {
+	p.emit(progressEvent{Event: "start", Target: targetName(t), Time: time.Now(), Meta: newRunMetadata(t)})
+	return nil
+}
+
+func (p *progressWriter) Write(t Target, rec Record) error {
+	p.emit(probeEvent(t, rec))
+	return nil
+}
+
+// probeEvent describes a probe as a progress event.
+func probeEvent(t Target, rec Record) progressEvent {
+	ev := progressEvent{
+		Event:        "probe",
+		Target:       targetName(t),
+		Time:         rec.StartTime,
+		Attempt:      rec.Attempt,
+		Status:       rec.StatusCode,
+		Bytes:        rec.BytesRead,
+		WireBytes:    rec.WireBytes,
+		ElapsedMS:    *durationMS(rec.ElapsedTime),
+		DNSMS:        *durationMS(rec.DNS),
+		ConnectMS:    *durationMS(rec.Connect),
+		TLSMS:        *durationMS(rec.TLS),
+		TunnelMS:     *durationMS(rec.Tunnel),
+		H2PingMS:     *durationMS(rec.H2Ping),
+		TTFBMS:       *durationMS(rec.TTFB),
+		ManifestMS:   *durationMS(rec.Manifest),
+		SegmentMS:    *durationMS(rec.Segment),
+		SegmentBytes: rec.SegmentBytes,
+		TotalMS:      *durationMS(rec.Total),
+		NearLimit:    rec.NearTimeout,
+	}
+	ev.Failures = rec.Failures
+	if rec.Err != nil {
+		ev.Error = rec.Err.Error()
+		ev.ErrClass = classifyError(rec.Err)
+		ev.Phase = rec.TimeoutPhase
+	}
+	return ev
+}
+
+func (p *progressWriter) Flush(t Target, s Summary) error {
+	p.emit(progressEvent{
+		Event:       "summary",
+		Target:      targetName(t),
+		Time:        time.Now(),
+		Requests:    &s.Requests,
+		Responses:   &s.Responses,
+		TimeoutRate: &s.TimeoutRate,
+		NewConns:    &s.NewConns,
+		ConnClose:   &s.ConnClose,
+		GoAway:      &s.GoAway,
+
+		TLSHandshakes: &s.TLSHandshakes,
+		ConnectTimeMS: durationMS(s.ConnectTime),
+		TLSTimeMS:     durationMS(s.TLSTime),
+
+		RequestedRate: &s.RequestedRate,
+		AchievedRate:  &s.AchievedRate,
+		MeanGapMS:     durationMS(s.MeanGap),
+		MaxGapMS:      durationMS(s.MaxGap),
+		BytesSent:     &s.BytesSent,
+		BytesReceived: &s.BytesReceived,
+		SizeCorr:      &s.SizeCorrelation,
+		MeanDNSMS:     durationMS(s.MeanDNS),
+		MaxDNSMS:      durationMS(s.MaxDNS),
+
+		Errors:            s.Errors,
+		TimeoutPhases:     s.TimeoutPhases,
+		AssertionFailures: &s.AssertionFailures,
+		NearTimeout:       &s.NearTimeout,
+	})
+	return nil
+}
+
+func (p *progressWriter) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := closeOutput(p.out); err != nil {
+		return fmt.Errorf("json sink: %w", err)
+	}
+	return nil
+}
+
+func durationMS(d time.Duration) *float64 {
+	ms := float64(d) / float64(time.Millisecond)
+	return &ms
+}
+
+func targetName(t Target) string {
+	if t.Name != "" {
+		return t.Name
+	}
+	return t.URL
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestProgressWriter(t *testing.T) {
+	var buf bytes.Buffer
+	p := newProgressWriter(&buf)
+	target := Target{Name: "api", URL: "https://example.com/"}
+	p.Write(target, Record{Attempt: 1, Responded: true, StatusCode: 200, BytesRead: 12, TTFB: 1500 * time.Microsecond, Total: 2 * time.Millisecond})
+	p.Write(target, Record{Attempt: 2, Err: context.DeadlineExceeded, TimeoutPhase: phaseServer, Total: time.Second})
+	p.Flush(target, Summary{Requests: 2, Responses: 1, Errors: map[string]int{errTimeout: 1}})
+
+	tests := []map[string]interface{}{
+		{"event": "probe", "target": "api", "attempt": 1.0, "status": 200.0, "bytes": 12.0, "ttfb_ms": 1.5, "total_ms": 2.0},
+		{"event": "probe", "target": "api", "attempt": 2.0, "error_class": errTimeout, "timeout_phase": phaseServer, "total_ms": 1000.0},
+		{"event": "summary", "target": "api", "requests": 2.0, "responses": 1.0},
+	}
+	sc := bufio.NewScanner(&buf)
+	for i, want := range tests {
+		if !sc.Scan() {
+			t.Fatalf("event %d missing", i)
+		}
+		var got map[string]interface{}
+		if err := json.Unmarshal(sc.Bytes(), &got); err != nil {
+			t.Fatalf("event %d: %v", i, err)
+		}
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("event %d: %s = %v, want %v", i, k, got[k], v)
+			}
+		}
+	}
+	if sc.Scan() {
+		t.Errorf("unexpected event %s", sc.Text())
+	}
+}
+
+func TestTargetName(t *testing.T) {
+	if got := targetName(Target{Name: "api", URL: "https://example.com/"}); got != "api" {
+		t.Errorf("targetName with a name = %q, want api", got)
+	}
+	if got := targetName(Target{URL: "https://example.com/"}); got != "https://example.com/" {
+		t.Errorf("targetName without a name = %q, want the URL", got)
+	}
+}
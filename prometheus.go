@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// promBuckets are the upper bounds, in seconds, of the response time
+// histogram.
+var promBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// prometheusSink serves the probe results on /metrics in the Prometheus
+// text format for as long as hilicurl runs.
+type prometheusSink struct {
+	mu      sync.Mutex
+	order   []string
+	targets map[string]*promTarget
+}
+
+type promTarget struct {
+	requests  int
+	responses map[int]int
+	errors    map[string]int
+	failures  int
+	up        bool
+
+	// Response time histogram, buckets[i] counts responses up to
+	// promBuckets[i].
+	buckets []int
+	count   int
+	sum     float64
+}
+
+func newPrometheusSink(addr string) (*prometheusSink, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("prometheus sink: %w", err)
+	}
+	s := &prometheusSink{targets: make(map[string]*promTarget)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", s.serveMetrics)
+	go func() { _ = http.Serve(ln, mux) }()
+	return s, nil
+}
+
+func (s *prometheusSink) Write(t Target, rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := targetName(t)
+	pt, ok := s.targets[name]
+	if !ok {
+		pt = &promTarget{
+			responses: make(map[int]int),
+			errors:    make(map[string]int),
+			buckets:   make([]int, len(promBuckets)),
+		}
+		s.targets[name] = pt
+		s.order = append(s.order, name)
+	}
+
+	pt.requests++
+	pt.failures += len(rec.Failures)
+	pt.up = probeOK(rec)
+	if rec.Err != nil {
+		pt.errors[classifyError(rec.Err)]++
+	}
+	if rec.StatusCode != 0 {
+		pt.responses[rec.StatusCode]++
+	}
+	if rec.Err == nil {
+		secs := rec.Total.Seconds()
+		for i, le := range promBuckets {
+			if secs <= le {
+				pt.buckets[i]++
+			}
+		}
+		pt.count++
+		pt.sum += secs
+	}
+	return nil
+}
+
+func (s *prometheusSink) Flush(t Target, summary Summary) error {
+	return nil
+}
+
+func (s *prometheusSink) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	metric := func(name, kind, help string, each func(target string, pt *promTarget)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+		for _, target := range s.order {
+			each(promLabel(target), s.targets[target])
+		}
+	}
+
+	metric("hilicurl_requests_total", "counter", "Probes sent.", func(target string, pt *promTarget) {
+		fmt.Fprintf(w, "hilicurl_requests_total{target=%s} %d\n", target, pt.requests)
+	})
+	metric("hilicurl_responses_total", "counter", "Responses received by status code.", func(target string, pt *promTarget) {
+		codes := make([]int, 0, len(pt.responses))
+		for code := range pt.responses {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "hilicurl_responses_total{target=%s,code=\"%d\"} %d\n", target, code, pt.responses[code])
+		}
+	})
+	metric("hilicurl_errors_total", "counter", "Failed probes by error class.", func(target string, pt *promTarget) {
+		classes := make([]string, 0, len(pt.errors))
+		for class := range pt.errors {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			fmt.Fprintf(w, "hilicurl_errors_total{target=%s,class=%s} %d\n", target, promLabel(class), pt.errors[class])
+		}
+	})
+	metric("hilicurl_assertion_failures_total", "counter", "Failed response assertions.", func(target string, pt *promTarget) {
+		fmt.Fprintf(w, "hilicurl_assertion_failures_total{target=%s} %d\n", target, pt.failures)
+	})
+	metric("hilicurl_up", "gauge", "Whether the last probe succeeded.", func(target string, pt *promTarget) {
+		up := 0
+		if pt.up {
+			up = 1
+		}
+		fmt.Fprintf(w, "hilicurl_up{target=%s} %d\n", target, up)
+	})
+	metric("hilicurl_response_seconds", "histogram", "Time from sending the request to reading the whole body.", func(target string, pt *promTarget) {
+		for i, le := range promBuckets {
+			fmt.Fprintf(w, "hilicurl_response_seconds_bucket{target=%s,le=\"%s\"} %d\n",
+				target, strconv.FormatFloat(le, 'g', -1, 64), pt.buckets[i])
+		}
+		fmt.Fprintf(w, "hilicurl_response_seconds_bucket{target=%s,le=\"+Inf\"} %d\n", target, pt.count)
+		fmt.Fprintf(w, "hilicurl_response_seconds_sum{target=%s} %g\n", target, pt.sum)
+		fmt.Fprintf(w, "hilicurl_response_seconds_count{target=%s} %d\n", target, pt.count)
+	})
+}
+
+var promEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabel quotes a label value.
+func promLabel(v string) string {
+	return `"` + promEscaper.Replace(v) + `"`
+}
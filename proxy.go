@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pacFetchTimeout bounds the download of a -pac file.
+const pacFetchTimeout = 30 * time.Second
+
+// proxyFunc returns the function choosing the proxy of every request of t:
+// the -proxy URL, the proxy returned by the -pac file or else the one given
+// by the HTTPS_PROXY, HTTP_PROXY and NO_PROXY environment variables. The
+// -proxy-user credentials are added to the proxy chosen.
+func proxyFunc(t Target) func(*http.Request) (*url.URL, error) {
+	choose := http.ProxyFromEnvironment
+	if t.Proxy != "" {
+		// The URL was checked by validate.
+		u, _ := parseProxyURL(t.Proxy)
+		choose = func(*http.Request) (*url.URL, error) { return u, nil }
+	} else if t.PAC != "" {
+		choose = func(req *http.Request) (*url.URL, error) {
+			script, err := loadPAC(t.PAC)
+			if err != nil {
+				return nil, err
+			}
+			// Like browsers, hide the path and query of https URLs from
+			// the script.
+			u := *req.URL
+			if u.Scheme == "https" {
+				u.Path, u.RawPath, u.RawQuery = "/", "", ""
+			}
+			result, err := script.findProxy(req.Context(), u.String(), u.Hostname())
+			if err != nil {
+				return nil, err
+			}
+			return pacProxy(result)
+		}
+	}
+	if t.ProxyUser == "" {
+		return choose
+	}
+
+	user := proxyUserinfo(t.ProxyUser)
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := choose(req)
+		if u == nil || err != nil {
+			return u, err
+		}
+		withUser := *u
+		withUser.User = user
+		return &withUser, nil
+	}
+}
+
+// parseProxyURL parses a -proxy URL. A bare host:port is an HTTP proxy.
+// With an https:// URL, the connection to the proxy, including the CONNECT
+// request of https targets, is itself encrypted.
+func parseProxyURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q, expected an http://, https:// or socks5:// URL", raw)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q, expected a host", raw)
+	}
+	return u, nil
+}
+
+// proxyUserinfo splits -proxy-user credentials like "user:password".
+func proxyUserinfo(s string) *url.Userinfo {
+	if i := strings.Index(s, ":"); i >= 0 {
+		return url.UserPassword(s[:i], s[i+1:])
+	}
+	return url.User(s)
+}
+
+// pacProxy returns the proxy of the first entry of a FindProxyForURL result
+// like "PROXY proxy:8080; DIRECT", or nil to connect directly. Probes report
+// the path they were given, so later entries are not tried as fallbacks.
+func pacProxy(result string) (*url.URL, error) {
+	entry := strings.Fields(strings.SplitN(result, ";", 2)[0])
+	if len(entry) == 0 {
+		return nil, nil
+	}
+	kind := strings.ToUpper(entry[0])
+	if kind == "DIRECT" {
+		return nil, nil
+	}
+	if len(entry) != 2 {
+		return nil, fmt.Errorf("pac: invalid result %q", result)
+	}
+	switch kind {
+	case "PROXY", "HTTP":
+		return parseProxyURL("http://" + entry[1])
+	case "HTTPS":
+		return parseProxyURL("https://" + entry[1])
+	case "SOCKS", "SOCKS5":
+		return parseProxyURL("socks5://" + entry[1])
+	}
+	return nil, fmt.Errorf("pac: unsupported proxy type %q", entry[0])
+}
+
+var pacScripts = struct {
+	sync.Mutex
+	m map[string]*pacScript
+}{m: make(map[string]*pacScript)}
+
+// loadPAC returns the parsed PAC file at location, a URL or a file path.
+// The file is read once per run; a failed attempt is retried by the next
+// probe.
+func loadPAC(location string) (*pacScript, error) {
+	pacScripts.Lock()
+	defer pacScripts.Unlock()
+	if script, ok := pacScripts.m[location]; ok {
+		return script, nil
+	}
+
+	var src []byte
+	var err error
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		src, err = fetchPAC(location)
+	} else {
+		src, err = ioutil.ReadFile(strings.TrimPrefix(location, "file://"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pac: %w", err)
+	}
+	script, err := parsePAC(string(src))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	pacScripts.m[location] = script
+	return script, nil
+}
+
+// fetchPAC downloads a PAC file, without a proxy since the file is what
+// would choose it.
+func fetchPAC(rawURL string) ([]byte, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	client := &http.Client{Transport: transport, Timeout: pacFetchTimeout}
+	resp, err := client.Get(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", rawURL, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+)
+
+// rawRequest is an HTTP/1.x request read from -request-file, as copied from
+// browser devtools or a packet capture.
+type rawRequest struct {
+	method string
+	uri    *url.URL
+	host   string
+	header http.Header
+	body   string
+}
+
+// readRequestFile parses the request in path. The body is everything after
+// the blank line ending the headers, since captured requests often have
+// bare LF line endings or a trailing newline that Content-Length does not
+// count.
+func readRequestFile(path string) (*rawRequest, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	head, body := b, []byte(nil)
+	if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+		head, body = b[:i+4], b[i+4:]
+	} else if i := bytes.Index(b, []byte("\n\n")); i >= 0 {
+		head, body = b[:i+2], b[i+2:]
+	} else {
+		head = append(bytes.TrimRight(b, "\r\n"), "\r\n\r\n"...)
+	}
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(head)))
+	if err != nil {
+		return nil, fmt.Errorf("request file %s: %w", path, err)
+	}
+
+	if len(req.TransferEncoding) > 0 && req.TransferEncoding[0] == "chunked" {
+		if body, err = ioutil.ReadAll(httputil.NewChunkedReader(bytes.NewReader(body))); err != nil {
+			return nil, fmt.Errorf("request file %s: chunked body: %w", path, err)
+		}
+	} else if req.Header.Get("Content-Length") != "" && int64(len(body)) > req.ContentLength {
+		body = body[:req.ContentLength]
+	}
+	// The body is sent as read, so its framing is up to the client.
+	req.Header.Del("Content-Length")
+	req.Header.Del("Transfer-Encoding")
+
+	return &rawRequest{
+		method: req.Method,
+		uri:    req.URL,
+		host:   req.Host,
+		header: req.Header,
+		body:   string(body),
+	}, nil
+}
+
+// replay sends raw, the request of t.RequestFile. The file is read for
+// every probe, so it can be edited while probing.
+func replay(ctx context.Context, s *probeState, logger *log.Logger, client *http.Client, t Target, raw *rawRequest) Record {
+	rawURL, err := raw.target(t.URL)
+	if err != nil {
+		return s.fail(err)
+	}
+	req, err := s.newRequest(ctx, logger, t, raw.method, rawURL, strings.NewReader(raw.body))
+	if err != nil {
+		return s.fail(err)
+	}
+	raw.apply(req, t)
+	return send(s, client, req, t)
+}
+
+// target returns the URL to send the request to: the scheme, host and port
+// of the target URL with the path and query of the request.
+func (r *rawRequest) target(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path, u.RawPath, u.RawQuery = r.uri.Path, r.uri.RawPath, r.uri.RawQuery
+	return u.String(), nil
+}
+
+// apply copies the headers of the request to req. Headers given with -H
+// and -host-header take precedence. Unless -raw is given, the
+// Accept-Encoding of the file is left out, as browsers ask for encodings
+// like br that would not be decoded; the probe asks for gzip instead.
+func (r *rawRequest) apply(req *http.Request, t Target) {
+	for name, values := range r.header {
+		if name == "Accept-Encoding" && !t.Raw {
+			continue
+		}
+		if _, ok := req.Header[name]; !ok {
+			req.Header[name] = values
+		}
+	}
+	if t.HostHeader == "" && !t.hasHostHeader() && r.host != "" {
+		req.Host = r.host
+	}
+}
+
+// hasHostHeader reports whether a Host header is given with -H.
+func (t Target) hasHostHeader() bool {
+	for _, h := range t.Headers {
+		if name, _, _ := parseHeader(h); http.CanonicalHeaderKey(name) == "Host" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadRequestFile(t *testing.T) {
+	tests := []struct {
+		name, file        string
+		method, uri, host string
+		body              string
+		contentType       string
+	}{
+		{"crlf", "POST /api/v1/items?sort=asc HTTP/1.1\r\nHost: api.example.com\r\nContent-Type: application/json\r\nContent-Length: 9\r\n\r\n{\"a\": 1}\n\r\n",
+			"POST", "/api/v1/items?sort=asc", "api.example.com", "{\"a\": 1}\n", "application/json"},
+		{"lf", "PUT /x HTTP/1.1\nHost: api.example.com\nContent-Type: text/plain\n\nhello\n",
+			"PUT", "/x", "api.example.com", "hello\n", "text/plain"},
+		{"no body", "GET /health HTTP/1.1\nHost: api.example.com\n",
+			"GET", "/health", "api.example.com", "", ""},
+		{"chunked", "POST /upload HTTP/1.1\r\nHost: api.example.com\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n6\r\n world\r\n0\r\n\r\n",
+			"POST", "/upload", "api.example.com", "hello world", ""},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name+".http")
+		if err := ioutil.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		r, err := readRequestFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if r.method != tt.method || r.uri.RequestURI() != tt.uri || r.host != tt.host || r.body != tt.body ||
+			r.header.Get("Content-Type") != tt.contentType {
+			t.Errorf("%s: %s %s host %q type %q body %q", tt.name, r.method, r.uri, r.host, r.header.Get("Content-Type"), r.body)
+		}
+		if _, ok := r.header["Content-Length"]; ok {
+			t.Errorf("%s: Content-Length kept", tt.name)
+		}
+	}
+
+	path := filepath.Join(dir, "bad.http")
+	ioutil.WriteFile(path, []byte("not a request\n"), 0o644)
+	if _, err := readRequestFile(path); err == nil {
+		t.Error("malformed request file read without error")
+	}
+}
+
+func TestRawRequestApply(t *testing.T) {
+	raw := &rawRequest{
+		host: "api.example.com",
+		header: http.Header{
+			"Accept-Encoding": {"gzip, deflate, br"},
+			"Cookie":          {"session=abc"},
+			"X-Trace":         {"from-file"},
+		},
+	}
+	raw.uri, _ = url.Parse("/v1/items?id=7")
+	rawURL, err := raw.target("https://203.0.113.10:8443/ignored?q=1")
+	if err != nil || rawURL != "https://203.0.113.10:8443/v1/items?id=7" {
+		t.Errorf("target = %q, %v", rawURL, err)
+	}
+
+	tests := []struct {
+		target         Target
+		host, encoding string
+		trace          string
+	}{
+		{Target{}, "api.example.com", "", "from-file"},
+		{Target{Raw: true}, "api.example.com", "gzip, deflate, br", "from-file"},
+		{Target{Headers: []string{"X-Trace: from-flag"}}, "api.example.com", "", "from-flag"},
+		{Target{Headers: []string{"host: www.example.com"}}, "www.example.com", "", "from-file"},
+		{Target{HostHeader: "cdn.example.com"}, "cdn.example.com", "", "from-file"},
+	}
+	logger := log.New(ioutil.Discard, "", 0)
+	for _, tt := range tests {
+		tt.target.URL = rawURL
+		req, err := startProbe().newRequest(context.Background(), logger, tt.target, http.MethodGet, rawURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw.apply(req, tt.target)
+		if req.Host != tt.host || req.Header.Get("Accept-Encoding") != tt.encoding ||
+			req.Header.Get("X-Trace") != tt.trace || req.Header.Get("Cookie") != "session=abc" {
+			t.Errorf("%+v: host %q, headers %v", tt.target, req.Host, req.Header)
+		}
+	}
+}
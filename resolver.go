@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http/httptrace"
+	"time"
+)
+
+// retryingResolver looks up host names with a timeout per attempt and a
+// fixed number of attempts, instead of the system resolver's own policy.
+type retryingResolver struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+	attempts int
+}
+
+func (r *retryingResolver) lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	var err error
+	for i := 0; i < r.attempts; i++ {
+		aCtx, cancel := ctx, context.CancelFunc(func() {})
+		if r.timeout > 0 {
+			aCtx, cancel = context.WithTimeout(ctx, r.timeout)
+		}
+		var addrs []net.IPAddr
+		addrs, err = r.resolver.LookupIPAddr(aCtx, host)
+		cancel()
+		if err == nil || ctx.Err() != nil {
+			return addrs, err
+		}
+		if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+			// NXDOMAIN is an answer, not a failure worth retrying.
+			return nil, err
+		}
+	}
+	return nil, err
+}
+
+// dialContext resolves the host with the retrying resolver and dials the
+// addresses in order. It reports the lookup to the request's httptrace
+// hooks just like the standard dialer does.
+func (r *retryingResolver) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, addr)
+		}
+
+		trace := httptrace.ContextClientTrace(ctx)
+		if trace != nil && trace.DNSStart != nil {
+			trace.DNSStart(httptrace.DNSStartInfo{Host: host})
+		}
+		addrs, err := r.lookup(ctx, host)
+		if trace != nil && trace.DNSDone != nil {
+			trace.DNSDone(httptrace.DNSDoneInfo{Addrs: addrs, Err: err})
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var conn net.Conn
+		for _, a := range addrs {
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(a.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
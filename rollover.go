@@ -0,0 +1,158 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rolloverDaily is the -rollover period starting a new file at midnight.
+const rolloverDaily = "daily"
+
+// rollover and rolloverGzip are set by -rollover and -rollover-gzip. They
+// apply to the files of the csv and json sinks.
+var (
+	rollover     string
+	rolloverGzip bool
+)
+
+// rollingFile writes to a file named after the current day, like
+// out-2024-05-01.csv for out.csv, and moves to the next day's file at the
+// first write after midnight. A file of the current day is appended to, so
+// a restart continues it. Closed files are compressed with rolloverGzip.
+type rollingFile struct {
+	path string
+
+	// header is written at the top of every file that starts out empty.
+	header []byte
+
+	mu        sync.Mutex
+	f         *os.File
+	day       string
+	empty     bool
+	lineStart bool
+
+	// compressing counts the files of past days being compressed.
+	compressing sync.WaitGroup
+}
+
+func newRollingFile(path string) (*rollingFile, error) {
+	r := &rollingFile{path: path, lineStart: true}
+	if err := r.open(time.Now()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// datedPath inserts day before the extension of path.
+func datedPath(path, day string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + day + ext
+}
+
+// open opens the file of the day of now. It must be called with r.mu held
+// or before the file is used.
+func (r *rollingFile) open(now time.Time) error {
+	day := now.Format("2006-01-02")
+	f, err := os.OpenFile(datedPath(r.path, day), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("rollover: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("rollover: %w", err)
+	}
+	if r.f != nil {
+		closed := r.f
+		closed.Close()
+		if rolloverGzip {
+			// Compressing a day of probes takes a while, keep writing.
+			r.compressing.Add(1)
+			go func() {
+				defer r.compressing.Done()
+				if err := gzipFile(closed.Name()); err != nil {
+					log.Printf("ERROR: %v", err)
+				}
+			}()
+		}
+	}
+	r.f, r.day, r.empty = f, day, info.Size() == 0
+	return nil
+}
+
+// Write moves to a new file when the day changed, but only between lines
+// so that no line is split across two files.
+func (r *rollingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.lineStart && now.Format("2006-01-02") != r.day {
+		if err := r.open(now); err != nil {
+			return 0, err
+		}
+	}
+	if r.empty && len(r.header) > 0 {
+		if _, err := r.f.Write(r.header); err != nil {
+			return 0, fmt.Errorf("rollover: %w", err)
+		}
+	}
+	r.empty = false
+	n, err := r.f.Write(p)
+	if n > 0 {
+		r.lineStart = p[n-1] == '\n'
+	}
+	return n, err
+}
+
+// Close closes the file of the current day, which is left uncompressed,
+// and waits for the compression of earlier ones.
+func (r *rollingFile) Close() error {
+	r.mu.Lock()
+	err := r.f.Close()
+	r.mu.Unlock()
+	r.compressing.Wait()
+	if err != nil {
+		return fmt.Errorf("rollover: %w", err)
+	}
+	return nil
+}
+
+// gzipFile replaces path with path.gz. The original is removed only once the
+// compressed copy is complete.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("rollover: %w", err)
+	}
+	defer in.Close()
+	tmp, err := os.Create(path + ".gz.tmp")
+	if err != nil {
+		return fmt.Errorf("rollover: %w", err)
+	}
+	zw := gzip.NewWriter(tmp)
+	_, err = io.Copy(zw, in)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path+".gz")
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("rollover: %w", err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("rollover: %w", err)
+	}
+	return nil
+}
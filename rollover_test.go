@@ -0,0 +1,97 @@
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDatedPath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"out.csv", "out-2024-05-01.csv"},
+		{"logs/probes.json", "logs/probes-2024-05-01.json"},
+		{"out", "out-2024-05-01"},
+	}
+	for _, tt := range tests {
+		if got := datedPath(tt.path, "2024-05-01"); got != tt.want {
+			t.Errorf("datedPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRollingFile(t *testing.T) {
+	defer func(gz bool) { rolloverGzip = gz }(rolloverGzip)
+	rolloverGzip = true
+
+	path := filepath.Join(t.TempDir(), "out.csv")
+	yesterday := time.Now().AddDate(0, 0, -1)
+	r := &rollingFile{path: path, header: []byte("time,status\n"), lineStart: true}
+	if err := r.open(yesterday); err != nil {
+		t.Fatal(err)
+	}
+	// A line is not split across the files of two days.
+	r.lineStart = false
+	if _, err := r.Write([]byte("1,200\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Write([]byte("2,200\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	old := datedPath(path, yesterday.Format("2006-01-02"))
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("%s left uncompressed: %v", old, err)
+	}
+	f, err := os.Open(old + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadAll(zr); string(b) != "time,status\n1,200\n" {
+		t.Errorf("yesterday's file %q, want the header and the first line", b)
+	}
+	b, err := ioutil.ReadFile(datedPath(path, time.Now().Format("2006-01-02")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "time,status\n2,200\n" {
+		t.Errorf("today's file %q, want the header and the second line", b)
+	}
+}
+
+func TestRollingFileAppend(t *testing.T) {
+	// A restart continues the file of the day without repeating the header.
+	path := filepath.Join(t.TempDir(), "out.csv")
+	for _, line := range []string{"1,200\n", "2,200\n"} {
+		r, err := newRollingFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.header = []byte("time,status\n")
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b, err := ioutil.ReadFile(datedPath(path, time.Now().Format("2006-01-02")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "time,status\n1,200\n2,200\n" {
+		t.Errorf("file %q, want one header and both lines", b)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"runtime"
+	"syscall"
+	"time"
+)
+
+// resourceUsage returns the peak resident set size in bytes and the CPU
+// time used by the process.
+func resourceUsage() (maxRSS int64, user, system time.Duration) {
+	var ru syscall.Rusage
+	if err := syscall.Getrusage(syscall.RUSAGE_SELF, &ru); err != nil {
+		return 0, 0, 0
+	}
+	maxRSS = int64(ru.Maxrss)
+	if runtime.GOOS != "darwin" {
+		// Linux and the BSDs count kilobytes, macOS bytes.
+		maxRSS *= 1024
+	}
+	return maxRSS, time.Duration(ru.Utime.Nano()), time.Duration(ru.Stime.Nano())
+}
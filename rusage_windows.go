@@ -0,0 +1,8 @@
+package main
+
+import "time"
+
+// resourceUsage is not implemented on Windows.
+func resourceUsage() (maxRSS int64, user, system time.Duration) {
+	return 0, 0, 0
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Target selection strategies for -strategy.
+const (
+	strategyAll        = "all"
+	strategyRandom     = "random"
+	strategyWeighted   = "weighted"
+	strategyRoundRobin = "roundrobin"
+)
+
+// runTargets probes the targets and flushes the statistics of each one to
+// the sinks once the context is cancelled. With the all strategy every
+// target runs on its own schedule; otherwise one target is chosen per
+// interval.
+func runTargets(ctx context.Context, targets []Target, strategy string, interval time.Duration, sinks sinkList) {
+	results := make([]Run, len(targets))
+	if strategy == strategyAll {
+		var wg sync.WaitGroup
+		for i, t := range targets {
+			wg.Add(1)
+			go func(i int, t Target) {
+				defer wg.Done()
+				results[i] = runRequests(ctx, t, sinks)
+			}(i, t)
+		}
+		wg.Wait()
+	} else {
+		results = runShared(ctx, targets, strategy, interval, sinks)
+	}
+
+	for i, t := range targets {
+		sinks.flush(t, summarize(results[i]))
+	}
+}
+
+// runRequests probes t every t.Interval until the context is cancelled.
+func runRequests(ctx context.Context, t Target, sinks sinkList) Run {
+	r := newRunner(t, sinks)
+
+	// A ticker keeps the requested cadence regardless of how long it takes
+	// to launch each probe.
+	ticker := time.NewTicker(time.Duration(t.Interval))
+	defer ticker.Stop()
+
+	r.launch(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return r.finish()
+		case <-ticker.C:
+			if r.ready() {
+				r.launch(ctx)
+			}
+		}
+	}
+}
+
+// runShared sends one probe per interval to a target chosen by the
+// strategy. The interval of each target is set to its average share of the
+// probes so that the statistics report the requested rate per target.
+func runShared(ctx context.Context, targets []Target, strategy string, interval time.Duration, sinks sinkList) []Run {
+	pick, shares := newPicker(strategy, targets)
+	runners := make([]*runner, len(targets))
+	for i, t := range targets {
+		if shares[i] > 0 {
+			t.Interval = Duration(float64(interval) / shares[i])
+		}
+		runners[i] = newRunner(t, sinks)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Probes of a target that is backing off are skipped rather than sent
+	// to another target, to keep the spread between targets.
+	next := func() {
+		if r := runners[pick()]; r.ready() {
+			r.launch(ctx)
+		}
+	}
+	next()
+	for {
+		select {
+		case <-ctx.Done():
+			results := make([]Run, len(runners))
+			for i, r := range runners {
+				results[i] = r.finish()
+			}
+			return results
+		case <-ticker.C:
+			next()
+		}
+	}
+}
+
+// newPicker returns a function choosing the index of the next target, and
+// the expected share of probes of each target. It is not safe for
+// concurrent use.
+func newPicker(strategy string, targets []Target) (func() int, []float64) {
+	n := len(targets)
+	shares := make([]float64, n)
+	for i := range shares {
+		shares[i] = 1 / float64(n)
+	}
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+
+	switch strategy {
+	case strategyRandom:
+		return func() int { return rng.Intn(n) }, shares
+	case strategyWeighted:
+		total := 0.0
+		for _, t := range targets {
+			total += t.weight()
+		}
+		for i, t := range targets {
+			shares[i] = t.weight() / total
+		}
+		return func() int {
+			x := rng.Float64() * total
+			for i, t := range targets {
+				if x < t.weight() {
+					return i
+				}
+				x -= t.weight()
+			}
+			return n - 1
+		}, shares
+	}
+
+	i := -1
+	return func() int {
+		i = (i + 1) % n
+		return i
+	}, shares
+}
+
+func validateStrategy(strategy string, targets []Target) error {
+	switch strategy {
+	case strategyAll, strategyRandom, strategyRoundRobin:
+	case strategyWeighted:
+		total := 0.0
+		for _, t := range targets {
+			total += t.weight()
+		}
+		if total <= 0 {
+			return fmt.Errorf("weighted strategy needs a target with a positive weight")
+		}
+	default:
+		return fmt.Errorf("invalid strategy %q", strategy)
+	}
+	return nil
+}
+
+// runner probes one target and collects its results.
+type runner struct {
+	t       Target
+	logger  *log.Logger
+	prober  Prober
+	counter *byteCounter
+	sinks   sinkList
+
+	// pinger is set with -h2-ping.
+	pinger *h2Pinger
+
+	// inflight counts the probes running in the background.
+	inflight sync.WaitGroup
+
+	mu       sync.Mutex
+	run      Run
+	attempts int
+
+	// With -dns-backoff, probing pauses after NXDOMAIN or SERVFAIL answers,
+	// doubling the pause for every consecutive failure.
+	dnsFailures int
+	notBefore   time.Time
+
+	// With -auto-backoff, the interval doubles for every failed probe.
+	failures     int
+	failingSince time.Time
+	backoff      time.Duration
+
+	lastSize int64
+	haveSize bool
+	lastETag string
+}
+
+func newRunner(t Target, sinks sinkList) *runner {
+	logger := targetLogger(t)
+	sinks.start(t)
+	prober, counter := newProber(t, logger)
+	r := &runner{
+		t:       t,
+		logger:  logger,
+		prober:  prober,
+		counter: counter,
+		sinks:   sinks,
+		run:     Run{Target: t, Start: time.Now(), Records: make([]Record, 0, 10)},
+	}
+	if t.H2Ping {
+		r.pinger = newH2Pinger(t, logger)
+	}
+	return r
+}
+
+// ready reports whether the target may be probed now.
+func (r *runner) ready() bool {
+	if probing.isPaused() {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !time.Now().Before(r.notBefore)
+}
+
+// launch starts a probe in the background.
+func (r *runner) launch(ctx context.Context) {
+	r.inflight.Add(1)
+	go func() {
+		defer r.inflight.Done()
+		r.probe(ctx)
+	}()
+}
+
+func (r *runner) probe(ctx context.Context) {
+	t := r.t
+	r.mu.Lock()
+	r.attempts++
+	attempt := r.attempts
+	r.mu.Unlock()
+
+	tCtx, cancel := context.WithTimeout(ctx, time.Duration(t.Timeout))
+	defer cancel()
+	res := r.prober.Probe(tCtx)
+	if ctx.Err() != nil {
+		// The run ended while the probe was in flight and cut it short.
+		return
+	}
+	res.Attempt = attempt
+	if r.pinger != nil {
+		res.H2Ping = r.pinger.take()
+		// Ping while the connections of the probes are idle.
+		go r.pinger.pingAfter(ctx, time.Duration(t.Interval)/2)
+	}
+
+	r.mu.Lock()
+	if res.Err == nil && t.ExpectSizeChange > 0 {
+		if change, ok := sizeChange(r.lastSize, res.BytesRead, t.ExpectSizeChange); r.haveSize && ok {
+			msg := fmt.Sprintf("size changed %+.1f%% from %d to %d bytes", change, r.lastSize, res.BytesRead)
+			res.Failures = append(res.Failures, msg)
+		}
+		r.lastSize, r.haveSize = res.BytesRead, true
+	}
+	if res.Err == nil && t.VerifyNoCache {
+		if res.ETag != "" && res.ETag == r.lastETag {
+			res.Failures = append(res.Failures, fmt.Sprintf("same ETag %s as the previous response", res.ETag))
+		}
+		r.lastETag = res.ETag
+	}
+	r.run.Records = append(r.run.Records, res)
+	if res.Err != nil && isDNSFailure(classifyError(res.Err)) && t.DNSBackoff > 0 {
+		r.dnsFailures++
+		backoff := time.Duration(t.DNSBackoff) << uint(minInt(r.dnsFailures-1, maxDNSBackoffShift))
+		r.notBefore = time.Now().Add(backoff)
+		r.logger.Printf("DNS failure, backing off for %v", backoff)
+	} else if res.Responded {
+		r.dnsFailures = 0
+	}
+	if t.AutoBackoff > 0 {
+		r.autoBackoff(res)
+	}
+	r.mu.Unlock()
+	r.sinks.write(t, res)
+}
+
+// autoBackoff stretches the interval while the target fails, up to
+// t.AutoBackoff, and restores it once a probe succeeds. It must be called
+// with r.mu held.
+func (r *runner) autoBackoff(res Record) {
+	interval := time.Duration(r.t.Interval)
+	if probeOK(res) {
+		if r.failures > 0 {
+			r.logger.Printf("Recovered after %v and %d failed probes, back to a %v interval",
+				formatDuration(time.Since(r.failingSince)), r.failures, formatDuration(interval))
+			r.failures, r.backoff = 0, 0
+		}
+		return
+	}
+	if r.failures == 0 {
+		r.failingSince = res.StartTime
+	}
+	r.failures++
+
+	backoff := interval
+	for i := 0; i < r.failures && backoff < time.Duration(r.t.AutoBackoff); i++ {
+		backoff *= 2
+	}
+	if backoff > time.Duration(r.t.AutoBackoff) {
+		backoff = time.Duration(r.t.AutoBackoff)
+	}
+	if backoff <= interval {
+		return
+	}
+	// The ticker keeps firing every interval; skip the ticks before the
+	// stretched one, with half an interval of slack for jitter.
+	if until := res.StartTime.Add(backoff - interval/2); until.After(r.notBefore) {
+		r.notBefore = until
+	}
+	if backoff != r.backoff {
+		r.backoff = backoff
+		r.logger.Printf("Target failing, backing off to a %v interval", formatDuration(backoff))
+	}
+}
+
+// finish ends the run once the probes in flight have returned. Those cut
+// short by the end of the run are not included.
+func (r *runner) finish() Run {
+	end := time.Now()
+	r.inflight.Wait()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.run.End = end
+	r.run.Pauses = probing.during(r.run.Start, r.run.End)
+	r.run.BytesSent, r.run.BytesReceived = r.counter.totals()
+	return r.run
+}
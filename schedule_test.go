@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func weights(w ...float64) []Target {
+	targets := make([]Target, len(w))
+	for i := range w {
+		targets[i].Weight = &w[i]
+	}
+	return targets
+}
+
+func TestNewPicker(t *testing.T) {
+	pick, shares := newPicker(strategyRoundRobin, make([]Target, 3))
+	for i, want := range []int{0, 1, 2, 0, 1} {
+		if got := pick(); got != want {
+			t.Errorf("round robin pick %d = %d, want %d", i, got, want)
+		}
+	}
+	if shares[0] != 1.0/3 || shares[2] != 1.0/3 {
+		t.Errorf("round robin shares %v", shares)
+	}
+
+	tests := []struct {
+		strategy string
+		targets  []Target
+		shares   []float64
+	}{
+		{strategyRandom, make([]Target, 4), []float64{0.25, 0.25, 0.25, 0.25}},
+		{strategyWeighted, weights(3, 1), []float64{0.75, 0.25}},
+		{strategyWeighted, weights(1, 0, 1), []float64{0.5, 0, 0.5}},
+		{strategyWeighted, []Target{{}, {}}, []float64{0.5, 0.5}},
+	}
+	const n = 20000
+	for _, tt := range tests {
+		pick, shares := newPicker(tt.strategy, tt.targets)
+		counts := make([]int, len(tt.targets))
+		for i := 0; i < n; i++ {
+			counts[pick()]++
+		}
+		for i, want := range tt.shares {
+			if shares[i] != want {
+				t.Errorf("%s %v: share %d = %v, want %v", tt.strategy, tt.shares, i, shares[i], want)
+			}
+			if got := float64(counts[i]) / n; math.Abs(got-want) > 0.02 {
+				t.Errorf("%s %v: target %d picked %.3f of the time", tt.strategy, tt.shares, i, got)
+			}
+		}
+	}
+}
+
+func TestValidateStrategy(t *testing.T) {
+	tests := []struct {
+		strategy string
+		targets  []Target
+		ok       bool
+	}{
+		{strategyAll, nil, true},
+		{strategyRoundRobin, make([]Target, 2), true},
+		{strategyWeighted, weights(0, 2), true},
+		{strategyWeighted, weights(0, 0), false},
+		{"fastest", make([]Target, 2), false},
+	}
+	for _, tt := range tests {
+		if err := validateStrategy(tt.strategy, tt.targets); (err == nil) != tt.ok {
+			t.Errorf("validateStrategy(%q) error %v, want ok %v", tt.strategy, err, tt.ok)
+		}
+	}
+}
+
+// recordingSink keeps the records written to it.
+type recordingSink struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func (s *recordingSink) Write(t Target, rec Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, rec)
+	return nil
+}
+
+func (s *recordingSink) Flush(t Target, summary Summary) error {
+	return nil
+}
+
+func TestRunRequestsCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			<-r.Context().Done()
+		}
+	}))
+	defer srv.Close()
+
+	// A probe still waiting for the server when the run ends is dropped.
+	sink := &recordingSink{}
+	target := Target{URL: srv.URL + "/slow", Mode: modeHTTP, Method: http.MethodGet,
+		Interval: Duration(time.Hour), Timeout: Duration(time.Minute)}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	run := runRequests(ctx, target, sinkList{sink})
+	if len(run.Records) != 0 || len(sink.records) != 0 {
+		t.Errorf("run has %d records and the sink %d, want none from the cancelled probe", len(run.Records), len(sink.records))
+	}
+
+	// One answered before the end is kept.
+	target.URL = srv.URL + "/fast"
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	run = runRequests(ctx, target, sinkList{sink})
+	if len(run.Records) != 1 || len(sink.records) != 1 || run.Records[0].StatusCode != 200 {
+		t.Errorf("run has %d records and the sink %d, want the one probe", len(run.Records), len(sink.records))
+	}
+}
+
+func TestAutoBackoff(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	s := time.Second
+	failed := Record{Responded: true, StatusCode: 503}
+	ok := Record{Responded: true, StatusCode: 200}
+	r := &runner{t: Target{Interval: Duration(s), AutoBackoff: Duration(8 * s)}, logger: log.New(ioutil.Discard, "", 0)}
+
+	tests := []struct {
+		rec       Record
+		at        time.Duration
+		backoff   time.Duration
+		notBefore time.Duration
+	}{
+		{failed, 0, 2 * s, 1500 * time.Millisecond},
+		{failed, 2 * s, 4 * s, 5500 * time.Millisecond},
+		{failed, 6 * s, 8 * s, 13500 * time.Millisecond},
+		{failed, 14 * s, 8 * s, 21500 * time.Millisecond},
+		// Recovery restores the interval from the next probe on.
+		{ok, 22 * s, 0, 21500 * time.Millisecond},
+		{failed, 23 * s, 2 * s, 24500 * time.Millisecond},
+	}
+	for i, tt := range tests {
+		tt.rec.StartTime = t0.Add(tt.at)
+		r.autoBackoff(tt.rec)
+		if r.backoff != tt.backoff || !r.notBefore.Equal(t0.Add(tt.notBefore)) {
+			t.Errorf("%d: backoff %v until %v, want %v until %v", i, r.backoff, r.notBefore.Sub(t0), tt.backoff, tt.notBefore)
+		}
+	}
+	if !r.failingSince.Equal(t0.Add(23*s)) || r.failures != 1 {
+		t.Errorf("failing since %v with %d failures, want since the last recovery", r.failingSince.Sub(t0), r.failures)
+	}
+
+	// A limit below twice the interval leaves it alone.
+	r = &runner{t: Target{Interval: Duration(s), AutoBackoff: Duration(s)}, logger: log.New(ioutil.Discard, "", 0)}
+	failed.StartTime = t0
+	r.autoBackoff(failed)
+	if r.backoff != 0 || !r.notBefore.IsZero() {
+		t.Errorf("backoff %v until %v with a limit of one interval", r.backoff, r.notBefore)
+	}
+}
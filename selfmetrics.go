@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// selfMonitor samples the resource use of hilicurl itself, so latency
+// spikes can be told apart from stalls of the monitoring process.
+type selfMonitor struct {
+	mu             sync.Mutex
+	peakGoroutines int
+	maxPause       time.Duration
+}
+
+// selfMetricsInterval is how often goroutines and GC pauses are sampled.
+// The runtime keeps the last 256 pauses, many more than collected in a
+// second.
+const selfMetricsInterval = time.Second
+
+func startSelfMonitor(ctx context.Context) *selfMonitor {
+	m := &selfMonitor{}
+	m.sample()
+	go func() {
+		ticker := time.NewTicker(selfMetricsInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				m.sample()
+			}
+		}
+	}()
+	return m
+}
+
+func (m *selfMonitor) sample() {
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	n := runtime.NumGoroutine()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n > m.peakGoroutines {
+		m.peakGoroutines = n
+	}
+	for _, p := range gc.Pause {
+		if p > m.maxPause {
+			m.maxPause = p
+		}
+	}
+}
+
+// print writes the self-metrics section of the statistics.
+func (m *selfMonitor) print() {
+	m.sample()
+	var gc debug.GCStats
+	debug.ReadGCStats(&gc)
+	maxRSS, user, system := resourceUsage()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Println("--- hilicurl process ---")
+	if maxRSS > 0 {
+		fmt.Printf("max RSS %s, CPU %v user %v system\n", formatBytes(maxRSS), formatDuration(user), formatDuration(system))
+	}
+	fmt.Printf("%d GC runs, pauses total %v max %v, peak %d goroutines\n", gc.NumGC,
+		formatDuration(gc.PauseTotal), formatDuration(m.maxPause), m.peakGoroutines)
+}
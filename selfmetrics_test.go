@@ -0,0 +1,36 @@
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestSelfMonitorSample(t *testing.T) {
+	m := &selfMonitor{}
+	stop := make(chan struct{})
+	for i := 0; i < 10; i++ {
+		go func() { <-stop }()
+	}
+	runtime.GC()
+	m.sample()
+	close(stop)
+	m.sample()
+
+	// The blocked goroutines and the test itself.
+	if m.peakGoroutines < 11 {
+		t.Errorf("peak goroutines %d, want at least 11", m.peakGoroutines)
+	}
+	if m.maxPause <= 0 {
+		t.Errorf("max GC pause %v after a collection", m.maxPause)
+	}
+}
+
+func TestResourceUsage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("resource usage is not implemented on Windows")
+	}
+	maxRSS, user, system := resourceUsage()
+	if maxRSS < 1<<20 || user+system <= 0 {
+		t.Errorf("max RSS %d bytes, CPU %v user %v system", maxRSS, user, system)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// selftestServer answers probes according to the profile given in the
+// query string of each request, so flags, assertions and sinks can be tried
+// without a real endpoint:
+//
+//	latency=50ms     delay every response
+//	jitter=20ms      add a random delay up to this long
+//	error_rate=10    answer this percentage of requests with an error
+//	status=503       the error status, 500 by default
+//	size=4096        pad the body to this many bytes
+//	format=html      answer with json (default), html or xml
+type selftestServer struct {
+	mu       sync.Mutex
+	rng      *rand.Rand
+	requests int
+}
+
+// startSelftestServer serves the self-test endpoint on a free local port
+// and returns its base URL.
+func startSelftestServer() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("selftest: %w", err)
+	}
+	s := &selftestServer{rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	go func() { _ = http.Serve(l, s) }()
+	return "http://" + l.Addr().String(), nil
+}
+
+// selftestURLs resolves the paths given to the selftest command against
+// the server, probing its root without any.
+func selftestURLs(base string, paths []string) ([]string, error) {
+	if len(paths) == 0 {
+		return []string{base + "/"}, nil
+	}
+	urls := make([]string, len(paths))
+	for i, p := range paths {
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("selftest: expected a path like /?latency=50ms, got %q", p)
+		}
+		urls[i] = base + p
+	}
+	return urls, nil
+}
+
+func (s *selftestServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	duration := func(name string) time.Duration {
+		d, _ := time.ParseDuration(q.Get(name))
+		return d
+	}
+	number := func(name string, def int) int {
+		if n, err := strconv.Atoi(q.Get(name)); err == nil {
+			return n
+		}
+		return def
+	}
+
+	s.mu.Lock()
+	s.requests++
+	n := s.requests
+	delay := duration("latency")
+	if jitter := duration("jitter"); jitter > 0 {
+		delay += time.Duration(s.rng.Int63n(int64(jitter)))
+	}
+	rate, _ := strconv.ParseFloat(q.Get("error_rate"), 64)
+	failed := s.rng.Float64()*100 < rate
+	s.mu.Unlock()
+
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+
+	status, state := http.StatusOK, "ok"
+	if failed {
+		status, state = number("status", http.StatusInternalServerError), "error"
+	}
+	render := func(pad string) string {
+		switch q.Get("format") {
+		case "html":
+			return fmt.Sprintf("<!DOCTYPE html>\n<html><head><title>hilicurl selftest</title></head>\n"+
+				"<body><div id=\"main\"><h1 class=\"status\">%s</h1><p class=\"request\">%d</p>%s</div></body></html>\n",
+				state, n, pad)
+		case "xml":
+			return fmt.Sprintf("<?xml version=\"1.0\"?>\n<selftest status=\"%s\"><request>%d</request>%s</selftest>\n",
+				state, n, pad)
+		}
+		b, _ := json.Marshal(struct {
+			Status  string `json:"status"`
+			Request int    `json:"request"`
+			Padding string `json:"padding,omitempty"`
+		}{state, n, pad})
+		return string(b) + "\n"
+	}
+	body := render("")
+	if size := number("size", 0); size > len(body) {
+		// The JSON padding field has some overhead of its own.
+		overhead := len(render("x")) - len(body) - 1
+		if pad := size - len(body) - overhead; pad > 0 {
+			body = render(strings.Repeat("x", pad))
+		}
+	}
+
+	switch q.Get("format") {
+	case "html":
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	case "xml":
+		w.Header().Set("Content-Type", "application/xml")
+	default:
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelftestURLs(t *testing.T) {
+	base := "http://127.0.0.1:8080"
+	urls, err := selftestURLs(base, nil)
+	if err != nil || !equalStrings(urls, []string{base + "/"}) {
+		t.Errorf("selftestURLs without paths = %q, %v", urls, err)
+	}
+	urls, err = selftestURLs(base, []string{"/?latency=50ms", "/slow?jitter=1s"})
+	if err != nil || !equalStrings(urls, []string{base + "/?latency=50ms", base + "/slow?jitter=1s"}) {
+		t.Errorf("selftestURLs = %q, %v", urls, err)
+	}
+	if _, err := selftestURLs(base, []string{"latency=50ms"}); err == nil {
+		t.Error("path without a slash accepted")
+	}
+}
+
+func TestSelftestServer(t *testing.T) {
+	tests := []struct {
+		query       string
+		status      int
+		contentType string
+		size        int
+		body        string
+	}{
+		{"", 200, "application/json", 0, `{"status":"ok","request":1}` + "\n"},
+		{"error_rate=100", 500, "application/json", 0, `"status":"error"`},
+		{"error_rate=100&status=503", 503, "application/json", 0, `"status":"error"`},
+		{"error_rate=0&status=503", 200, "application/json", 0, `"status":"ok"`},
+		{"size=4096", 200, "application/json", 4096, `"padding":"xxx`},
+		{"format=html&size=1000", 200, "text/html; charset=utf-8", 1000, `<h1 class="status">ok</h1>`},
+		{"format=xml&size=500", 200, "application/xml", 500, `<selftest status="ok">`},
+		{"latency=20ms&jitter=10ms", 200, "application/json", 0, `"status":"ok"`},
+	}
+	for _, tt := range tests {
+		s := &selftestServer{rng: rand.New(rand.NewSource(1))}
+		w := httptest.NewRecorder()
+		start := time.Now()
+		s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/?"+tt.query, nil))
+		elapsed := time.Since(start)
+
+		body := w.Body.String()
+		if w.Code != tt.status || w.Header().Get("Content-Type") != tt.contentType || !strings.Contains(body, tt.body) {
+			t.Errorf("%s: %d %s %q", tt.query, w.Code, w.Header().Get("Content-Type"), body)
+		}
+		if tt.size > 0 && len(body) != tt.size {
+			t.Errorf("%s: %d bytes, want %d", tt.query, len(body), tt.size)
+		}
+		if strings.Contains(tt.query, "latency") && (elapsed < 20*time.Millisecond || elapsed > time.Second) {
+			t.Errorf("%s: answered after %v", tt.query, elapsed)
+		}
+	}
+}
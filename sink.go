@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sink receives the result of every probe and, once probing stops, the
+// summary of each target. Write is called concurrently from the probe
+// goroutines of all targets.
+type Sink interface {
+	Write(t Target, rec Record) error
+	Flush(t Target, s Summary) error
+}
+
+// starter is implemented by sinks that announce a target before its first
+// probe.
+type starter interface {
+	Start(t Target) error
+}
+
+// closer is implemented by sinks holding resources, like files or queued
+// deliveries, to release once every target is flushed.
+type closer interface {
+	Close() error
+}
+
+// Sink kinds accepted by -sink.
+const (
+	sinkConsole    = "console"
+	sinkCSV        = "csv"
+	sinkJSON       = "json"
+	sinkPrometheus = "prometheus"
+	sinkStatsd     = "statsd"
+	sinkBinlog     = "binlog"
+)
+
+// newSink creates the sink described by a -sink argument of the form
+// kind or kind=destination.
+func newSink(spec string) (Sink, error) {
+	kind, dest := spec, ""
+	if i := strings.Index(spec, "="); i >= 0 {
+		kind, dest = spec[:i], spec[i+1:]
+	}
+
+	if kind == sinkConsole {
+		if dest != "" {
+			return nil, fmt.Errorf("sink %q takes no destination", kind)
+		}
+		return consoleSink{}, nil
+	}
+	if dest == "" {
+		return nil, fmt.Errorf("sink %q needs a destination, e.g. %s=out.%s", kind, kind, kind)
+	}
+
+	switch kind {
+	case sinkCSV:
+		w, err := createOutput(dest)
+		if err != nil {
+			return nil, err
+		}
+		return newCSVSink(w)
+	case sinkJSON:
+		w, err := createOutput(dest)
+		if err != nil {
+			return nil, err
+		}
+		return newProgressWriter(w), nil
+	case sinkPrometheus:
+		return newPrometheusSink(dest)
+	case sinkStatsd:
+		return newStatsdSink(dest)
+	case sinkBinlog:
+		return newBinlogSink(dest)
+	}
+	return nil, fmt.Errorf("unknown sink %q", kind)
+}
+
+// createOutput opens a sink destination file, where "-" is standard output.
+// With -rollover, the file is replaced by one per day.
+func createOutput(path string) (io.Writer, error) {
+	if path == "-" {
+		return os.Stdout, nil
+	}
+	if rollover == rolloverDaily {
+		return newRollingFile(path)
+	}
+	return os.Create(path)
+}
+
+// closeOutput closes a destination opened by createOutput, leaving standard
+// output open.
+func closeOutput(w io.Writer) error {
+	if c, ok := w.(io.Closer); ok && w != io.Writer(os.Stdout) {
+		return c.Close()
+	}
+	return nil
+}
+
+// sinkList fans probe results out to several sinks. Errors are logged
+// rather than returned so that one failing sink does not stop the others.
+type sinkList []Sink
+
+func (l sinkList) start(t Target) {
+	for _, s := range l {
+		if st, ok := s.(starter); ok {
+			if err := st.Start(t); err != nil {
+				targetLogger(t).Printf("ERROR: %v", err)
+			}
+		}
+	}
+}
+
+func (l sinkList) write(t Target, rec Record) {
+	for _, s := range l {
+		if err := s.Write(t, rec); err != nil {
+			targetLogger(t).Printf("ERROR: %v", err)
+		}
+	}
+}
+
+func (l sinkList) flush(t Target, summary Summary) {
+	for _, s := range l {
+		if err := s.Flush(t, summary); err != nil {
+			log.Printf("ERROR: %v", err)
+		}
+	}
+}
+
+func (l sinkList) close() {
+	for _, s := range l {
+		if c, ok := s.(closer); ok {
+			if err := c.Close(); err != nil {
+				log.Printf("ERROR: %v", err)
+			}
+		}
+	}
+}
+
+// consoleSink logs a line per probe and prints the statistics of each
+// target at the end, like ping.
+type consoleSink struct{}
+
+func (consoleSink) Start(t Target) error {
+	targetLogger(t).Printf("%s %s\n", t.verb(), t.URL)
+	return nil
+}
+
+func (consoleSink) Write(t Target, rec Record) error {
+	logger := targetLogger(t)
+	if rec.Err != nil {
+		logger.Printf("ERROR (%s): %v", errorLabel(rec), rec.Err)
+		return nil
+	}
+	logger.Printf("%s: length=%s%s %s%s%s%s%s%s%s\n", rec.Status, formatBytes(rec.BytesRead), sizeNote(rec), timingNote(rec),
+		timeoutNote(t, rec), dnsNote(rec), tunnelNote(rec), h2PingNote(rec), hlsNote(rec), connNote(t, rec))
+	for _, msg := range rec.Failures {
+		logger.Printf("ASSERTION FAILED: %s", msg)
+	}
+	return nil
+}
+
+// timingNote shows the time to the first response byte apart from the
+// total, so that server latency and transfer time can be told apart.
+// Protocols without a response have only the total.
+func timingNote(rec Record) string {
+	if rec.TTFB == 0 {
+		return "total=" + formatDuration(rec.Total)
+	}
+	return fmt.Sprintf("ttfb=%s total=%s", formatDuration(rec.TTFB), formatDuration(rec.Total))
+}
+
+func (consoleSink) Flush(t Target, s Summary) error {
+	fmt.Printf("--- %s %s statistics ---\n", t.verb(), t.URL)
+	printStatistics(s)
+	return nil
+}
+
+var csvHeader = []string{
+	"target", "time", "attempt", "status", "bytes",
+	"dns_ms", "connect_ms", "tls_ms", "ttfb_ms", "total_ms",
+	"error_class", "error", "failures",
+}
+
+// csvSink writes a row per probe. Rows are flushed as they are written so
+// the file can be followed while probing.
+type csvSink struct {
+	mu  sync.Mutex
+	w   *csv.Writer
+	out io.Writer
+}
+
+func newCSVSink(w io.Writer) (*csvSink, error) {
+	s := &csvSink{w: csv.NewWriter(w), out: w}
+	if r, ok := w.(*rollingFile); ok {
+		// Every day's file starts with the header.
+		var header bytes.Buffer
+		hw := csv.NewWriter(&header)
+		_ = hw.Write(csvHeader)
+		hw.Flush()
+		r.header = header.Bytes()
+		return s, nil
+	}
+	if err := s.writeRow(csvHeader); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *csvSink) writeRow(row []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_ = s.w.Write(row)
+	s.w.Flush()
+	if err := s.w.Error(); err != nil {
+		return fmt.Errorf("csv sink: %w", err)
+	}
+	return nil
+}
+
+func (s *csvSink) Write(t Target, rec Record) error {
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(*durationMS(d), 'f', 3, 64)
+	}
+	var class, msg string
+	if rec.Err != nil {
+		class, msg = classifyError(rec.Err), rec.Err.Error()
+	}
+	return s.writeRow([]string{
+		targetName(t),
+		rec.StartTime.Format(time.RFC3339Nano),
+		strconv.Itoa(rec.Attempt),
+		strconv.Itoa(rec.StatusCode),
+		strconv.FormatInt(rec.BytesRead, 10),
+		ms(rec.DNS), ms(rec.Connect), ms(rec.TLS), ms(rec.TTFB), ms(rec.Total),
+		class, msg,
+		strings.Join(rec.Failures, "; "),
+	})
+}
+
+func (s *csvSink) Flush(t Target, summary Summary) error {
+	return nil
+}
+
+func (s *csvSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := closeOutput(s.out); err != nil {
+		return fmt.Errorf("csv sink: %w", err)
+	}
+	return nil
+}
+
+// statsdSink sends counters and timings for every probe to a statsd
+// daemon over UDP, named hilicurl.<target>.<metric>.
+type statsdSink struct {
+	conn net.Conn
+}
+
+func newStatsdSink(addr string) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd sink: %w", err)
+	}
+	return &statsdSink{conn: conn}, nil
+}
+
+var statsdUnsafe = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+func (s *statsdSink) Write(t Target, rec Record) error {
+	prefix := "hilicurl." + strings.Trim(statsdUnsafe.ReplaceAllString(targetName(t), "_"), "_") + "."
+	ms := func(d time.Duration) string {
+		return strconv.FormatFloat(*durationMS(d), 'f', 3, 64)
+	}
+
+	lines := []string{prefix + "requests:1|c"}
+	if rec.Err != nil {
+		lines = append(lines, prefix+"errors."+classifyError(rec.Err)+":1|c")
+	}
+	if rec.StatusCode != 0 {
+		lines = append(lines, prefix+"status."+strconv.Itoa(rec.StatusCode)+":1|c")
+	}
+	if rec.Responded {
+		lines = append(lines,
+			prefix+"ttfb:"+ms(rec.TTFB)+"|ms",
+			prefix+"total:"+ms(rec.Total)+"|ms",
+			prefix+"bytes:"+strconv.FormatInt(rec.BytesRead, 10)+"|g")
+	}
+	if len(rec.Failures) > 0 {
+		lines = append(lines, prefix+"assertion_failures:"+strconv.Itoa(len(rec.Failures))+"|c")
+	}
+
+	if _, err := s.conn.Write([]byte(strings.Join(lines, "\n"))); err != nil {
+		return fmt.Errorf("statsd sink: %w", err)
+	}
+	return nil
+}
+
+func (s *statsdSink) Flush(t Target, summary Summary) error {
+	return nil
+}
+
+func (s *statsdSink) Close() error {
+	if err := s.conn.Close(); err != nil {
+		return fmt.Errorf("statsd sink: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSinkErrors(t *testing.T) {
+	tests := []struct {
+		spec, err string
+	}{
+		{"console=out.txt", `sink "console" takes no destination`},
+		{"csv", `sink "csv" needs a destination, e.g. csv=out.csv`},
+		{"xml=out.xml", `unknown sink "xml"`},
+	}
+	for _, tt := range tests {
+		if _, err := newSink(tt.spec); err == nil || err.Error() != tt.err {
+			t.Errorf("newSink(%q) error %v, want %q", tt.spec, err, tt.err)
+		}
+	}
+}
+
+func TestCSVSink(t *testing.T) {
+	var buf bytes.Buffer
+	s, err := newCSVSink(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	target := Target{Name: "api"}
+	s.Write(target, Record{StartTime: start, Attempt: 1, Responded: true, StatusCode: 200, BytesRead: 512,
+		TTFB: 1500 * time.Microsecond, Total: 2 * time.Millisecond, Failures: []string{"a, b", `c "d"`}})
+	s.Write(target, Record{StartTime: start, Attempt: 2, Err: context.DeadlineExceeded, Total: time.Second})
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "target,time,attempt,status,bytes,dns_ms,connect_ms,tls_ms,ttfb_ms,total_ms,error_class,error,failures\n" +
+		`api,2024-01-01T12:00:00Z,1,200,512,0.000,0.000,0.000,1.500,2.000,,,"a, b; c ""d"""` + "\n" +
+		"api,2024-01-01T12:00:00Z,2,0,0,0.000,0.000,0.000,0.000,1000.000,timeout,context deadline exceeded,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("csv\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestStatsdSink(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	s, err := newStatsdSink(pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	tests := []struct {
+		target Target
+		rec    Record
+		want   []string
+	}{
+		{
+			Target{URL: "https://example.com/health"},
+			Record{Responded: true, StatusCode: 200, BytesRead: 42, TTFB: time.Millisecond, Total: 2 * time.Millisecond},
+			[]string{
+				"hilicurl.https_example_com_health.requests:1|c",
+				"hilicurl.https_example_com_health.status.200:1|c",
+				"hilicurl.https_example_com_health.ttfb:1.000|ms",
+				"hilicurl.https_example_com_health.total:2.000|ms",
+				"hilicurl.https_example_com_health.bytes:42|g",
+			},
+		},
+		{
+			Target{Name: "api"},
+			Record{Err: context.DeadlineExceeded},
+			[]string{"hilicurl.api.requests:1|c", "hilicurl.api.errors.timeout:1|c"},
+		},
+		{
+			Target{Name: "api"},
+			Record{Responded: true, StatusCode: 503, Failures: []string{"status=ok: got degraded"}},
+			[]string{
+				"hilicurl.api.requests:1|c",
+				"hilicurl.api.status.503:1|c",
+				"hilicurl.api.ttfb:0.000|ms",
+				"hilicurl.api.total:0.000|ms",
+				"hilicurl.api.bytes:0|g",
+				"hilicurl.api.assertion_failures:1|c",
+			},
+		},
+	}
+	buf := make([]byte, 1024)
+	for _, tt := range tests {
+		if err := s.Write(tt.target, tt.rec); err != nil {
+			t.Fatal(err)
+		}
+		_ = pc.SetReadDeadline(time.Now().Add(time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(buf[:n]), strings.Join(tt.want, "\n"); got != want {
+			t.Errorf("packet\n%s\nwant\n%s", got, want)
+		}
+	}
+}
+
+func TestTimingNote(t *testing.T) {
+	tests := []struct {
+		rec  Record
+		want string
+	}{
+		{Record{TTFB: 1500 * time.Microsecond, Total: 2 * time.Millisecond}, "ttfb=1.5 ms total=2.0 ms"},
+		{Record{Total: 800 * time.Microsecond}, "total=800 µs"},
+	}
+	for _, tt := range tests {
+		if got := timingNote(tt.rec); got != tt.want {
+			t.Errorf("timingNote(%v, %v) = %q, want %q", tt.rec.TTFB, tt.rec.Total, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parseBurnWindows parses the "short,long" argument of -slo-windows.
+func parseBurnWindows(s string) (short, long time.Duration, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid slo windows %q, expected short,long like 5m,1h", s)
+	}
+	if short, err = time.ParseDuration(strings.TrimSpace(parts[0])); err != nil {
+		return 0, 0, err
+	}
+	if long, err = time.ParseDuration(strings.TrimSpace(parts[1])); err != nil {
+		return 0, 0, err
+	}
+	if short <= 0 || long <= short {
+		return 0, 0, fmt.Errorf("invalid slo windows %q, the short window must be positive and below the long one", s)
+	}
+	return short, long, nil
+}
+
+// sloGood reports whether a probe meets the objective: it succeeded and,
+// with a latency objective, was fast enough.
+func (p alertPolicy) sloGood(rec Record) bool {
+	return probeOK(rec) && (p.sloLatency <= 0 || rec.Total <= p.sloLatency)
+}
+
+// burnFailing implements multi-window burn-rate alerting as described in
+// the Google SRE workbook. A target is down when the error budget burns at
+// least burnRate times faster than sustainable over both the long and the
+// short window: the long window makes sure enough budget is at stake, the
+// short one that the problem is still going on. It is up again as soon as
+// the short window burn rate drops below the threshold. Like windowFailing,
+// going down also needs the alert threshold of bad probes.
+//
+// The returned burn rate is that of the long window.
+func (a *alerter) burnFailing(st *alertState, rec Record) (bool, float64) {
+	st.recent = append(st.recent, windowProbe{start: rec.StartTime, ok: a.sloGood(rec)})
+	now := time.Now()
+	cutoff := now.Add(-a.longWindow)
+	for len(st.recent) > 0 && st.recent[0].start.Before(cutoff) {
+		st.recent = st.recent[1:]
+	}
+
+	budget := 1 - a.slo/100
+	burn := func(probes []windowProbe) (rate float64, bad int, firstBad time.Time) {
+		for _, p := range probes {
+			if !p.ok {
+				if bad == 0 {
+					firstBad = p.start
+				}
+				bad++
+			}
+		}
+		if len(probes) == 0 {
+			return 0, 0, firstBad
+		}
+		return float64(bad) / float64(len(probes)) / budget, bad, firstBad
+	}
+
+	shortStart := now.Add(-a.shortWindow)
+	i := 0
+	for i < len(st.recent) && st.recent[i].start.Before(shortStart) {
+		i++
+	}
+	shortRate, shortBad, firstBad := burn(st.recent[i:])
+	longRate, _, _ := burn(st.recent)
+
+	if st.down {
+		return shortRate >= a.burnRate, longRate
+	}
+	st.since, st.failures = firstBad, shortBad
+	return shortBad >= a.after && shortRate >= a.burnRate && longRate >= a.burnRate, longRate
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBurnWindows(t *testing.T) {
+	tests := []struct {
+		s           string
+		short, long time.Duration
+		ok          bool
+	}{
+		{"5m,1h", 5 * time.Minute, time.Hour, true},
+		{" 30s , 6h ", 30 * time.Second, 6 * time.Hour, true},
+		{"5m", 0, 0, false},
+		{"5m,1h,6h", 0, 0, false},
+		{"1h,5m", 0, 0, false},
+		{"0s,1h", 0, 0, false},
+		{"5x,1h", 0, 0, false},
+	}
+	for _, tt := range tests {
+		short, long, err := parseBurnWindows(tt.s)
+		if short != tt.short || long != tt.long || (err == nil) != tt.ok {
+			t.Errorf("parseBurnWindows(%q) = %v, %v, %v, want %v, %v, ok %v", tt.s, short, long, err, tt.short, tt.long, tt.ok)
+		}
+	}
+}
+
+func TestSLOGood(t *testing.T) {
+	ok := Record{Responded: true, StatusCode: 200, Total: 300 * time.Millisecond}
+	policy := alertPolicy{slo: 99.9, sloLatency: 200 * time.Millisecond}
+	if policy.sloGood(ok) {
+		t.Error("slow probe meets the latency objective")
+	}
+	policy.sloLatency = 0
+	if !policy.sloGood(ok) {
+		t.Error("probe fails an objective without latency")
+	}
+	if policy.sloGood(Record{Responded: true, StatusCode: 500}) {
+		t.Error("failed probe meets the objective")
+	}
+}
+
+func TestAlerterBurnRate(t *testing.T) {
+	now := time.Now()
+	// probes returns n probes, 100ms apart, the last one ago before now.
+	probes := func(n int, ok bool, ago time.Duration) []Record {
+		status := 200
+		if !ok {
+			status = 503
+		}
+		var records []Record
+		for i := n - 1; i >= 0; i-- {
+			start := now.Add(-ago - time.Duration(i)*100*time.Millisecond)
+			records = append(records, Record{StartTime: start, Responded: true, StatusCode: status})
+		}
+		return records
+	}
+	concat := func(lists ...[]Record) []Record {
+		var records []Record
+		for _, l := range lists {
+			records = append(records, l...)
+		}
+		return records
+	}
+
+	// With a 90% objective the budget is 10%, so a burn rate of 2 means
+	// 20% of bad probes.
+	s := time.Second
+	tests := []struct {
+		name    string
+		records []Record
+		states  []string
+	}{
+		{"healthy", probes(100, true, 0), nil},
+		{"short blip", concat(probes(100, true, 5*s), probes(5, false, 0)), nil},
+		{"outage", concat(probes(20, true, 10*s), probes(10, false, 0)), []string{stateDown}},
+		// Bad probes that left the short window are over.
+		{"past", concat(probes(10, false, 30*s), probes(10, true, 0)), nil},
+		{"recovered", concat(probes(20, true, 30*s), probes(10, false, 8*s), probes(60, true, 0)), []string{stateDown, stateUp}},
+	}
+	for _, tt := range tests {
+		n := &recordingNotifier{}
+		a := newAlerter(alertPolicy{after: 3, slo: 90, shortWindow: 10 * s, longWindow: time.Minute, burnRate: 2},
+			[]notifier{n})
+		for _, rec := range tt.records {
+			a.Write(Target{}, rec)
+		}
+		a.Close()
+		if got := n.states(); !equalStrings(got, tt.states) {
+			t.Errorf("%s: events %q, want %q", tt.name, got, tt.states)
+			continue
+		}
+		if len(n.events) > 0 && n.events[0].BurnRate < 2 {
+			t.Errorf("%s: down at a burn rate of %v", tt.name, n.events[0].BurnRate)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// sizeBucketCount is how many groups of similar body size the latency table
+// splits responses into.
+const sizeBucketCount = 4
+
+// SizeBucket is the mean latency of responses within a body size range.
+type SizeBucket struct {
+	MinBytes    int64
+	MaxBytes    int64
+	Count       int
+	MeanElapsed time.Duration
+}
+
+// sizeLatency relates body size to response time for successful probes. It
+// returns the Pearson correlation coefficient and a table of responses
+// grouped by size, or nothing when every response had the same size.
+func sizeLatency(records []Record) (float64, []SizeBucket) {
+	var ok []Record
+	for _, rec := range records {
+		if rec.Err == nil && rec.Responded {
+			ok = append(ok, rec)
+		}
+	}
+	if len(ok) < 2 {
+		return 0, nil
+	}
+	sort.Slice(ok, func(i, j int) bool { return ok[i].BytesRead < ok[j].BytesRead })
+	if ok[0].BytesRead == ok[len(ok)-1].BytesRead {
+		return 0, nil
+	}
+
+	var sx, sy, sxx, syy, sxy float64
+	for _, rec := range ok {
+		x, y := float64(rec.BytesRead), float64(rec.ElapsedTime)
+		sx += x
+		sy += y
+		sxx += x * x
+		syy += y * y
+		sxy += x * y
+	}
+	n := float64(len(ok))
+	var corr float64
+	if d := math.Sqrt(n*sxx-sx*sx) * math.Sqrt(n*syy-sy*sy); d > 0 {
+		corr = (n*sxy - sx*sy) / d
+	}
+
+	var buckets []SizeBucket
+	per := (len(ok) + sizeBucketCount - 1) / sizeBucketCount
+	for start := 0; start < len(ok); start += per {
+		end := minInt(start+per, len(ok))
+		b := SizeBucket{MinBytes: ok[start].BytesRead, MaxBytes: ok[end-1].BytesRead, Count: end - start}
+		var total time.Duration
+		for _, rec := range ok[start:end] {
+			total += rec.ElapsedTime
+		}
+		b.MeanElapsed = total / time.Duration(b.Count)
+		buckets = append(buckets, b)
+	}
+	return corr, buckets
+}
+
+func printSizeLatency(corr float64, buckets []SizeBucket) {
+	fmt.Printf("size/latency correlation %.2f\n", corr)
+	for _, b := range buckets {
+		fmt.Printf("  %10s - %-10s %5d responses, mean %s\n",
+			formatBytes(b.MinBytes), formatBytes(b.MaxBytes), b.Count, formatDuration(b.MeanElapsed))
+	}
+}
+
+// trendWindow is the span of each row of the -trend-window latency table;
+// zero disables the table.
+var trendWindow time.Duration
+
+// TrendWindow holds the latency percentiles of the successful probes
+// started within one window of a run.
+type TrendWindow struct {
+	Start  time.Time
+	Probes int
+	Failed int
+	P50    time.Duration
+	P99    time.Duration
+}
+
+// latencyTrend splits a run into consecutive windows from its start, so
+// that a degradation during a long run stands out from the overall
+// distribution. Runs shorter than two windows have no trend.
+func latencyTrend(run Run, window time.Duration) []TrendWindow {
+	if window <= 0 || run.End.Sub(run.Start) < 2*window {
+		return nil
+	}
+	n := int((run.End.Sub(run.Start) + window - 1) / window)
+	trend := make([]TrendWindow, n)
+	latencies := make([][]time.Duration, n)
+	for i := range trend {
+		trend[i].Start = run.Start.Add(time.Duration(i) * window)
+	}
+	for _, rec := range run.Records {
+		i := int(rec.StartTime.Sub(run.Start) / window)
+		if i < 0 || i >= n {
+			continue
+		}
+		trend[i].Probes++
+		if !probeOK(rec) {
+			trend[i].Failed++
+			continue
+		}
+		latencies[i] = append(latencies[i], rec.Total)
+	}
+	for i, l := range latencies {
+		sort.Slice(l, func(a, b int) bool { return l[a] < l[b] })
+		trend[i].P50, trend[i].P99 = percentile(l, 50), percentile(l, 99)
+	}
+	return trend
+}
+
+func printLatencyTrend(trend []TrendWindow) {
+	layout := "15:04:05"
+	if trend[len(trend)-1].Start.Sub(trend[0].Start) >= 24*time.Hour {
+		layout = "Jan _2 15:04"
+	}
+	fmt.Printf("latency trend per %v:\n", trend[1].Start.Sub(trend[0].Start))
+	fmt.Printf("  %-12s %7s %7s %10s %10s\n", "start", "probes", "failed", "p50", "p99")
+	for _, w := range trend {
+		p50, p99 := "-", "-"
+		if w.Probes > w.Failed {
+			p50, p99 = formatDuration(w.P50), formatDuration(w.P99)
+		}
+		fmt.Printf("  %-12s %7d %7d %10s %10s\n", w.Start.Format(layout), w.Probes, w.Failed, p50, p99)
+	}
+}
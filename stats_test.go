@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSizeLatency(t *testing.T) {
+	ms := time.Millisecond
+	rec := func(size int64, elapsed time.Duration) Record {
+		return Record{Responded: true, BytesRead: size, ElapsedTime: elapsed}
+	}
+	tests := []struct {
+		records []Record
+		corr    float64
+		buckets []SizeBucket
+	}{
+		{nil, 0, nil},
+		{[]Record{rec(100, ms), rec(100, 2*ms)}, 0, nil},
+		{
+			[]Record{rec(400, 4*ms), rec(100, ms), rec(300, 3*ms), rec(200, 2*ms), {Err: errors.New("refused")}},
+			1,
+			[]SizeBucket{{100, 100, 1, ms}, {200, 200, 1, 2 * ms}, {300, 300, 1, 3 * ms}, {400, 400, 1, 4 * ms}},
+		},
+		{
+			[]Record{rec(100, 4*ms), rec(200, 2*ms), rec(300, 2*ms), rec(400, 2*ms), rec(500, ms), rec(600, ms)},
+			-0.88,
+			[]SizeBucket{{100, 200, 2, 3 * ms}, {300, 400, 2, 2 * ms}, {500, 600, 2, ms}},
+		},
+	}
+	for i, tt := range tests {
+		corr, buckets := sizeLatency(tt.records)
+		if math.Abs(corr-tt.corr) > 0.01 {
+			t.Errorf("%d: correlation %.3f, want %.2f", i, corr, tt.corr)
+		}
+		if len(buckets) != len(tt.buckets) {
+			t.Errorf("%d: buckets %v, want %v", i, buckets, tt.buckets)
+			continue
+		}
+		for j := range buckets {
+			if buckets[j] != tt.buckets[j] {
+				t.Errorf("%d: bucket %d %v, want %v", i, j, buckets[j], tt.buckets[j])
+			}
+		}
+	}
+}
+
+func TestLatencyTrend(t *testing.T) {
+	t0 := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ms := time.Millisecond
+	probe := func(at time.Duration, total time.Duration, status int) Record {
+		return Record{StartTime: t0.Add(at), Responded: true, StatusCode: status, Total: total}
+	}
+	run := Run{Start: t0, End: t0.Add(25 * time.Minute), Records: []Record{
+		probe(time.Minute, 10*ms, 200),
+		probe(2*time.Minute, 30*ms, 200),
+		probe(3*time.Minute, 20*ms, 500),
+		probe(12*time.Minute, 200*ms, 200),
+		{StartTime: t0.Add(13 * time.Minute), Err: errors.New("connection refused")},
+		// Past the end of the run.
+		probe(31*time.Minute, time.Second, 200),
+	}}
+
+	trend := latencyTrend(run, 10*time.Minute)
+	want := []TrendWindow{
+		{Start: t0, Probes: 3, Failed: 1, P50: 10 * ms, P99: 30 * ms},
+		{Start: t0.Add(10 * time.Minute), Probes: 2, Failed: 1, P50: 200 * ms, P99: 200 * ms},
+		{Start: t0.Add(20 * time.Minute)},
+	}
+	if len(trend) != len(want) {
+		t.Fatalf("trend %+v, want %+v", trend, want)
+	}
+	for i := range want {
+		if trend[i] != want[i] {
+			t.Errorf("window %d: %+v, want %+v", i, trend[i], want[i])
+		}
+	}
+
+	if trend := latencyTrend(run, 15*time.Minute); trend != nil {
+		t.Errorf("trend of a run shorter than two windows: %+v", trend)
+	}
+	if trend := latencyTrend(run, 0); trend != nil {
+		t.Errorf("trend without a window: %+v", trend)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// statusWindow is the number of most recent probes the rolling statistics
+// of the status file cover.
+const statusWindow = 100
+
+// statusWriter keeps the -status-file up to date with the health of every
+// target. The file is replaced atomically after each probe so readers never
+// see a partial document.
+type statusWriter struct {
+	path string
+
+	mu      sync.Mutex
+	order   []string
+	targets map[string]*targetStatus
+}
+
+type statusDocument struct {
+	Updated time.Time       `json:"updated"`
+	Targets []*targetStatus `json:"targets"`
+}
+
+type targetStatus struct {
+	Name          string    `json:"name"`
+	URL           string    `json:"url"`
+	Healthy       bool      `json:"healthy"`
+	LastProbe     time.Time `json:"last_probe"`
+	LastStatus    int       `json:"last_status,omitempty"`
+	LastError     string    `json:"last_error,omitempty"`
+	LastLatencyMS float64   `json:"last_latency_ms"`
+
+	Window statusWindowStats `json:"window"`
+
+	recent []Record
+}
+
+type statusWindowStats struct {
+	Probes        int     `json:"probes"`
+	Failures      int     `json:"failures"`
+	SuccessRate   float64 `json:"success_rate"`
+	MeanLatencyMS float64 `json:"mean_latency_ms"`
+	MaxLatencyMS  float64 `json:"max_latency_ms"`
+}
+
+func newStatusWriter(path string) *statusWriter {
+	return &statusWriter{path: path, targets: make(map[string]*targetStatus)}
+}
+
+// probeOK reports whether a probe got a successful (below 400 for HTTP)
+// response that passed all assertions.
+func probeOK(rec Record) bool {
+	return rec.Err == nil && rec.Responded && rec.StatusCode < 400 && len(rec.Failures) == 0
+}
+
+// Write records the outcome of a probe and rewrites the status file.
+func (w *statusWriter) Write(t Target, rec Record) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	name := targetName(t)
+	ts, ok := w.targets[name]
+	if !ok {
+		ts = &targetStatus{Name: name, URL: t.URL}
+		w.targets[name] = ts
+		w.order = append(w.order, name)
+	}
+
+	ts.Healthy = probeOK(rec)
+	ts.LastProbe = rec.StartTime
+	ts.LastStatus, ts.LastError = rec.StatusCode, ""
+	if rec.Err != nil {
+		ts.LastError = rec.Err.Error()
+	}
+	ts.LastLatencyMS = *durationMS(rec.ElapsedTime)
+
+	ts.recent = append(ts.recent, rec)
+	if len(ts.recent) > statusWindow {
+		ts.recent = ts.recent[len(ts.recent)-statusWindow:]
+	}
+	ts.Window = windowStats(ts.recent)
+
+	doc := statusDocument{Updated: time.Now()}
+	for _, name := range w.order {
+		doc.Targets = append(doc.Targets, w.targets[name])
+	}
+	if err := writeFileAtomic(w.path, doc); err != nil {
+		return fmt.Errorf("status file: %w", err)
+	}
+	return nil
+}
+
+func (w *statusWriter) Flush(t Target, s Summary) error {
+	return nil
+}
+
+func windowStats(records []Record) statusWindowStats {
+	var s statusWindowStats
+	var total, longest time.Duration
+	var ok int
+	for _, rec := range records {
+		s.Probes++
+		if !probeOK(rec) {
+			s.Failures++
+			continue
+		}
+		ok++
+		total += rec.ElapsedTime
+		if rec.ElapsedTime > longest {
+			longest = rec.ElapsedTime
+		}
+	}
+	if s.Probes > 0 {
+		s.SuccessRate = float64(s.Probes-s.Failures) / float64(s.Probes) * 100
+	}
+	if ok > 0 {
+		s.MeanLatencyMS = *durationMS(total / time.Duration(ok))
+	}
+	s.MaxLatencyMS = *durationMS(longest)
+	return s
+}
+
+// writeFileAtomic writes v as JSON to a temporary file next to path and
+// renames it into place.
+func writeFileAtomic(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// webhookMaxDelay bounds how long a probe waits in a partial batch before
+// it is sent.
+const webhookMaxDelay = 10 * time.Second
+
+// resultWebhook POSTs every probe to a URL as a JSON probe event, like
+// those of -progress-fd. With a batch size above one, the events are sent
+// as a JSON array once the batch is full, after webhookMaxDelay or at the
+// end of the run, whichever comes first.
+type resultWebhook struct {
+	url   string
+	batch int
+
+	mu      sync.Mutex
+	pending []progressEvent
+	timer   *time.Timer
+}
+
+func (w *resultWebhook) Write(t Target, rec Record) error {
+	ev := probeEvent(t, rec)
+	if w.batch <= 1 {
+		return w.post(ev)
+	}
+
+	w.mu.Lock()
+	w.pending = append(w.pending, ev)
+	full := len(w.pending) >= w.batch
+	if !full && w.timer == nil {
+		w.timer = time.AfterFunc(webhookMaxDelay, func() {
+			if err := w.send(); err != nil {
+				log.Printf("ERROR: %v", err)
+			}
+		})
+	}
+	w.mu.Unlock()
+	if full {
+		return w.send()
+	}
+	return nil
+}
+
+// send posts the pending batch.
+func (w *resultWebhook) send() error {
+	w.mu.Lock()
+	events := w.pending
+	w.pending = nil
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	w.mu.Unlock()
+	if len(events) == 0 {
+		return nil
+	}
+	return w.post(events)
+}
+
+func (w *resultWebhook) post(v interface{}) error {
+	if err := postJSON(w.url, nil, v); err != nil {
+		return fmt.Errorf("result webhook: %w", err)
+	}
+	return nil
+}
+
+func (w *resultWebhook) Flush(t Target, s Summary) error {
+	return w.send()
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResultWebhook(t *testing.T) {
+	posts := make(chan json.RawMessage, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		posts <- body
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		batch, probes int
+		// posts holds the number of events of each post, 0 for a single
+		// event object.
+		posts []int
+	}{
+		{1, 2, []int{0, 0}},
+		{2, 5, []int{2, 2, 1}},
+		{10, 3, []int{3}},
+	}
+	target := Target{Name: "api"}
+	for _, tt := range tests {
+		w := &resultWebhook{url: srv.URL, batch: tt.batch}
+		for i := 1; i <= tt.probes; i++ {
+			if err := w.Write(target, Record{Attempt: i, Responded: true, StatusCode: 200}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Flush(target, Summary{}); err != nil {
+			t.Fatal(err)
+		}
+		got := len(posts)
+		if got != len(tt.posts) {
+			t.Errorf("batch %d: %d posts, want %d", tt.batch, got, len(tt.posts))
+			for ; got > 0; got-- {
+				<-posts
+			}
+			continue
+		}
+		attempt := 1.0
+		for _, n := range tt.posts {
+			var events []map[string]interface{}
+			body := <-posts
+			if n == 0 {
+				var ev map[string]interface{}
+				json.Unmarshal(body, &ev)
+				events = append(events, ev)
+			} else if err := json.Unmarshal(body, &events); err != nil || len(events) != n {
+				t.Errorf("batch %d: post %s, want %d events", tt.batch, body, n)
+				continue
+			}
+			for _, ev := range events {
+				if ev["event"] != "probe" || ev["target"] != "api" || ev["attempt"] != attempt {
+					t.Errorf("batch %d: event %v, want probe %v of api", tt.batch, ev, attempt)
+				}
+				attempt++
+			}
+		}
+	}
+}
+
+func TestResultWebhookError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	w := &resultWebhook{url: srv.URL, batch: 1}
+	if err := w.Write(Target{}, Record{}); err == nil {
+		t.Error("failed post not reported")
+	}
+}
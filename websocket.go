@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// webSocketGUID is appended to the handshake key to compute the accept
+// value, as defined by RFC 6455.
+const webSocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// webSocketProber completes the opening handshake of a WebSocket endpoint
+// and closes the connection again.
+type webSocketProber struct {
+	t      Target
+	logger *log.Logger
+	client *http.Client
+}
+
+func (p *webSocketProber) Probe(ctx context.Context) Record {
+	s := startProbe()
+
+	rawURL := p.t.URL
+	switch {
+	case strings.HasPrefix(rawURL, "ws://"):
+		rawURL = "http://" + strings.TrimPrefix(rawURL, "ws://")
+	case strings.HasPrefix(rawURL, "wss://"):
+		rawURL = "https://" + strings.TrimPrefix(rawURL, "wss://")
+	}
+
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return s.fail(err)
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+
+	req, err := s.newRequest(ctx, p.logger, p.t, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return s.fail(err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", key)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return s.fail(err)
+	}
+	defer res.Body.Close()
+	s.response(res)
+	// A failed handshake is not a response, although the server answered
+	// at the HTTP level.
+	handshakeFailed := func(err error) Record {
+		s.rec.Responded = false
+		return s.fail(err)
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		return handshakeFailed(fmt.Errorf("websocket: upgrade refused: %s", res.Status))
+	}
+	if res.Header.Get("Sec-WebSocket-Accept") != webSocketAccept(key) {
+		return handshakeFailed(fmt.Errorf("websocket: invalid Sec-WebSocket-Accept header"))
+	}
+
+	// Say goodbye with a normal closure frame before dropping the
+	// connection.
+	if conn, ok := res.Body.(io.Writer); ok {
+		_, _ = conn.Write(webSocketCloseFrame())
+	}
+	return s.done(p.t)
+}
+
+func webSocketAccept(key string) string {
+	sum := sha1.Sum([]byte(key + webSocketGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// webSocketCloseFrame builds a masked close frame with status 1000, as
+// client frames must be masked.
+func webSocketCloseFrame() []byte {
+	frame := []byte{0x88, 0x82, 0, 0, 0, 0, 0x03, 0xe8}
+	_, _ = rand.Read(frame[2:6])
+	for i := 0; i < 2; i++ {
+		frame[6+i] ^= frame[2+i%4]
+	}
+	return frame
+}
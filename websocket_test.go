@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebSocketAccept(t *testing.T) {
+	// The example handshake of RFC 6455 section 1.3.
+	if got, want := webSocketAccept("dGhlIHNhbXBsZSBub25jZQ=="), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo="; got != want {
+		t.Errorf("webSocketAccept = %q, want %q", got, want)
+	}
+}
+
+func TestWebSocketProbe(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		accept func(key string) string
+		ok     bool
+	}{
+		{"upgraded", http.StatusSwitchingProtocols, webSocketAccept, true},
+		{"refused", http.StatusOK, webSocketAccept, false},
+		{"bad accept", http.StatusSwitchingProtocols, func(string) string { return "x" }, false},
+	}
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.status != http.StatusSwitchingProtocols {
+				w.WriteHeader(tt.status)
+				return
+			}
+			conn, buf, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+				"Sec-WebSocket-Accept: " + tt.accept(r.Header.Get("Sec-WebSocket-Key")) + "\r\n\r\n")
+			buf.Flush()
+			ioutil.ReadAll(conn)
+		}))
+		target := Target{URL: "ws://" + strings.TrimPrefix(srv.URL, "http://"), Mode: modeWebSocket,
+			Timeout: Duration(5 * time.Second)}
+		prober, _ := newProber(target, log.New(ioutil.Discard, "", 0))
+		rec := prober.Probe(context.Background())
+		srv.Close()
+
+		if (rec.Err == nil) != tt.ok || rec.Responded != tt.ok {
+			t.Errorf("%s: error %v, responded %v, want ok %v", tt.name, rec.Err, rec.Responded, tt.ok)
+		}
+	}
+}
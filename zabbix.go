@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+const zabbixTimeout = 5 * time.Second
+
+// zabbixSender pushes probe results to a Zabbix server or proxy using the
+// Zabbix sender protocol. The items must exist as trapper items on the
+// Zabbix host.
+type zabbixSender struct {
+	addr      string
+	host      string
+	key       string
+	statusKey string
+	// perTarget appends the target name as a key parameter, e.g.
+	// http.latency[api], so several targets can report to one host.
+	perTarget bool
+}
+
+type zabbixItem struct {
+	Host  string `json:"host"`
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	Clock int64  `json:"clock"`
+}
+
+type zabbixResponse struct {
+	Response string `json:"response"`
+	Info     string `json:"info"`
+}
+
+// Write reports the latency of a successful probe and, with a status key,
+// whether the probe succeeded.
+func (z *zabbixSender) Write(t Target, rec Record) error {
+	clock := rec.StartTime.Unix()
+	var items []zabbixItem
+	if probeOK(rec) {
+		items = append(items, zabbixItem{
+			Host:  z.host,
+			Key:   z.itemKey(z.key, t),
+			Value: fmt.Sprintf("%.6f", rec.ElapsedTime.Seconds()),
+			Clock: clock,
+		})
+	}
+	if z.statusKey != "" {
+		up := "0"
+		if probeOK(rec) {
+			up = "1"
+		}
+		items = append(items, zabbixItem{Host: z.host, Key: z.itemKey(z.statusKey, t), Value: up, Clock: clock})
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	if err := z.push(items); err != nil {
+		return fmt.Errorf("zabbix: %w", err)
+	}
+	return nil
+}
+
+func (z *zabbixSender) Flush(t Target, s Summary) error {
+	return nil
+}
+
+func (z *zabbixSender) itemKey(key string, t Target) string {
+	if !z.perTarget {
+		return key
+	}
+	return fmt.Sprintf("%s[%q]", key, targetName(t))
+}
+
+func (z *zabbixSender) push(items []zabbixItem) error {
+	data, err := json.Marshal(struct {
+		Request string       `json:"request"`
+		Data    []zabbixItem `json:"data"`
+	}{"sender data", items})
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", z.addr, zabbixTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(zabbixTimeout))
+
+	if _, err := conn.Write(zabbixPacket(data)); err != nil {
+		return err
+	}
+
+	reply, err := readZabbixPacket(conn)
+	if err != nil {
+		return err
+	}
+	var res zabbixResponse
+	if err := json.Unmarshal(reply, &res); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	if res.Response != "success" || (strings.Contains(res.Info, "failed: ") && !strings.Contains(res.Info, "failed: 0;")) {
+		return fmt.Errorf("%s %s", res.Response, res.Info)
+	}
+	return nil
+}
+
+// zabbixPacket frames data with the "ZBXD" header, protocol flags and the
+// little-endian data length.
+func zabbixPacket(data []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("ZBXD\x01")
+	_ = binary.Write(&buf, binary.LittleEndian, uint64(len(data)))
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+func readZabbixPacket(r io.Reader) ([]byte, error) {
+	header := make([]byte, 13)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if string(header[:4]) != "ZBXD" {
+		return nil, errors.New("invalid response header")
+	}
+	n := binary.LittleEndian.Uint32(header[5:9])
+	if n > 1<<20 {
+		return nil, errors.New("response too large")
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	return data, nil
+}
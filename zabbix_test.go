@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestZabbixPacket(t *testing.T) {
+	data := []byte(`{"request":"sender data"}`)
+	packet := zabbixPacket(data)
+	if want := "ZBXD\x01\x19\x00\x00\x00\x00\x00\x00\x00"; string(packet[:13]) != want {
+		t.Errorf("header %q, want %q", packet[:13], want)
+	}
+	got, err := readZabbixPacket(bytes.NewReader(packet))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("readZabbixPacket = %q, %v, want %q", got, err, data)
+	}
+
+	tests := []struct {
+		packet string
+		err    string
+	}{
+		{"ZBXD\x01", "reading response"},
+		{"HTTP/1.1 400 Bad Request\r\n", "invalid response header"},
+		{"ZBXD\x01\x00\x00\x20\x00\x00\x00\x00\x00", "response too large"},
+		{"ZBXD\x01\x05\x00\x00\x00\x00\x00\x00\x00abc", "reading response"},
+	}
+	for _, tt := range tests {
+		if _, err := readZabbixPacket(strings.NewReader(tt.packet)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("readZabbixPacket(%q) error %v, want %q", tt.packet, err, tt.err)
+		}
+	}
+}
+
+// zabbixServer accepts one sender connection per reply, records the
+// items sent and answers with reply.
+func zabbixServer(t *testing.T, replies ...string) (string, <-chan []zabbixItem) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	items := make(chan []zabbixItem, len(replies))
+	go func() {
+		for _, reply := range replies {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			var req struct{ Data []zabbixItem }
+			data, err := readZabbixPacket(conn)
+			if err == nil {
+				err = json.Unmarshal(data, &req)
+			}
+			if err != nil {
+				t.Error(err)
+			}
+			items <- req.Data
+			conn.Write(zabbixPacket([]byte(reply)))
+			conn.Close()
+		}
+	}()
+	return ln.Addr().String(), items
+}
+
+func TestZabbixSender(t *testing.T) {
+	addr, items := zabbixServer(t,
+		`{"response":"success","info":"processed: 2; failed: 0; total: 2"}`,
+		`{"response":"success","info":"processed: 0; failed: 1; total: 1"}`,
+	)
+	z := &zabbixSender{addr: addr, host: "web01", key: "http.latency", statusKey: "http.up", perTarget: true}
+	target := Target{Name: "api"}
+	start := time.Unix(1700000000, 0)
+
+	if err := z.Write(target, Record{StartTime: start, Responded: true, StatusCode: 200, ElapsedTime: 250 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	want := []zabbixItem{
+		{Host: "web01", Key: `http.latency["api"]`, Value: "0.250000", Clock: 1700000000},
+		{Host: "web01", Key: `http.up["api"]`, Value: "1", Clock: 1700000000},
+	}
+	if got := <-items; len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("items %+v, want %+v", got, want)
+	}
+
+	err := z.Write(target, Record{StartTime: start, Responded: true, StatusCode: 503})
+	if err == nil || !strings.Contains(err.Error(), "failed: 1") {
+		t.Errorf("error %v, want the failed item reported", err)
+	}
+	if got := <-items; len(got) != 1 || got[0].Key != `http.up["api"]` || got[0].Value != "0" {
+		t.Errorf("items %+v, want only the status down", got)
+	}
+}